@@ -0,0 +1,58 @@
+package httpjail
+
+import (
+	"net/http"
+	"time"
+)
+
+// accountIPs records the distinct IPs an account used and when each was last seen, returning how many were seen
+// since the provided time. IPs not seen since then are forgotten.
+func (j *Jail) accountIPs(account, ip string, now, since time.Time) int {
+	j.mux.Lock()
+	defer j.mux.Unlock()
+
+	if j.accounts == nil {
+		j.accounts = make(map[string]map[string]time.Time)
+	}
+	ips := j.accounts[account]
+	if ips == nil {
+		ips = make(map[string]time.Time)
+		j.accounts[account] = ips
+	}
+	ips[ip] = now
+
+	for seenIP, seen := range ips {
+		if seen.Before(since) {
+			delete(ips, seenIP)
+		}
+	}
+	return len(ips)
+}
+
+// pruneAccounts forgets account IPs last seen before the provided time, and accounts left with none
+func (j *Jail) pruneAccounts(before time.Time) {
+	j.mux.Lock()
+	defer j.mux.Unlock()
+	for account, ips := range j.accounts {
+		for ip, seen := range ips {
+			if seen.Before(before) {
+				delete(ips, ip)
+			}
+		}
+		if len(ips) == 0 {
+			delete(j.accounts, account)
+		}
+	}
+}
+
+// isSharedAccount reports whether the request's account has been used from more than MaxAccountIPs distinct IPs in
+// the window, recording the count on the decision
+func (j *Jail) isSharedAccount(req *http.Request, decision *Decision, window time.Duration) bool {
+	account := j.AccountFunc(req)
+	if account == "" {
+		return false
+	}
+
+	decision.AccountIPs = j.accountIPs(account, KeyByIP(req), decision.Time, decision.Time.Add(-window))
+	return decision.AccountIPs > j.MaxAccountIPs
+}
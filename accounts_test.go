@@ -0,0 +1,84 @@
+package httpjail
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMaxAccountIPs(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	jail := NewJailForTesting(clock, time.Minute, 0, 100)
+	jail.KeyFunc = KeyByUser(KeyByHeader("X-User"), PerUser)
+	jail.AccountFunc = KeyByHeader("X-User")
+	jail.MaxAccountIPs = 3
+
+	var decision Decision
+	jail.OnDecision = func(d Decision) {
+		decision = d
+	}
+
+	request := func(user, ip string) bool {
+		req := makeRequest(ip, false)
+		req.Header.Set("X-User", user)
+		return serveJail(jail, req)
+	}
+
+	for i := 0; i < 3; i++ {
+		if !request("shared", fmt.Sprintf("10.0.0.%d", i)) {
+			t.Logf("request from IP %d denied, under the threshold", i)
+			t.Fail()
+		}
+	}
+	// repeat visits from a known IP don't count as new
+	if !request("shared", "10.0.0.0") {
+		t.Log("request from a known IP denied")
+		t.Fail()
+	}
+
+	if request("shared", "10.0.0.3") {
+		t.Log("request from a fourth IP allowed, account should be flagged")
+		t.Fail()
+	}
+	if decision.AccountIPs != 4 {
+		t.Logf("decision counted %d IPs, expected 4", decision.AccountIPs)
+		t.Fail()
+	}
+
+	// other accounts and anonymous requests are unaffected
+	if !request("honest", "10.0.0.3") || !serveJail(jail, makeRequest("10.0.0.9", false)) {
+		t.Log("unrelated request denied")
+		t.Fail()
+	}
+
+	// IPs age out with the window
+	clock.Advance(2 * time.Minute)
+	if !request("shared", "10.0.0.5") {
+		t.Log("request denied after the window, old IPs should be forgotten")
+		t.Fail()
+	}
+}
+
+func TestCleanupPrunesAccounts(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	jail := NewJailForTesting(clock, time.Minute, 0, 100)
+
+	jail.accountIPs("stale", "10.0.0.1", clock.Now(), clock.Now().Add(-time.Minute))
+	clock.Advance(30 * time.Second)
+	jail.accountIPs("active", "10.0.0.1", clock.Now(), clock.Now().Add(-time.Minute))
+	clock.Advance(45 * time.Second)
+	jail.Cleanup()
+
+	jail.mux.Lock()
+	_, stale := jail.accounts["stale"]
+	_, active := jail.accounts["active"]
+	jail.mux.Unlock()
+	if stale {
+		t.Log("account unseen for over a window survived cleanup")
+		t.Fail()
+	}
+	if !active {
+		t.Log("account seen within the window pruned by cleanup")
+		t.Fail()
+	}
+}
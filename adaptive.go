@@ -0,0 +1,67 @@
+package httpjail
+
+import "time"
+
+// latencyWeight is how much each new observation moves a visitor's average latency
+const latencyWeight = 0.3
+
+// defaultSlowLimitFactor is the fraction of the limit slow visitors get when SlowLimitFactor isn't set
+const defaultSlowLimitFactor = 0.5
+
+// visitorLatency is a visitor's moving average handler latency
+type visitorLatency struct {
+	average time.Duration
+	// when the latest observation was folded in
+	observed time.Time
+}
+
+// observeLatency folds a handler latency into the visitor's moving average
+func (j *Jail) observeLatency(key string, latency time.Duration, now time.Time) {
+	j.mux.Lock()
+	defer j.mux.Unlock()
+
+	if j.latencies == nil {
+		j.latencies = make(map[string]visitorLatency)
+	}
+
+	observed, ok := j.latencies[key]
+	if !ok {
+		j.latencies[key] = visitorLatency{average: latency, observed: now}
+		return
+	}
+	average := observed.average + time.Duration(latencyWeight*float64(latency-observed.average))
+	j.latencies[key] = visitorLatency{average: average, observed: now}
+}
+
+// pruneLatencies forgets the average latency of visitors last observed before the provided time
+func (j *Jail) pruneLatencies(before time.Time) {
+	j.mux.Lock()
+	defer j.mux.Unlock()
+	for key, observed := range j.latencies {
+		if observed.observed.Before(before) {
+			delete(j.latencies, key)
+		}
+	}
+}
+
+// isSlowVisitor reports whether the visitor's average handler latency exceeds SlowRequest
+func (j *Jail) isSlowVisitor(key string) bool {
+	j.mux.Lock()
+	observed, ok := j.latencies[key]
+	j.mux.Unlock()
+	return ok && observed.average > j.SlowRequest
+}
+
+// slowLimit tightens an allowed request count for a slow visitor, always allowing at least one request
+func (j *Jail) slowLimit(allowed int) int {
+	factor := j.SlowLimitFactor
+	if factor <= 0 {
+		factor = defaultSlowLimitFactor
+	}
+
+	tightened := int(float64(allowed) * factor)
+	if tightened < 1 {
+		return 1
+	}
+	return tightened
+}
@@ -0,0 +1,72 @@
+package httpjail
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveSlowVisitors(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	jail := NewJailForTesting(clock, time.Minute, 0, 10)
+	jail.SlowRequest = 500 * time.Millisecond
+
+	handler := jail.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == "/slow" {
+			clock.Advance(2 * time.Second)
+		}
+		w.Write([]byte(successRes))
+	}))
+
+	// count how many requests each client gets through before the first block
+	allowedBeforeBlock := func(ip, path string) int {
+		for i := 0; i < 20; i++ {
+			req := httptest.NewRequest("GET", path, nil)
+			req.RemoteAddr = ip
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			if rec.Body.String() != successRes {
+				return i
+			}
+		}
+		return 20
+	}
+
+	slow := allowedBeforeBlock("1.1.1.1", "/slow")
+	fast := allowedBeforeBlock("2.2.2.2", "/fast")
+
+	if fast != 10 {
+		t.Logf("fast client allowed %d requests, expected the full limit of %d", fast, 10)
+		t.Fail()
+	}
+	if slow >= fast {
+		t.Logf("slow client allowed %d requests, expected fewer than the fast client's %d", slow, fast)
+		t.Fail()
+	}
+	if slow != 5 {
+		t.Logf("slow client allowed %d requests, expected half the limit", slow)
+		t.Fail()
+	}
+}
+
+func TestCleanupPrunesLatencies(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	jail := NewJailForTesting(clock, time.Minute, 0, 10)
+	jail.SlowRequest = 500 * time.Millisecond
+
+	jail.observeLatency("1.1.1.1", time.Second, clock.Now())
+	clock.Advance(30 * time.Second)
+	jail.observeLatency("2.2.2.2", time.Second, clock.Now())
+	clock.Advance(45 * time.Second)
+	jail.Cleanup()
+
+	if jail.isSlowVisitor("1.1.1.1") {
+		t.Log("latency unobserved for over a window survived cleanup")
+		t.Fail()
+	}
+	if !jail.isSlowVisitor("2.2.2.2") {
+		t.Log("latency observed within the window pruned by cleanup")
+		t.Fail()
+	}
+}
@@ -0,0 +1,144 @@
+package httpjail
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Status summarizes a jail's configuration and state
+type Status struct {
+	Disabled        bool          `json:"disabled"`
+	AllowedRequests int           `json:"allowed_requests"`
+	Window          time.Duration `json:"window"`
+	Cooloff         time.Duration `json:"cooloff"`
+	Sentences       int           `json:"sentences"`
+}
+
+// Status returns a summary of the jail's configuration and state
+func (j *Jail) Status() Status {
+	j.sentenceMux.RLock()
+	sentences := len(j.Sentences)
+	j.sentenceMux.RUnlock()
+
+	j.limitsMux.RLock()
+	defer j.limitsMux.RUnlock()
+	return Status{
+		Disabled:        j.Disabled(),
+		AllowedRequests: j.AllowedRequests,
+		Window:          j.Window,
+		Cooloff:         j.Cooloff,
+		Sentences:       sentences,
+	}
+}
+
+// AdminHandler returns an http.Handler exposing jail controls. It has no authentication of its own, so mount it
+// behind auth on an internal port, stripping any path prefix:
+//
+//	GET  /status                   jail status as JSON
+//	POST /disable                  switch off limiting
+//	POST /enable                   switch limiting back on
+//	POST /reset?key=KEY            release a visitor and clear its history
+//	POST /ban?key=KEY&duration=1h  sentence a visitor for a duration
+//	POST /pardon?key=KEY           release a visitor, keeping its history
+//	GET  /explain?key=KEY          explain why a visitor is or isn't blocked
+//	GET  /sentences                export sentences as JSON
+//	POST /sentences                import sentences exported from GET /sentences
+func (j *Jail) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/status", adminMethod(http.MethodGet, func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, j.Status())
+	}))
+
+	mux.HandleFunc("/disable", adminMethod(http.MethodPost, func(w http.ResponseWriter, req *http.Request) {
+		j.Disable()
+		writeJSON(w, j.Status())
+	}))
+
+	mux.HandleFunc("/enable", adminMethod(http.MethodPost, func(w http.ResponseWriter, req *http.Request) {
+		j.Enable()
+		writeJSON(w, j.Status())
+	}))
+
+	mux.HandleFunc("/reset", adminMethod(http.MethodPost, func(w http.ResponseWriter, req *http.Request) {
+		key := req.URL.Query().Get("key")
+		if key == "" {
+			http.Error(w, "missing key", http.StatusBadRequest)
+			return
+		}
+		j.Reset(key)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	mux.HandleFunc("/ban", adminMethod(http.MethodPost, func(w http.ResponseWriter, req *http.Request) {
+		key := req.URL.Query().Get("key")
+		if key == "" {
+			http.Error(w, "missing key", http.StatusBadRequest)
+			return
+		}
+		d, err := time.ParseDuration(req.URL.Query().Get("duration"))
+		if err != nil || d <= 0 {
+			http.Error(w, "invalid duration", http.StatusBadRequest)
+			return
+		}
+		j.Ban(key, d)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	mux.HandleFunc("/pardon", adminMethod(http.MethodPost, func(w http.ResponseWriter, req *http.Request) {
+		key := req.URL.Query().Get("key")
+		if key == "" {
+			http.Error(w, "missing key", http.StatusBadRequest)
+			return
+		}
+		j.Pardon(key)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	mux.HandleFunc("/explain", adminMethod(http.MethodGet, func(w http.ResponseWriter, req *http.Request) {
+		key := req.URL.Query().Get("key")
+		if key == "" {
+			http.Error(w, "missing key", http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, j.Explain(key))
+	}))
+
+	mux.HandleFunc("/sentences", func(w http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodGet:
+			w.Header().Set("Content-Type", "application/json")
+			j.SaveSentences(w)
+		case http.MethodPost:
+			if err := j.LoadSentences(req.Body); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		}
+	})
+
+	return mux
+}
+
+// adminMethod restricts an admin endpoint to one HTTP method
+func adminMethod(method string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != method {
+			w.Header().Set("Allow", method)
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		handler(w, req)
+	}
+}
+
+// writeJSON writes v as a JSON response
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,245 @@
+package httpjail
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// adminRequest sends a request to the jail's admin handler
+func adminRequest(jail *Jail, method, target, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	jail.AdminHandler().ServeHTTP(rec, httptest.NewRequest(method, target, strings.NewReader(body)))
+	return rec
+}
+
+func TestAdminStatus(t *testing.T) {
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, time.Hour, 1)
+	serveJail(jail, makeRequest("1.2.3.4", false))
+	serveJail(jail, makeRequest("1.2.3.4", false))
+
+	rec := adminRequest(jail, "GET", "/status", "")
+	var status Status
+	if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if status.Sentences != 1 || status.AllowedRequests != 1 || status.Window != time.Minute || status.Disabled {
+		t.Logf("incorrect status: %#v", status)
+		t.Fail()
+	}
+
+	if rec := adminRequest(jail, "POST", "/status", ""); rec.Code != http.StatusMethodNotAllowed {
+		t.Logf("POST /status: got status %d", rec.Code)
+		t.Fail()
+	}
+}
+
+func TestAdminDisableEnable(t *testing.T) {
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, 0, 1)
+
+	adminRequest(jail, "POST", "/disable", "")
+	if !jail.Disabled() {
+		t.Log("POST /disable did not disable the jail")
+		t.Fail()
+	}
+
+	adminRequest(jail, "POST", "/enable", "")
+	if jail.Disabled() {
+		t.Log("POST /enable did not enable the jail")
+		t.Fail()
+	}
+}
+
+func TestAdminReset(t *testing.T) {
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, time.Hour, 1)
+	serveJail(jail, makeRequest("1.2.3.4", false))
+	serveJail(jail, makeRequest("1.2.3.4", false))
+
+	if rec := adminRequest(jail, "POST", "/reset", ""); rec.Code != http.StatusBadRequest {
+		t.Logf("reset without a key: got status %d", rec.Code)
+		t.Fail()
+	}
+
+	if rec := adminRequest(jail, "POST", "/reset?key=1.2.3.4", ""); rec.Code != http.StatusNoContent {
+		t.Logf("reset: got status %d", rec.Code)
+		t.Fail()
+	}
+
+	if !serveJail(jail, makeRequest("1.2.3.4", false)) {
+		t.Log("reset visitor still blocked")
+		t.Fail()
+	}
+}
+
+func TestAdminBanPardon(t *testing.T) {
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, time.Hour, 100)
+
+	for _, target := range []string{"/ban?duration=1h", "/ban?key=1.2.3.4", "/ban?key=1.2.3.4&duration=-1h", "/pardon"} {
+		if rec := adminRequest(jail, "POST", target, ""); rec.Code != http.StatusBadRequest {
+			t.Logf("POST %s: got status %d", target, rec.Code)
+			t.Fail()
+		}
+	}
+
+	if rec := adminRequest(jail, "POST", "/ban?key=1.2.3.4&duration=1h", ""); rec.Code != http.StatusNoContent {
+		t.Logf("ban: got status %d", rec.Code)
+		t.Fail()
+	}
+	if serveJail(jail, makeRequest("1.2.3.4", false)) {
+		t.Log("banned visitor allowed")
+		t.Fail()
+	}
+
+	if rec := adminRequest(jail, "POST", "/pardon?key=1.2.3.4", ""); rec.Code != http.StatusNoContent {
+		t.Logf("pardon: got status %d", rec.Code)
+		t.Fail()
+	}
+	if !serveJail(jail, makeRequest("1.2.3.4", false)) {
+		t.Log("pardoned visitor still blocked")
+		t.Fail()
+	}
+}
+
+func TestAdminSentences(t *testing.T) {
+	source := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, time.Hour, 1)
+	serveJail(source, makeRequest("1.2.3.4", false))
+	serveJail(source, makeRequest("1.2.3.4", false))
+
+	exported := adminRequest(source, "GET", "/sentences", "")
+	if exported.Code != http.StatusOK {
+		t.Logf("export: got status %d", exported.Code)
+		t.FailNow()
+	}
+
+	destination := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, time.Hour, 1)
+	if rec := adminRequest(destination, "POST", "/sentences", exported.Body.String()); rec.Code != http.StatusNoContent {
+		t.Logf("import: got status %d", rec.Code)
+		t.Fail()
+	}
+
+	if serveJail(destination, makeRequest("1.2.3.4", false)) {
+		t.Log("imported sentence did not block")
+		t.Fail()
+	}
+
+	if rec := adminRequest(destination, "POST", "/sentences", "not json"); rec.Code != http.StatusBadRequest {
+		t.Logf("invalid import: got status %d", rec.Code)
+		t.Fail()
+	}
+}
+
+func TestExplain(t *testing.T) {
+	clock := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	jail := NewJailForTesting(clock, time.Minute, time.Hour, 2)
+	jail.Routes = []RouteLimit{
+		{Method: "POST", Path: "/login", Limit: Limit{AllowedRequests: 1, Window: time.Minute, Cooloff: 10 * time.Minute}},
+	}
+
+	for i := 0; i < 3; i++ {
+		serveJail(jail, makeRequest("1.2.3.4", false))
+	}
+	serveJail(jail, makeRequest("5.6.7.8", false))
+	login := httptest.NewRequest("POST", "/login", nil)
+	login.RemoteAddr = "9.9.9.9"
+	serveJail(jail, login)
+
+	explained := jail.Explain("1.2.3.4")
+	if !explained.Blocked || !explained.Sentenced || explained.Rule != "default" || explained.Count != 3 ||
+		explained.Remaining != 0 || !explained.Release.Equal(clock.Now().Add(time.Hour)) ||
+		explained.Limit.AllowedRequests != 2 {
+		t.Logf("blocked client explained as %+v", explained)
+		t.Fail()
+	}
+
+	explained = jail.Explain("5.6.7.8")
+	if explained.Blocked || explained.Sentenced || explained.Count != 1 || explained.Remaining != 1 {
+		t.Logf("allowed client explained as %+v", explained)
+		t.Fail()
+	}
+
+	// route buckets are explained against their route's limit
+	explained = jail.Explain("route:POST /login|9.9.9.9")
+	if explained.Rule != "route:POST /login" || explained.Limit.AllowedRequests != 1 || !explained.Blocked ||
+		explained.Sentenced {
+		t.Logf("route client explained as %+v", explained)
+		t.Fail()
+	}
+
+	rec := adminRequest(jail, "GET", "/explain?key=1.2.3.4", "")
+	var fromAdmin Explanation
+	if err := json.NewDecoder(rec.Body).Decode(&fromAdmin); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if !fromAdmin.Blocked || fromAdmin.Count != 3 {
+		t.Logf("admin explanation %+v", fromAdmin)
+		t.Fail()
+	}
+	if rec := adminRequest(jail, "GET", "/explain", ""); rec.Code != http.StatusBadRequest {
+		t.Logf("explain without a key: got status %d", rec.Code)
+		t.Fail()
+	}
+}
+
+func TestExplainScopes(t *testing.T) {
+	clock := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	jail := NewJailForTesting(clock, time.Minute, 0, 100)
+	firstParty := Limit{AllowedRequests: 7, Window: 7 * time.Minute}
+	jail.FirstPartyLimit = &firstParty
+	jail.HostLimits = map[string]Limit{"api.example.com": {AllowedRequests: 2, Window: 2 * time.Minute}}
+	jail.ClassLimits = map[string]Limit{"bots": {AllowedRequests: 3, Window: 3 * time.Minute}}
+	jail.Routes = []RouteLimit{{Method: "POST", Path: "/login", Limit: Limit{AllowedRequests: 4, Window: 4 * time.Minute}}}
+	jail.ExtraLimits = []Limit{{AllowedRequests: 5, Window: 5 * time.Second}}
+	jail.KeyLimits = []KeyLimit{{Name: "s", Key: KeyByCookie("s"), Limit: Limit{AllowedRequests: 6, Window: time.Hour}}}
+	jail.ErrorBudget = 8
+
+	for key, want := range map[string]struct {
+		rule  string
+		limit Limit
+	}{
+		"1.2.3.4":                      {"default", Limit{AllowedRequests: 100, Window: time.Minute}},
+		"first-party|1.2.3.4":          {"first-party", firstParty},
+		"host:api.example.com|1.2.3.4": {"host:api.example.com", jail.HostLimits["api.example.com"]},
+		"class:bots|1.2.3.4":           {"class:bots", jail.ClassLimits["bots"]},
+		"route:POST /login|1.2.3.4":    {"route:POST /login", jail.Routes[0].Limit},
+		"limit:5/5s|1.2.3.4":           {"limit:5/5s", jail.ExtraLimits[0]},
+		"key:s|abc":                    {"key:s", jail.KeyLimits[0].Limit},
+		"errors|1.2.3.4":               {"errors", Limit{AllowedRequests: 8, Window: time.Minute}},
+		"errors|class:bots|1.2.3.4":    {"errors", Limit{AllowedRequests: 8, Window: 3 * time.Minute}},
+	} {
+		if explained := jail.Explain(key); explained.Rule != want.rule || explained.Limit != want.limit {
+			t.Logf("%s explained under %s %+v, expected %s %+v", key, explained.Rule, explained.Limit, want.rule,
+				want.limit)
+			t.Fail()
+		}
+	}
+}
+
+func TestExplainKeepsHistory(t *testing.T) {
+	clock := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	jail := NewJailForTesting(clock, time.Minute, 0, 100)
+	jail.KeyLimits = []KeyLimit{{Name: "s", Key: KeyByCookie("s"), Limit: Limit{AllowedRequests: 3, Window: time.Hour}}}
+
+	request := func() bool {
+		req := makeRequest("1.2.3.4", false)
+		req.AddCookie(&http.Cookie{Name: "s", Value: "abc"})
+		return serveJail(jail, req)
+	}
+	for i := 0; i < 3; i++ {
+		request()
+		clock.Advance(10 * time.Minute)
+	}
+
+	if explained := jail.Explain("key:s|cookie:abc"); explained.Count != 3 || !explained.Blocked {
+		t.Logf("session at its hourly limit explained as %+v", explained)
+		t.Fail()
+	}
+	if request() {
+		t.Log("request over the hourly session limit allowed after an explanation")
+		t.Fail()
+	}
+}
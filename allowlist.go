@@ -0,0 +1,64 @@
+package httpjail
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ParseNetworks parses IP addresses and CIDR ranges, such as "10.0.0.1" and "192.168.0.0/16", into networks for
+// AllowedIPs, DeniedIPs and CountOnlyIPs. A bare address becomes a network of just that address.
+func ParseNetworks(entries ...string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(entries))
+	for _, entry := range entries {
+		if strings.Contains(entry, "/") {
+			_, network, err := net.ParseCIDR(entry)
+			if err != nil {
+				return nil, err
+			}
+			networks = append(networks, network)
+			continue
+		}
+
+		ip := net.ParseIP(entry)
+		if ip == nil {
+			return nil, &net.ParseError{Type: "IP address", Text: entry}
+		}
+		bits := 8 * net.IPv4len
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		} else {
+			bits = 8 * net.IPv6len
+		}
+		networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+	}
+	return networks, nil
+}
+
+// requestIP parses the client IP of the request, resolving it from the forwarded chain in proxy mode, or returns
+// nil if the address isn't an IP
+func (j *Jail) requestIP(req *http.Request) net.IP {
+	addr := req.RemoteAddr
+	if j.isProxied {
+		addr = j.clientAddr(req, j.forwardedChain(req))
+	}
+	return net.ParseIP(stripPort(addr))
+}
+
+// inNetworks reports whether ip falls in any of the networks
+func inNetworks(ip net.IP, networks []*net.IPNet) bool {
+	if ip == nil {
+		return false
+	}
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// isAllowlisted reports whether the request's client IP is in AllowedIPs
+func (j *Jail) isAllowlisted(req *http.Request) bool {
+	return inNetworks(j.requestIP(req), j.AllowedIPs)
+}
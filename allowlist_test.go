@@ -0,0 +1,106 @@
+package httpjail
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseNetworks(t *testing.T) {
+	networks, err := ParseNetworks("10.0.0.1", "192.168.0.0/16", "2001:db8::1", "2001:db8:1::/48")
+	if err != nil {
+		t.Logf("failed to parse networks: %s", err)
+		t.FailNow()
+	}
+
+	cases := map[string]bool{
+		"10.0.0.1":        true,
+		"10.0.0.2":        false,
+		"192.168.4.20":    true,
+		"192.169.0.1":     false,
+		"2001:db8::1":     true,
+		"2001:db8::2":     false,
+		"2001:db8:1:2::3": true,
+		"::ffff:10.0.0.1": true,
+	}
+	for addr, expected := range cases {
+		if contained := inNetworks(net.ParseIP(addr), networks); contained != expected {
+			t.Logf("%s in networks: %v, expected %v", addr, contained, expected)
+			t.Fail()
+		}
+	}
+
+	for _, bad := range []string{"10.0.0", "10.0.0.0/33", "example.com"} {
+		if _, err := ParseNetworks(bad); err == nil {
+			t.Logf("parsed invalid entry %q", bad)
+			t.Fail()
+		}
+	}
+}
+
+func TestAllowedIPs(t *testing.T) {
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, time.Hour, 2)
+	jail.AllowedIPs, _ = ParseNetworks("10.0.0.1", "172.16.0.0/12")
+	decisions := 0
+	jail.OnDecision = func(Decision) { decisions++ }
+
+	handler := jail.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(successRes))
+	}))
+	serve := func(addr string) bool {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, makeRequest(addr, false))
+		return rec.Body.String() == successRes
+	}
+
+	// allowlisted clients are never counted, blocked or sentenced
+	for _, addr := range []string{"10.0.0.1:5000", "172.20.1.1:5000"} {
+		for i := 0; i < 10; i++ {
+			if !serve(addr) {
+				t.Logf("allowlisted client %s blocked on request %d", addr, i)
+				t.Fail()
+			}
+		}
+		if count := jail.visitors.CountVisits(stripPort(addr), time.Time{}); count != 0 {
+			t.Logf("allowlisted client %s counted %d visits", addr, count)
+			t.Fail()
+		}
+	}
+	if decisions != 0 || len(jail.Sentences) != 0 {
+		t.Logf("allowlisted requests made %d decisions and %d sentences", decisions, len(jail.Sentences))
+		t.Fail()
+	}
+
+	// everyone else is limited as usual
+	serve("10.0.0.2:5000")
+	serve("10.0.0.2:5000")
+	if serve("10.0.0.2:5000") {
+		t.Log("client outside the allowlist wasn't blocked")
+		t.Fail()
+	}
+}
+
+func TestAllowedIPsProxied(t *testing.T) {
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, 0, 1)
+	jail.isProxied = true
+	jail.AllowedIPs, _ = ParseNetworks("10.0.0.1")
+
+	// the forwarded client is checked, not the proxy
+	for i := 0; i < 3; i++ {
+		req := makeRequest("10.0.0.1", true)
+		req.RemoteAddr = "192.0.2.1:443"
+		if !serveJail(jail, req) {
+			t.Logf("allowlisted forwarded client blocked on request %d", i)
+			t.Fail()
+		}
+	}
+	req := makeRequest("10.0.0.2", true)
+	req.RemoteAddr = "10.0.0.1:443"
+	serveJail(jail, req)
+	if serveJail(jail, req) {
+		t.Log("forwarded client let through for an allowlisted proxy")
+		t.Fail()
+	}
+}
@@ -0,0 +1,80 @@
+package httpjail
+
+import "time"
+
+// BatchVisitorLog is implemented by visitor logs that can count many visitors in one call, such as a remote store
+// answering in a single round trip. Unlike CountVisits, CountVisitsBatch mustn't drop visits from before since, so
+// counting a short span doesn't cut into a longer enforcement window.
+type BatchVisitorLog interface {
+	CountVisitsBatch(keys []string, since time.Time) map[string]int
+}
+
+// CountVisitsBatch counts the visits of each key since the provided time, in one call if the visitor log implements
+// BatchVisitorLog and key by key otherwise. Keys are visitor log keys, as reported in Decision.Key.
+func (j *Jail) CountVisitsBatch(keys []string, since time.Time) map[string]int {
+	return countVisitsBatch(j.visitors, keys, since)
+}
+
+// countVisitsBatch counts the visits of each key in the log, key by key if it doesn't implement BatchVisitorLog
+func countVisitsBatch(log VisitorLog, keys []string, since time.Time) map[string]int {
+	if batcher, ok := log.(BatchVisitorLog); ok {
+		return batcher.CountVisitsBatch(keys, since)
+	}
+
+	counts := make(map[string]int, len(keys))
+	for _, key := range keys {
+		counts[key] = log.CountVisits(key, since)
+	}
+	return counts
+}
+
+// CountVisitsBatch counts the visits of each key, leaving visits from before since in place
+func (l *DefaultVisitorLog) CountVisitsBatch(keys []string, since time.Time) map[string]int {
+	counts := make(map[string]int, len(keys))
+	for _, key := range keys {
+		shard := l.shard(key)
+		shard.mux.Lock()
+		counts[key] = l.peekVisits(shard, key, since)
+		shard.mux.Unlock()
+	}
+	return counts
+}
+
+// CountVisitsBatch counts the visits of each key in the local log, in one call if it implements BatchVisitorLog,
+// plus the latest counts gossiped by other nodes
+func (l *GossipVisitorLog) CountVisitsBatch(keys []string, since time.Time) map[string]int {
+	counts := countVisitsBatch(l.local, keys, since)
+	now := nowFrom(l.Clock)
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	for node, snapshot := range l.remote {
+		if now.Sub(snapshot.received) > l.window {
+			delete(l.remote, node)
+			continue
+		}
+		for _, key := range keys {
+			counts[key] += snapshot.counts[key]
+		}
+	}
+	return counts
+}
+
+// CountVisitsBatch counts the visits of each key in the current fixed window under a single lock. since is
+// ignored, as in CountVisits.
+func (l *FixedWindowLog) CountVisitsBatch(keys []string, since time.Time) map[string]int {
+	start := l.windowStart(nowFrom(l.Clock))
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+
+	counts := make(map[string]int, len(keys))
+	for _, key := range keys {
+		if counter, ok := l.counters[key]; ok && counter.start.Equal(start) {
+			counts[key] = counter.count
+		} else {
+			counts[key] = 0
+		}
+	}
+	return counts
+}
@@ -0,0 +1,76 @@
+package httpjail
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCountVisitsBatch(t *testing.T) {
+	clock := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+
+	defaultLog := NewDefaultVisitorLog()
+	defaultLog.Clock = clock
+	fixedLog := NewFixedWindowLog(time.Hour)
+	fixedLog.Clock = clock
+	gossipLog := NewGossipVisitorLog("a", NewDefaultVisitorLog(), time.Hour)
+	defer gossipLog.Close()
+
+	logs := map[string]VisitorLog{
+		"default":      defaultLog,
+		"fixed window": fixedLog,
+		"gossip":       gossipLog,
+	}
+
+	var keys []string
+	for i := 0; i < 5; i++ {
+		keys = append(keys, fmt.Sprintf("10.0.0.%d", i))
+	}
+	keys = append(keys, "never-seen")
+
+	for name, log := range logs {
+		jail := NewJail(log, time.Hour, 0, 100)
+		jail.Clock = clock
+
+		for i, key := range keys[:5] {
+			for n := 0; n <= i; n++ {
+				log.LogVisit(key)
+			}
+		}
+
+		since := clock.Now().Add(-time.Hour)
+		batch := jail.CountVisitsBatch(keys, since)
+		if len(batch) != len(keys) {
+			t.Logf("%s: batch returned %d counts for %d keys", name, len(batch), len(keys))
+			t.Fail()
+		}
+		for _, key := range keys {
+			if individual := log.CountVisits(key, since); batch[key] != individual {
+				t.Logf("%s: batch count for %s is %d, individual count is %d", name, key, batch[key], individual)
+				t.Fail()
+			}
+		}
+	}
+}
+
+func TestCountVisitsBatchKeepsHistory(t *testing.T) {
+	clock := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	log := NewDefaultVisitorLog()
+	log.Clock = clock
+	jail := NewJail(log, time.Hour, 0, 100)
+	jail.Clock = clock
+
+	for i := 0; i < 3; i++ {
+		log.LogVisit("1.2.3.4")
+	}
+	clock.Advance(30 * time.Minute)
+
+	if counts := jail.CountVisitsBatch([]string{"1.2.3.4"}, clock.Now().Add(-time.Minute)); counts["1.2.3.4"] != 0 {
+		t.Logf("counted %d visits in the last minute, expected 0", counts["1.2.3.4"])
+		t.Fail()
+	}
+	if count := log.CountVisits("1.2.3.4", clock.Now().Add(-time.Hour)); count != 3 {
+		t.Logf("batch count of the last minute dropped visits from the hour window, %d of 3 left", count)
+		t.Fail()
+	}
+}
@@ -0,0 +1,168 @@
+package httpjail
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// benchResponseWriter discards responses without allocating per request
+type benchResponseWriter struct {
+	header http.Header
+}
+
+func (w *benchResponseWriter) Header() http.Header         { return w.header }
+func (w *benchResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *benchResponseWriter) WriteHeader(status int)      {}
+
+// trafficPattern generates a realistic mix of requests: a Zipf distribution over clients, so a few heavy hitters
+// send most of the traffic (and get limited) while a long tail of clients sends a handful of requests each. The
+// pattern is seeded, so every algorithm sees the same traffic.
+func trafficPattern(requests, clients int) []*http.Request {
+	rng := rand.New(rand.NewSource(1))
+	zipf := rand.NewZipf(rng, 1.2, 1, uint64(clients-1))
+
+	pattern := make([]*http.Request, requests)
+	for i := range pattern {
+		client := zipf.Uint64()
+		pattern[i] = makeRequest(fmt.Sprintf("10.%d.%d.%d", client>>16&0xff, client>>8&0xff, client&0xff), false)
+	}
+	return pattern
+}
+
+// benchAlgorithm runs the traffic pattern through a jail built on the visitor log, allowing the provided count a
+// second and advancing a fake clock a millisecond per request
+func benchAlgorithm(b *testing.B, newLog func(clock Clock) VisitorLog, allowed int) {
+	pattern := trafficPattern(10000, 5000)
+	clock := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	jail := NewJail(newLog(clock), time.Second, 0, allowed)
+	jail.Clock = clock
+
+	handler := jail.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	w := &benchResponseWriter{header: make(http.Header)}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		handler.ServeHTTP(w, pattern[i%len(pattern)])
+		clock.Advance(time.Millisecond)
+	}
+}
+
+// BenchmarkAlgorithms compares the limiting algorithms on the same traffic. Run with
+//
+//	go test -run '^$' -bench Algorithms -benchmem
+func BenchmarkAlgorithms(b *testing.B) {
+	algorithms := []struct {
+		name    string
+		newLog  func(clock Clock) VisitorLog
+		allowed int
+	}{
+		{"sliding window", func(clock Clock) VisitorLog {
+			log := NewDefaultVisitorLog()
+			log.Clock = clock
+			return log
+		}, 20},
+		{"fixed window", func(clock Clock) VisitorLog {
+			log := NewFixedWindowLog(time.Second)
+			log.Clock = clock
+			return log
+		}, 20},
+		{"sliding window counter", func(clock Clock) VisitorLog {
+			log := NewSlidingWindowLog(time.Second)
+			log.Clock = clock
+			return log
+		}, 20},
+		{"token bucket", func(clock Clock) VisitorLog {
+			log := NewTokenBucketLog(20, 20, time.Second)
+			log.Clock = clock
+			return log
+		}, 20},
+		// a leaky bucket meter is a token bucket counted from the other side: each request fills it and it drains at
+		// a steady rate. With room for a single request it admits the same 20 a second, evenly spaced, with no burst.
+		{"leaky bucket", func(clock Clock) VisitorLog {
+			log := NewTokenBucketLog(1, 1, time.Second/20)
+			log.Clock = clock
+			return log
+		}, 1},
+	}
+
+	for _, algorithm := range algorithms {
+		newLog, allowed := algorithm.newLog, algorithm.allowed
+		b.Run(algorithm.name, func(b *testing.B) {
+			benchAlgorithm(b, newLog, allowed)
+		})
+	}
+}
+
+// BenchmarkCountVisits counts a heavy visitor's history, most of it still in the window. Counting searches the
+// ordered visits and trims them in place, so it doesn't allocate however long the history.
+func BenchmarkCountVisits(b *testing.B) {
+	for _, history := range []int{10, 1000, 100000} {
+		b.Run(fmt.Sprintf("visits=%d", history), func(b *testing.B) {
+			clock := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+			visitorLog := NewDefaultVisitorLog()
+			visitorLog.Clock = clock
+			for i := 0; i < history; i++ {
+				visitorLog.LogVisit("1.2.3.4")
+				clock.Advance(time.Millisecond)
+			}
+			since := clock.Now().Add(-time.Duration(history) * time.Millisecond / 2)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				visitorLog.CountVisits("1.2.3.4", since)
+			}
+		})
+	}
+}
+
+// BenchmarkDefaultVisitorLogParallel logs and counts visits from many goroutines, each its own visitor, as
+// unrelated clients hitting one server do
+func BenchmarkDefaultVisitorLogParallel(b *testing.B) {
+	visitorLog := NewDefaultVisitorLog()
+	// count nothing as in the window, so every visitor's history stays short
+	since := time.Now().Add(time.Hour)
+	var clients int32
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		key := fmt.Sprintf("10.0.0.%d", atomic.AddInt32(&clients, 1))
+		for pb.Next() {
+			visitorLog.IncrementAndCount(key, since)
+		}
+	})
+}
+
+// BenchmarkEviction floods a full DefaultVisitorLog with new visitors, each of which evicts the least recently
+// active one
+func BenchmarkEviction(b *testing.B) {
+	for _, capacity := range []int{1000, 100000} {
+		b.Run(fmt.Sprintf("visitors=%d", capacity), func(b *testing.B) {
+			clock := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+			visitorLog := NewDefaultVisitorLog()
+			visitorLog.Clock = clock
+			visitorLog.MaxVisitors = capacity
+			for i := 0; i < capacity; i++ {
+				visitorLog.LogVisit(strconv.Itoa(i))
+				clock.Advance(time.Millisecond)
+			}
+			keys := make([]string, b.N)
+			for i := range keys {
+				keys[i] = strconv.Itoa(capacity + i)
+			}
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				visitorLog.LogVisit(keys[i])
+			}
+		})
+	}
+}
@@ -0,0 +1,18 @@
+package httpjail
+
+import "context"
+
+// classKey is the context key holding a request's traffic class
+type classKey struct{}
+
+// WithClass tags a context with a traffic class, such as "internal" for calls between services. Requests made with
+// it are limited by the jail's ClassLimits entry for the class, if there is one.
+func WithClass(ctx context.Context, class string) context.Context {
+	return context.WithValue(ctx, classKey{}, class)
+}
+
+// ClassFromContext returns the traffic class the context was tagged with by WithClass, if any
+func ClassFromContext(ctx context.Context) (class string, ok bool) {
+	class, ok = ctx.Value(classKey{}).(string)
+	return class, ok
+}
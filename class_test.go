@@ -0,0 +1,65 @@
+package httpjail
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestClassLimits(t *testing.T) {
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, 0, 2)
+	jail.ClassLimits = map[string]Limit{
+		"internal": {AllowedRequests: 5, Window: time.Minute},
+	}
+
+	internal := func() bool {
+		req := makeRequest("10.0.0.1", false)
+		return serveJail(jail, req.WithContext(WithClass(req.Context(), "internal")))
+	}
+
+	for i := 0; i < 2; i++ {
+		if !serveJail(jail, makeRequest("10.0.0.1", false)) {
+			t.Logf("external request %d denied", i+1)
+			t.Fail()
+		}
+	}
+	if serveJail(jail, makeRequest("10.0.0.1", false)) {
+		t.Log("external request past the default limit allowed")
+		t.Fail()
+	}
+
+	// the same caller's internal traffic has its own, looser budget
+	for i := 0; i < 5; i++ {
+		if !internal() {
+			t.Logf("internal request %d denied", i+1)
+			t.Fail()
+		}
+	}
+	if internal() {
+		t.Log("internal request past the class limit allowed")
+		t.Fail()
+	}
+
+	// a class without a limit of its own falls back to the default
+	req := makeRequest("10.0.0.2", false)
+	req = req.WithContext(WithClass(req.Context(), "batch"))
+	for i := 0; i < 2; i++ {
+		serveJail(jail, req)
+	}
+	if serveJail(jail, req) {
+		t.Log("unlisted class escaped the default limit")
+		t.Fail()
+	}
+
+	// classes can be exempted outright
+	jail.Metered = func(req *http.Request) bool {
+		class, _ := ClassFromContext(req.Context())
+		return class != "internal"
+	}
+	for i := 0; i < 10; i++ {
+		if !internal() {
+			t.Logf("exempt internal request %d denied", i+1)
+			t.FailNow()
+		}
+	}
+}
@@ -0,0 +1,157 @@
+package httpjail
+
+import "time"
+
+// cleanupEvery is the number of keys cleanup prunes per lock acquisition, so a sweep of a large log doesn't stall
+// requests waiting on the lock
+const cleanupEvery = 100
+
+// visitorPruner is implemented by visitor logs that can drop visitors with no visits since a time
+type visitorPruner interface {
+	Prune(since time.Time)
+}
+
+// StartCleanup sweeps the visitor log and sentences every interval in a background goroutine until Close is called,
+// reclaiming visitors that stopped sending requests. Without it an in-memory visitor log only prunes a visitor's old
+// visits when that visitor is counted again.
+func (j *Jail) StartCleanup(interval time.Duration) {
+	j.mux.Lock()
+	if j.cleanupStop != nil {
+		j.mux.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	j.cleanupStop = stop
+	j.mux.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				j.Cleanup()
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops the background cleanup started by StartCleanup
+func (j *Jail) Close() {
+	j.mux.Lock()
+	if j.cleanupStop != nil {
+		close(j.cleanupStop)
+		j.cleanupStop = nil
+	}
+	j.mux.Unlock()
+}
+
+// Cleanup drops visitors with no visits in the widest window in use, if the visitor log supports it, expired
+// sentences, forgiven offenses, and stale OnFirstBlock state, SlowRequest latencies and account IPs
+func (j *Jail) Cleanup() {
+	now := j.now()
+	stale := now.Add(-j.widestWindow())
+
+	if pruner, ok := j.visitors.(visitorPruner); ok {
+		pruner.Prune(stale)
+	}
+
+	j.sentenceMux.Lock()
+	for key, release := range j.Sentences {
+		if !release.After(now) {
+			j.unsentence(key)
+		}
+	}
+	for key, release := range j.wouldSentences {
+		if !release.After(now) {
+			delete(j.wouldSentences, key)
+		}
+	}
+	for key := range j.lastReleases {
+		if j.forgiven(key, now) {
+			j.forgive(key)
+		}
+	}
+	j.sentenceMux.Unlock()
+
+	j.pruneBlocked(now, stale)
+	j.pruneLatencies(stale)
+	j.pruneAccounts(stale)
+}
+
+// widestWindow returns the longest window of any limit the jail applies
+func (j *Jail) widestWindow() time.Duration {
+	j.limitsMux.RLock()
+	defer j.limitsMux.RUnlock()
+
+	widest := j.Window
+	widen := func(window time.Duration) {
+		if window > widest {
+			widest = window
+		}
+	}
+	for _, route := range j.Routes {
+		widen(route.Window)
+	}
+	for _, limit := range j.HostLimits {
+		widen(limit.Window)
+	}
+	for _, limit := range j.ClassLimits {
+		widen(limit.Window)
+	}
+	for _, limit := range j.ExtraLimits {
+		widen(limit.Window)
+	}
+	if j.FirstPartyLimit != nil {
+		widen(j.FirstPartyLimit.Window)
+	}
+	for _, keyLimit := range j.KeyLimits {
+		widen(keyLimit.Window)
+	}
+	return widest
+}
+
+// Prune drops every visit from before the provided time, and with them the visitors left with none, shard by shard
+// and cleanupEvery visitors at a time
+func (l *DefaultVisitorLog) Prune(since time.Time) {
+	for i := range l.shards {
+		shard := &l.shards[i]
+		shard.mux.Lock()
+		keys := make([]string, 0, len(shard.visits))
+		for key := range shard.visits {
+			keys = append(keys, key)
+		}
+		shard.mux.Unlock()
+
+		for start := 0; start < len(keys); start += cleanupEvery {
+			end := start + cleanupEvery
+			if end > len(keys) {
+				end = len(keys)
+			}
+
+			shard.mux.Lock()
+			for _, key := range keys[start:end] {
+				if l.countVisits(shard, key, since) == 0 {
+					l.forget(shard, key)
+				}
+			}
+			shard.mux.Unlock()
+		}
+	}
+}
+
+// Prune drops every visitor whose count is from a window before the current one. since is ignored, as in
+// CountVisits.
+func (l *FixedWindowLog) Prune(since time.Time) {
+	start := l.windowStart(nowFrom(l.Clock))
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	for key, counter := range l.counters {
+		if !counter.start.Equal(start) {
+			delete(l.counters, key)
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package httpjail
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestCleanup(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	jail := NewJailForTesting(clock, time.Minute, 30*time.Second, 1)
+	jail.Routes = []RouteLimit{{Path: "/slow", Limit: Limit{AllowedRequests: 1, Window: 5 * time.Minute}}}
+	visitors := jail.visitors.(*DefaultVisitorLog)
+
+	for i := 0; i < 250; i++ {
+		serveJail(jail, makeRequest(fmt.Sprintf("10.0.%d.%d", i/256, i%256), false))
+	}
+	// sentenced
+	serveJail(jail, makeRequest("10.0.0.0", false))
+
+	// past the route's window, the widest in use
+	clock.Advance(6 * time.Minute)
+	visitors.LogVisit("route:GET /slow|1.2.3.4")
+	clock.Advance(4 * time.Minute)
+	serveJail(jail, makeRequest("active", false))
+
+	jail.Cleanup()
+
+	if remaining := visitors.visitors(); remaining != 2 {
+		t.Logf("%d visitors left after cleanup, expected the 2 within the widest window", remaining)
+		t.Fail()
+	}
+	if len(jail.Sentences) != 0 {
+		t.Logf("expired sentences left after cleanup: %v", jail.Sentences)
+		t.Fail()
+	}
+
+	clock.Advance(10 * time.Minute)
+	jail.Cleanup()
+	if remaining := visitors.visitors(); remaining != 0 {
+		t.Logf("%d visitors left after their windows passed", remaining)
+		t.Fail()
+	}
+}
+
+func TestStartCleanup(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	jail := NewJailForTesting(clock, time.Minute, 0, 1)
+	visitors := jail.visitors.(*DefaultVisitorLog)
+	before := runtime.NumGoroutine()
+
+	serveJail(jail, makeRequest("1.2.3.4", false))
+	clock.Advance(2 * time.Minute)
+
+	jail.StartCleanup(time.Millisecond)
+	// starting twice doesn't leak a second goroutine
+	jail.StartCleanup(time.Millisecond)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if visitors.visitors() == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Log("background cleanup never reclaimed the stale visitor")
+			t.FailNow()
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	jail.Close()
+	jail.Close()
+	for runtime.NumGoroutine() > before && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if after := runtime.NumGoroutine(); after > before {
+		t.Logf("cleanup goroutine still running after Close: %d before, %d after", before, after)
+		t.Fail()
+	}
+}
+
+func TestDefaultVisitorLogPrune(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	visitorLog := NewDefaultVisitorLog()
+	visitorLog.Clock = clock
+
+	start := clock.Now()
+	// one visitor only visits early, one throughout, one only late
+	for i := 0; i < 10; i++ {
+		if i < 5 {
+			visitorLog.LogVisit("early")
+		}
+		visitorLog.LogVisit("steady")
+		if i >= 5 {
+			visitorLog.LogVisit("late")
+		}
+		clock.Advance(time.Second)
+	}
+
+	visitorLog.Prune(start.Add(5 * time.Second))
+
+	if _, tracked := visitorLog.stored("early"); tracked {
+		t.Log("visitor with only old visits survived pruning")
+		t.Fail()
+	}
+	for key, expected := range map[string]int{"steady": 5, "late": 5} {
+		visits, tracked := visitorLog.stored(key)
+		if !tracked || len(visits) != expected {
+			t.Logf("%s kept %d visits after pruning, expected %d", key, len(visits), expected)
+			t.Fail()
+			continue
+		}
+		if visits[0].Before(start.Add(5 * time.Second)) {
+			t.Logf("%s kept a visit from before the cutoff", key)
+			t.Fail()
+		}
+	}
+	if visitorLog.visitors() != 2 {
+		t.Logf("%d visitors tracked after pruning, expected 2", visitorLog.visitors())
+		t.Fail()
+	}
+
+	visitorLog.Reset("steady")
+	if _, tracked := visitorLog.stored("steady"); tracked || visitorLog.visitors() != 1 {
+		t.Log("reset visitor still tracked")
+		t.Fail()
+	}
+}
@@ -0,0 +1,59 @@
+package httpjail
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the jail what time it is
+type Clock interface {
+	Now() time.Time
+}
+
+// realClock reads the system time
+type realClock struct{}
+
+// Now returns the current system time
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+// FakeClock is a manually advanced Clock for deterministic tests
+type FakeClock struct {
+	mux sync.Mutex
+	now time.Time
+}
+
+// NewFakeClock creates a FakeClock stopped at the provided time
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+// Now returns the fake clock's current time
+func (c *FakeClock) Now() time.Time {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	return c.now
+}
+
+// Advance moves the fake clock forward by d
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mux.Lock()
+	c.now = c.now.Add(d)
+	c.mux.Unlock()
+}
+
+// Set moves the fake clock to t
+func (c *FakeClock) Set(t time.Time) {
+	c.mux.Lock()
+	c.now = t
+	c.mux.Unlock()
+}
+
+// nowFrom reads the time from clock, falling back to the system time if clock is nil
+func nowFrom(clock Clock) time.Time {
+	if clock == nil {
+		return time.Now()
+	}
+	return clock.Now()
+}
@@ -0,0 +1,202 @@
+package httpjail
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// maxCoalescedRequest is the largest request body hashed for coalescing, larger requests are served on their own
+const maxCoalescedRequest = 1 << 20
+
+// defaultMaxCoalescedBody is the largest response body buffered for followers when MaxCoalescedBody isn't set
+const defaultMaxCoalescedBody = 1 << 20
+
+// coalescedCall is a handler call shared by concurrent identical requests
+type coalescedCall struct {
+	// closed once the call finishes
+	done chan struct{}
+	// guards followers, unreplayable and body while the handler runs
+	mux sync.Mutex
+	// requests waiting on the call
+	followers int
+	// whether the response went unbuffered, with no followers to buffer it for or past MaxCoalescedBody, so
+	// followers serve themselves
+	unreplayable bool
+	// whether the handler took over the connection, leaving no response to replay
+	hijacked bool
+	// whether the handler returned normally, followers serve themselves if it didn't
+	completed bool
+	header    http.Header
+	status    int
+	body      bytes.Buffer
+}
+
+// coalesce serves the request through next, or if an identical request from the same visitor is already in flight,
+// waits for it and replays its response
+func (j *Jail) coalesce(w http.ResponseWriter, req *http.Request, next http.Handler, key string) {
+	fingerprint, ok := coalesceKey(req, key)
+	if !ok {
+		next.ServeHTTP(w, req)
+		return
+	}
+
+	j.mux.Lock()
+	if call, inFlight := j.coalescing[fingerprint]; inFlight {
+		j.mux.Unlock()
+		j.follow(w, req, next, call)
+		return
+	}
+
+	call := &coalescedCall{done: make(chan struct{})}
+	if j.coalescing == nil {
+		j.coalescing = make(map[string]*coalescedCall)
+	}
+	j.coalescing[fingerprint] = call
+	j.mux.Unlock()
+
+	defer func() {
+		j.mux.Lock()
+		delete(j.coalescing, fingerprint)
+		j.mux.Unlock()
+		close(call.done)
+	}()
+
+	maxBody := j.MaxCoalescedBody
+	if maxBody <= 0 {
+		maxBody = defaultMaxCoalescedBody
+	}
+	next.ServeHTTP(&coalesceRecorder{ResponseWriter: w, call: call, maxBody: maxBody}, req)
+	if call.status == 0 {
+		call.status = http.StatusOK
+		call.header = w.Header().Clone()
+	}
+	call.completed = !call.hijacked
+}
+
+// follow waits on an identical request's call and replays its response, or serves the request itself if the
+// response couldn't be replayed. Followers whose client goes away stop waiting.
+func (j *Jail) follow(w http.ResponseWriter, req *http.Request, next http.Handler, call *coalescedCall) {
+	call.mux.Lock()
+	if call.unreplayable {
+		call.mux.Unlock()
+		next.ServeHTTP(w, req)
+		return
+	}
+	call.followers++
+	call.mux.Unlock()
+
+	select {
+	case <-call.done:
+	case <-req.Context().Done():
+		call.mux.Lock()
+		call.followers--
+		call.mux.Unlock()
+		return
+	}
+
+	if !call.completed || call.unreplayable {
+		next.ServeHTTP(w, req)
+		return
+	}
+	call.replay(w)
+}
+
+// coalesceKey fingerprints the request by visitor, method, URL and body, restoring the body for the handler.
+// Only GET and HEAD requests are coalesced, and not those with unreadable or oversized bodies.
+func coalesceKey(req *http.Request, key string) (string, bool) {
+	if req.URL == nil || req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return "", false
+	}
+
+	hash := sha256.New()
+	io.WriteString(hash, key+"|"+req.Method+" "+req.URL.RequestURI()+"|")
+
+	if req.Body != nil && req.Body != http.NoBody {
+		body, err := io.ReadAll(io.LimitReader(req.Body, maxCoalescedRequest+1))
+		req.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), req.Body), req.Body}
+		if err != nil || len(body) > maxCoalescedRequest {
+			return "", false
+		}
+		hash.Write(body)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), true
+}
+
+// replay writes the shared response, keeping headers the jail already set for this request
+func (c *coalescedCall) replay(w http.ResponseWriter) {
+	header := w.Header()
+	for name, values := range c.header {
+		if _, set := header[name]; !set {
+			header[name] = values
+		}
+	}
+	w.WriteHeader(c.status)
+	w.Write(c.body.Bytes())
+}
+
+// coalesceRecorder writes the leading request's response while capturing a copy for the followers
+type coalesceRecorder struct {
+	http.ResponseWriter
+	call    *coalescedCall
+	maxBody int
+}
+
+// WriteHeader captures the status and headers before writing them
+func (r *coalesceRecorder) WriteHeader(status int) {
+	if r.call.status == 0 {
+		r.call.status = status
+		r.call.header = r.ResponseWriter.Header().Clone()
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Write captures the body as it's written
+func (r *coalesceRecorder) Write(b []byte) (int, error) {
+	if r.call.status == 0 {
+		r.WriteHeader(http.StatusOK)
+	}
+
+	// the body is only copied while followers wait on it, and not past MaxCoalescedBody
+	call := r.call
+	call.mux.Lock()
+	if !call.unreplayable && (call.followers == 0 || call.body.Len()+len(b) > r.maxBody) {
+		call.unreplayable = true
+		call.body = bytes.Buffer{}
+	}
+	if !call.unreplayable {
+		call.body.Write(b)
+	}
+	call.mux.Unlock()
+	return r.ResponseWriter.Write(b)
+}
+
+// Flush flushes the underlying writer if it supports flushing
+func (r *coalesceRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack hands the connection to the handler if the underlying writer allows it, followers then serve themselves
+func (r *coalesceRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	r.call.hijacked = true
+	return hijacker.Hijack()
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (r *coalesceRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
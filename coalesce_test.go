@@ -0,0 +1,251 @@
+package httpjail
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCoalesceRequests(t *testing.T) {
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, 0, 100)
+	jail.CoalesceRequests = true
+
+	var calls int32
+	release := make(chan struct{})
+	handler := jail.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		body, _ := io.ReadAll(req.Body)
+		<-release
+		w.Header().Set("X-Backend", "hit")
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, "report for %s", body)
+	}))
+
+	concurrent := 10
+	recorders := make([]*httptest.ResponseRecorder, concurrent)
+	var wg sync.WaitGroup
+	for i := range recorders {
+		recorders[i] = httptest.NewRecorder()
+		wg.Add(1)
+		go func(w *httptest.ResponseRecorder) {
+			defer wg.Done()
+			handler.ServeHTTP(w, coalescableRequest("1.2.3.4"))
+		}(recorders[i])
+	}
+
+	// let every request join the one in flight before the backend answers
+	waitForFollowers(jail, concurrent-1)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Logf("backend hit %d times by %d identical requests, expected once", calls, concurrent)
+		t.Fail()
+	}
+	for i, w := range recorders {
+		if w.Code != http.StatusAccepted || w.Body.String() != "report for hello" || w.Header().Get("X-Backend") != "hit" {
+			t.Logf("request %d got %d %q, expected the shared response", i, w.Code, w.Body.String())
+			t.Fail()
+		}
+	}
+
+	// requests that differ in visitor or body are served separately, as are other methods
+	calls = 0
+	requests := []*http.Request{coalescableRequest("1.2.3.4"), coalescableRequest("5.6.7.8")}
+	other := coalescableRequest("1.2.3.4")
+	other.Body = io.NopCloser(strings.NewReader("goodbye"))
+	requests = append(requests, other, makeRequest("1.2.3.4", false))
+	for _, req := range requests {
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if calls != 4 {
+		t.Logf("backend hit %d times by 4 distinct requests", calls)
+		t.Fail()
+	}
+}
+
+// waitForFollowers waits until the request in flight has the provided number of followers
+func waitForFollowers(jail *Jail, count int) {
+	for followers := -1; followers != count; time.Sleep(time.Millisecond) {
+		jail.mux.Lock()
+		for _, call := range jail.coalescing {
+			call.mux.Lock()
+			followers = call.followers
+			call.mux.Unlock()
+		}
+		jail.mux.Unlock()
+	}
+}
+
+func TestCoalesceBodyCap(t *testing.T) {
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, 0, 100)
+	jail.CoalesceRequests = true
+	jail.MaxCoalescedBody = 8
+
+	var calls int32
+	release := make(chan struct{})
+	handler := jail.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		fmt.Fprint(w, "a report too long to buffer")
+	}))
+
+	concurrent := 4
+	recorders := make([]*httptest.ResponseRecorder, concurrent)
+	var wg sync.WaitGroup
+	for i := range recorders {
+		recorders[i] = httptest.NewRecorder()
+		wg.Add(1)
+		go func(w *httptest.ResponseRecorder) {
+			defer wg.Done()
+			handler.ServeHTTP(w, coalescableRequest("1.2.3.4"))
+		}(recorders[i])
+	}
+	waitForFollowers(jail, concurrent-1)
+	close(release)
+	wg.Wait()
+
+	// followers of a response past the cap run the handler themselves rather than replay a partial body
+	if calls != int32(concurrent) {
+		t.Logf("backend hit %d times by %d requests with an oversized response, expected each", calls, concurrent)
+		t.Fail()
+	}
+	for i, w := range recorders {
+		if w.Body.String() != "a report too long to buffer" {
+			t.Logf("request %d got %q, expected the full response", i, w.Body.String())
+			t.Fail()
+		}
+	}
+}
+
+func TestCoalesceBuffersOnlyForFollowers(t *testing.T) {
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, 0, 100)
+	jail.CoalesceRequests = true
+
+	buffered := -1
+	handler := jail.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, "report")
+		jail.mux.Lock()
+		for _, call := range jail.coalescing {
+			call.mux.Lock()
+			buffered = call.body.Len()
+			call.mux.Unlock()
+		}
+		jail.mux.Unlock()
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, coalescableRequest("1.2.3.4"))
+
+	if buffered != 0 || w.Body.String() != "report" {
+		t.Logf("lone request buffered %d bytes and got %q, expected nothing buffered", buffered, w.Body.String())
+		t.Fail()
+	}
+}
+
+func TestCoalesceFollowerCancel(t *testing.T) {
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, 0, 100)
+	jail.CoalesceRequests = true
+
+	release := make(chan struct{})
+	handler := jail.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		<-release
+		fmt.Fprint(w, "report")
+	}))
+
+	leader := make(chan struct{})
+	go func() {
+		defer close(leader)
+		handler.ServeHTTP(httptest.NewRecorder(), coalescableRequest("1.2.3.4"))
+	}()
+	waitForFollowers(jail, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	follower := make(chan struct{})
+	go func() {
+		defer close(follower)
+		handler.ServeHTTP(httptest.NewRecorder(), coalescableRequest("1.2.3.4").WithContext(ctx))
+	}()
+	waitForFollowers(jail, 1)
+	cancel()
+
+	// the follower stops waiting while the leader is still running
+	select {
+	case <-follower:
+	case <-time.After(time.Second):
+		t.Log("cancelled follower kept waiting on the leader")
+		t.Fail()
+	}
+	waitForFollowers(jail, 0)
+	close(release)
+	<-leader
+}
+
+// coalescableRequest is a GET from the provided address with a body
+func coalescableRequest(fromAddr string) *http.Request {
+	req := makeRequest(fromAddr, false)
+	req.Method = http.MethodGet
+	return req
+}
+
+func TestCoalesceKeyMethods(t *testing.T) {
+	for method, coalesced := range map[string]bool{
+		http.MethodGet:    true,
+		http.MethodHead:   true,
+		http.MethodPost:   false,
+		http.MethodPut:    false,
+		http.MethodDelete: false,
+	} {
+		req := httptest.NewRequest(method, "/report", nil)
+		if _, ok := coalesceKey(req, "1.2.3.4"); ok != coalesced {
+			t.Logf("%s request coalesced: %t, expected %t", method, ok, coalesced)
+			t.Fail()
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/report", nil)
+	req.URL = nil
+	if _, ok := coalesceKey(req, "1.2.3.4"); ok {
+		t.Log("request without a URL coalesced")
+		t.Fail()
+	}
+}
+
+func TestCoalesceHijack(t *testing.T) {
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, 0, 100)
+	jail.CoalesceRequests = true
+
+	server := httptest.NewServer(jail.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if _, ok := w.(http.Flusher); !ok {
+			t.Log("coalesced handler can't flush")
+			t.Fail()
+		}
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Logf("coalesced handler can't hijack: %s", err)
+			t.Fail()
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 8\r\nConnection: close\r\n\r\nhijacked")
+		buf.Flush()
+	})))
+	defer server.Close()
+
+	res, err := http.Get(server.URL)
+	if err != nil {
+		t.Logf("request to hijacking handler failed: %s", err)
+		t.FailNow()
+	}
+	defer res.Body.Close()
+	if body, _ := io.ReadAll(res.Body); string(body) != "hijacked" {
+		t.Logf("got %q from hijacking handler", body)
+		t.Fail()
+	}
+}
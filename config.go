@@ -0,0 +1,217 @@
+package httpjail
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"time"
+)
+
+// Config is the JSON form of a jail's limits, read by LoadConfig. Durations are strings such as "90s" or "1m".
+//
+//	{
+//		"allowed_requests": 100,
+//		"window": "1m",
+//		"cooloff": "5m",
+//		"routes": [
+//			{"method": "POST", "path": "/login", "allowed_requests": 5, "window": "1m"},
+//			{"pattern": "/users/*/posts", "allowed_requests": 20, "window": "1m"}
+//		],
+//		"host_limits": {"api.example.com": {"allowed_requests": 1000, "window": "1m"}},
+//		"origin_allowlist": ["https://example.com"],
+//		"first_party_limit": {"allowed_requests": 500, "window": "1m"}
+//	}
+type Config struct {
+	AllowedRequests int                    `json:"allowed_requests"`
+	SoftLimit       int                    `json:"soft_limit"`
+	Window          Duration               `json:"window"`
+	Cooloff         Duration               `json:"cooloff"`
+	Routes          []RouteConfig          `json:"routes"`
+	HostLimits      map[string]LimitConfig `json:"host_limits"`
+	OriginAllowlist []string               `json:"origin_allowlist"`
+	FirstPartyLimit *LimitConfig           `json:"first_party_limit"`
+}
+
+// LimitConfig is the JSON form of a Limit
+type LimitConfig struct {
+	AllowedRequests int      `json:"allowed_requests"`
+	SoftLimit       int      `json:"soft_limit"`
+	Window          Duration `json:"window"`
+	Cooloff         Duration `json:"cooloff"`
+	BlockStatus     int      `json:"block_status"`
+}
+
+// RouteConfig is the JSON form of a RouteLimit. Set one of Path (a prefix), Pattern (a PathPattern wildcard) or
+// Regexp.
+type RouteConfig struct {
+	Method  string `json:"method"`
+	Path    string `json:"path"`
+	Pattern string `json:"pattern"`
+	Regexp  string `json:"regexp"`
+	LimitConfig
+}
+
+// Duration is a time.Duration read from a JSON string such as "1m30s"
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration string
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"1m\": %s", data)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalJSON writes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// LoadConfig reads a Config from r and swaps it in as the jail's limits, routes and origin allowlist. The config is
+// validated before anything is applied, so on error the live limits are left untouched. Requests in flight see
+// either the old limits or the new ones, never a mix, which makes it safe to call from a SIGHUP handler.
+func (j *Jail) LoadConfig(r io.Reader) error {
+	decoder := json.NewDecoder(r)
+	decoder.DisallowUnknownFields()
+
+	var config Config
+	if err := decoder.Decode(&config); err != nil {
+		return fmt.Errorf("httpjail: invalid config: %w", err)
+	}
+
+	limits, err := config.limits()
+	if err != nil {
+		return fmt.Errorf("httpjail: invalid config: %w", err)
+	}
+
+	j.limitsMux.Lock()
+	j.AllowedRequests = limits.base.AllowedRequests
+	j.SoftLimit = limits.base.SoftLimit
+	j.Window = limits.base.Window
+	j.Cooloff = limits.base.Cooloff
+	j.Routes = limits.routes
+	j.HostLimits = limits.hosts
+	j.OriginAllowlist = config.OriginAllowlist
+	j.FirstPartyLimit = limits.firstParty
+	j.limitsMux.Unlock()
+	return nil
+}
+
+// window returns the jail's default window
+func (j *Jail) window() time.Duration {
+	j.limitsMux.RLock()
+	defer j.limitsMux.RUnlock()
+	return j.Window
+}
+
+// loadedLimits holds a validated config converted to the jail's types
+type loadedLimits struct {
+	base       Limit
+	routes     []RouteLimit
+	hosts      map[string]Limit
+	firstParty *Limit
+}
+
+// limits validates the config and converts it to the jail's types
+func (c Config) limits() (loadedLimits, error) {
+	var limits loadedLimits
+
+	def := LimitConfig{AllowedRequests: c.AllowedRequests, SoftLimit: c.SoftLimit, Window: c.Window, Cooloff: c.Cooloff}
+	limit, err := def.limit()
+	if err != nil {
+		return limits, err
+	}
+	limits.base = limit
+
+	for i, route := range c.Routes {
+		routeLimit, err := route.routeLimit()
+		if err != nil {
+			return limits, fmt.Errorf("route %d: %w", i, err)
+		}
+		limits.routes = append(limits.routes, routeLimit)
+	}
+
+	if len(c.HostLimits) > 0 {
+		limits.hosts = make(map[string]Limit, len(c.HostLimits))
+	}
+	for host, hostLimit := range c.HostLimits {
+		limit, err := hostLimit.limit()
+		if err != nil {
+			return limits, fmt.Errorf("host %q: %w", host, err)
+		}
+		limits.hosts[host] = limit
+	}
+
+	if c.FirstPartyLimit != nil {
+		limit, err := c.FirstPartyLimit.limit()
+		if err != nil {
+			return limits, fmt.Errorf("first party limit: %w", err)
+		}
+		limits.firstParty = &limit
+	}
+
+	for _, origin := range c.OriginAllowlist {
+		if origin == "" {
+			return limits, errors.New("empty origin in allowlist")
+		}
+	}
+	return limits, nil
+}
+
+// limit validates the limit and converts it to a Limit
+func (c LimitConfig) limit() (Limit, error) {
+	if c.AllowedRequests < 0 || c.SoftLimit < 0 {
+		return Limit{}, errors.New("request limits can't be negative")
+	}
+	if c.Window <= 0 {
+		return Limit{}, errors.New("window must be positive")
+	}
+	if c.Cooloff < 0 {
+		return Limit{}, errors.New("cooloff can't be negative")
+	}
+	return Limit{
+		AllowedRequests: c.AllowedRequests,
+		SoftLimit:       c.SoftLimit,
+		Window:          time.Duration(c.Window),
+		Cooloff:         time.Duration(c.Cooloff),
+		BlockStatus:     c.BlockStatus,
+	}, nil
+}
+
+// routeLimit validates the route, compiling its pattern, and converts it to a RouteLimit
+func (c RouteConfig) routeLimit() (RouteLimit, error) {
+	limit, err := c.LimitConfig.limit()
+	if err != nil {
+		return RouteLimit{}, err
+	}
+	route := RouteLimit{Method: c.Method, Path: c.Path, Limit: limit}
+
+	set := 0
+	for _, match := range []string{c.Path, c.Pattern, c.Regexp} {
+		if match != "" {
+			set++
+		}
+	}
+	if set != 1 {
+		return RouteLimit{}, errors.New("set exactly one of path, pattern or regexp")
+	}
+
+	switch {
+	case c.Pattern != "":
+		route.Pattern = PathPattern(c.Pattern)
+	case c.Regexp != "":
+		route.Pattern, err = regexp.Compile(c.Regexp)
+		if err != nil {
+			return RouteLimit{}, err
+		}
+	}
+	return route, nil
+}
@@ -0,0 +1,124 @@
+package httpjail
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLoadConfig(t *testing.T) {
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, 0, 100)
+
+	config := `{
+		"allowed_requests": 2,
+		"window": "30s",
+		"cooloff": "1m",
+		"routes": [
+			{"method": "POST", "path": "/login", "allowed_requests": 1, "window": "1m"},
+			{"pattern": "/users/*/posts", "allowed_requests": 3, "window": "1m"},
+			{"regexp": "^/orders/[0-9]+$", "allowed_requests": 4, "window": "1m", "block_status": 429}
+		],
+		"host_limits": {"api.example.com": {"allowed_requests": 50, "window": "1m"}},
+		"origin_allowlist": ["https://example.com"],
+		"first_party_limit": {"allowed_requests": 10, "window": "1m"}
+	}`
+	if err := jail.LoadConfig(strings.NewReader(config)); err != nil {
+		t.Logf("valid config rejected: %s", err)
+		t.FailNow()
+	}
+
+	if jail.AllowedRequests != 2 || jail.Window != 30*time.Second || jail.Cooloff != time.Minute {
+		t.Logf("default limit not applied: %d per %s, cooloff %s", jail.AllowedRequests, jail.Window, jail.Cooloff)
+		t.Fail()
+	}
+
+	cases := []struct {
+		method, path string
+		allowed      int
+	}{
+		{"POST", "/login", 1},
+		{"GET", "/login", 2},
+		{"GET", "/users/42/posts", 3},
+		{"GET", "/orders/7", 4},
+		{"GET", "/", 2},
+	}
+	for _, c := range cases {
+		rule := jail.ruleFor(httptest.NewRequest(c.method, c.path, nil), "1.2.3.4")
+		if rule.AllowedRequests != c.allowed {
+			t.Logf("%s %s got a limit of %d, expected %d", c.method, c.path, rule.AllowedRequests, c.allowed)
+			t.Fail()
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Origin", "https://example.com")
+	if rule := jail.ruleFor(req, "1.2.3.4"); rule.AllowedRequests != 10 {
+		t.Logf("first party request got a limit of %d, expected 10", rule.AllowedRequests)
+		t.Fail()
+	}
+
+	req = httptest.NewRequest("GET", "http://api.example.com/", nil)
+	if rule := jail.ruleFor(req, "1.2.3.4"); rule.AllowedRequests != 50 {
+		t.Logf("host request got a limit of %d, expected 50", rule.AllowedRequests)
+		t.Fail()
+	}
+}
+
+func TestLoadInvalidConfig(t *testing.T) {
+	configs := map[string]string{
+		"malformed json":    `{"allowed_requests": 2,`,
+		"unknown field":     `{"allowed_requests": 2, "window": "1m", "burst": 5}`,
+		"bad duration":      `{"allowed_requests": 2, "window": "soon"}`,
+		"numeric duration":  `{"allowed_requests": 2, "window": 60}`,
+		"missing window":    `{"allowed_requests": 2}`,
+		"negative limit":    `{"allowed_requests": -1, "window": "1m"}`,
+		"bad regexp":        `{"allowed_requests": 2, "window": "1m", "routes": [{"regexp": "(", "window": "1m"}]}`,
+		"ambiguous route":   `{"allowed_requests": 2, "window": "1m", "routes": [{"path": "/a", "pattern": "/b", "window": "1m"}]}`,
+		"bad host limit":    `{"allowed_requests": 2, "window": "1m", "host_limits": {"a.com": {"allowed_requests": 1}}}`,
+		"empty origin":      `{"allowed_requests": 2, "window": "1m", "origin_allowlist": [""]}`,
+		"bad route halfway": `{"allowed_requests": 2, "window": "1m", "routes": [{"path": "/a", "window": "1m"}, {"path": "/b"}]}`,
+	}
+
+	for name, config := range configs {
+		jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, time.Hour, 100)
+		jail.Routes = []RouteLimit{{Path: "/live", Limit: Limit{AllowedRequests: 7, Window: time.Minute}}}
+
+		if err := jail.LoadConfig(strings.NewReader(config)); err == nil {
+			t.Logf("%s: config accepted", name)
+			t.Fail()
+		}
+
+		// the live config is untouched
+		if jail.AllowedRequests != 100 || jail.Window != time.Minute || jail.Cooloff != time.Hour ||
+			len(jail.Routes) != 1 || jail.Routes[0].Path != "/live" {
+			t.Logf("%s: live config disrupted", name)
+			t.Fail()
+		}
+	}
+}
+
+func TestLoadConfigUnderTraffic(t *testing.T) {
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, 0, 100)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for n := 0; n < 100; n++ {
+				serveJail(jail, makeRequest(fmt.Sprintf("10.0.%d.%d", i, n), false))
+			}
+		}(i)
+	}
+	for i := 0; i < 20; i++ {
+		config := fmt.Sprintf(`{"allowed_requests": %d, "window": "1m", "routes": [{"path": "/a", "window": "1m"}]}`, i)
+		if err := jail.LoadConfig(strings.NewReader(config)); err != nil {
+			t.Log(err)
+			t.Fail()
+		}
+	}
+	wg.Wait()
+}
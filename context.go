@@ -0,0 +1,21 @@
+package httpjail
+
+import (
+	"context"
+	"net/http"
+)
+
+// decisionKey is the context key holding the jail's decision on a request
+type decisionKey struct{}
+
+// withDecision attaches the decision to the request's context
+func withDecision(req *http.Request, decision Decision) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), decisionKey{}, decision))
+}
+
+// InfoFromContext returns the decision the jail made on the request whose context this is, so handlers can see
+// the visitor's count, remaining requests and reset time. ok is false unless the jail has ContextInfo set.
+func InfoFromContext(ctx context.Context) (decision Decision, ok bool) {
+	decision, ok = ctx.Value(decisionKey{}).(Decision)
+	return decision, ok
+}
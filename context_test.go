@@ -0,0 +1,46 @@
+package httpjail
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestInfoFromContext(t *testing.T) {
+	clock := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	jail := NewJailForTesting(clock, time.Minute, 0, 3)
+	jail.ContextInfo = true
+
+	var info Decision
+	var found bool
+	handler := jail.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		info, found = InfoFromContext(req.Context())
+	}))
+
+	for i := 1; i <= 3; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), makeRequest("1.2.3.4", false))
+		if !found {
+			t.Log("no info in the request context")
+			t.FailNow()
+		}
+		if info.Count != i || info.Remaining != 3-i || !info.Reset.Equal(clock.Now().Add(time.Minute)) {
+			t.Logf("request %d: count %d, remaining %d, reset %s", i, info.Count, info.Remaining, info.Reset)
+			t.Fail()
+		}
+		if info.Key != "1.2.3.4" || info.Limit.AllowedRequests != 3 {
+			t.Logf("request %d: unexpected key %q or limit %d", i, info.Key, info.Limit.AllowedRequests)
+			t.Fail()
+		}
+		clock.Advance(time.Second)
+	}
+
+	// without the option the context is left alone
+	jail.ContextInfo = false
+	found = false
+	handler.ServeHTTP(httptest.NewRecorder(), makeRequest("5.6.7.8", false))
+	if found {
+		t.Log("info attached without ContextInfo set")
+		t.Fail()
+	}
+}
@@ -0,0 +1,12 @@
+package httpjail
+
+import (
+	"net"
+	"net/http"
+)
+
+// isCountOnly reports whether the request's client IP is in CountOnlyIPs. In proxy mode the client IP has already
+// been resolved from X-Forwarded-For.
+func (j *Jail) isCountOnly(req *http.Request) bool {
+	return inNetworks(net.ParseIP(stripPort(req.RemoteAddr)), j.CountOnlyIPs)
+}
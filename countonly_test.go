@@ -0,0 +1,75 @@
+package httpjail
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWouldBlockHeader(t *testing.T) {
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, time.Hour, 1)
+	jail.CountOnlyIPs, _ = ParseNetworks("10.0.0.1")
+	jail.WouldBlockHeader = true
+
+	handler := jail.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(successRes))
+	}))
+	serve := func(addr string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, makeRequest(addr, false))
+		return rec
+	}
+
+	if rec := serve("10.0.0.1:5000"); rec.Header().Get(headerWouldBlock) != "" {
+		t.Log("request under the limit flagged")
+		t.Fail()
+	}
+
+	for i := 0; i < 3; i++ {
+		rec := serve("10.0.0.1:5000")
+		if rec.Body.String() != successRes {
+			t.Logf("count-only client blocked on request %d", i)
+			t.Fail()
+		}
+		if rec.Header().Get(headerWouldBlock) != "true" {
+			t.Logf("flagged count-only client missing %s on request %d", headerWouldBlock, i)
+			t.Fail()
+		}
+	}
+
+	// count-only clients are never sentenced
+	if _, jailed := jail.Sentences["10.0.0.1"]; jailed {
+		t.Log("count-only client sentenced")
+		t.Fail()
+	}
+
+	// other clients are blocked as usual
+	serve("10.0.0.2")
+	if rec := serve("10.0.0.2"); rec.Body.String() == successRes || rec.Header().Get(headerWouldBlock) != "" {
+		t.Log("client outside the count-only list wasn't blocked")
+		t.Fail()
+	}
+}
+
+func TestCountOnlyIPForms(t *testing.T) {
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, 0, 1)
+	jail.CountOnlyIPs, _ = ParseNetworks("192.168.0.0/16", "2001:db8::1")
+
+	for addr, countOnly := range map[string]bool{
+		"192.168.4.2:5000":                 true,
+		"192.169.0.1:5000":                 false,
+		"[2001:db8:0:0:0:0:0:1]:5000":      true,
+		"[2001:0db8::0001]:5000":           true,
+		"[2001:db8::2]:5000":               false,
+		"not an ip":                        false,
+		"[2001:db8:0000:0000::0001]:65535": true,
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = addr
+		if got := jail.isCountOnly(req); got != countOnly {
+			t.Logf("%s count-only: %t, expected %t", addr, got, countOnly)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,357 @@
+package httpjail
+
+import (
+	"net/http"
+	"time"
+)
+
+// Decision describes the jail's verdict on a single request
+type Decision struct {
+	// visitor key the request was counted under
+	Key string
+	// was the request allowed through?
+	Allowed bool
+	// requests counted in the current window, including this one
+	Count int
+	// requests left in the current window
+	Remaining int
+	// time the current window resets
+	Reset time.Time
+	// how long a blocked visitor should wait before retrying
+	RetryAfter time.Duration
+	// limit applied to the request
+	Limit Limit
+	// full X-Forwarded-For chain as received, only captured in proxy mode
+	ForwardedFor []string
+	// time the decision was made
+	Time time.Time
+	// was the request allowed only because the client is in CountOnlyIPs or the jail is in DryRun?
+	WouldBlock bool
+	// distinct IPs the request's account used in the window, only counted when MaxAccountIPs is set
+	AccountIPs int
+	// store error that prevented counting the request, which is then allowed only if the jail fails open
+	Err error
+}
+
+// Allow runs the jail's checks on a request without responding to it, for handlers that build their own blocked
+// responses. It logs and counts the request, sentences violators and fires the jail's callbacks just as Middleware
+// does, returning whether the request may proceed and, if not, how long the client should wait. MaxEventStreams
+// isn't applied, as open streams can only be tracked by serving them. A store error blocks the request with no wait
+// unless the jail fails open.
+func (j *Jail) Allow(req *http.Request) (allowed bool, retryAfter time.Duration) {
+	if decision, screened := j.screen(req); screened {
+		return decision.Allowed, 0
+	}
+	decision := j.judge(req)
+	return decision.Allowed, decision.RetryAfter
+}
+
+// screen settles requests the jail doesn't count: every request while the jail is disabled, and those from
+// DeniedIPs and AllowedIPs. screened is false for requests that need a decision.
+func (j *Jail) screen(req *http.Request) (decision Decision, screened bool) {
+	if j.Disabled() {
+		if j.TrackWhileDisabled {
+			if decision, _ := j.identify(req); decision.Key != "" {
+				j.visitors.LogVisit(decision.Key)
+			}
+		}
+		return Decision{Allowed: true}, true
+	}
+	if len(j.DeniedIPs) > 0 && j.isDenylisted(req) {
+		return Decision{Allowed: j.DryRun}, true
+	}
+	if len(j.AllowedIPs) > 0 && j.isAllowlisted(req) {
+		return Decision{Allowed: true}, true
+	}
+	return Decision{}, false
+}
+
+// judge decides the request and fires OnDecision and OnFirstBlock
+func (j *Jail) judge(req *http.Request) Decision {
+	decision := j.decide(req)
+	if j.OnDecision != nil {
+		j.OnDecision(decision)
+	}
+	blocked := !decision.Allowed || j.DryRun && decision.WouldBlock
+	if j.OnFirstBlock != nil && decision.Key != "" && decision.Err == nil &&
+		j.trackBlocked(decision.Key, blocked, decision.Time) {
+		j.OnFirstBlock(decision.Key)
+	}
+	return decision
+}
+
+// decide logs the request and decides whether it may proceed, sentencing violators
+func (j *Jail) decide(req *http.Request) Decision {
+	decision := j.evaluate(req)
+	if !decision.Allowed && decision.Err == nil && decision.RetryAfter == 0 {
+		decision.RetryAfter = j.retryAfter(decision)
+	}
+	return decision
+}
+
+// retryAfter returns how long a blocked visitor should wait: until its sentence is served, its log resets, or
+// otherwise a window
+func (j *Jail) retryAfter(decision Decision) time.Duration {
+	if wait := j.sentenceLeft(decision.Key, decision.Time); wait > 0 {
+		return wait
+	}
+	if waiter, ok := j.visitors.(nextAllower); ok {
+		// blocks by other limits, such as KeyLimits, don't wait on the visitor's own log
+		if next := waiter.nextAllowed(decision.Key, decision.Time); next.After(decision.Time) {
+			return next.Sub(decision.Time)
+		}
+	}
+	// logs resetting on their own schedule, such as fixed windows, reopen at the reset the headers advertise
+	if _, ok := j.visitors.(ResettingVisitorLog); ok && decision.Reset.After(decision.Time) {
+		return decision.Reset.Sub(decision.Time)
+	}
+	return decision.Limit.Window
+}
+
+// sentenceLeft returns how much of key's sentence remains, 0 if it isn't jailed
+func (j *Jail) sentenceLeft(key string, now time.Time) time.Duration {
+	j.sentenceMux.RLock()
+	release, jailed := j.Sentences[key]
+	j.sentenceMux.RUnlock()
+
+	if jailed && release.After(now) {
+		return release.Sub(now)
+	}
+	return 0
+}
+
+// nextAllower is implemented by visitor logs that know when a blocked visitor's next request will be allowed, such
+// as a token bucket waiting on its next token
+type nextAllower interface {
+	nextAllowed(key string, now time.Time) time.Time
+}
+
+// evaluate counts the request against its rule and decides whether it's allowed
+func (j *Jail) evaluate(req *http.Request) Decision {
+	decision, rule := j.identify(req)
+	key := decision.Key
+
+	// requests that can't be attributed to a visitor fail open
+	if key == "" {
+		decision.Allowed = true
+		return decision
+	}
+	// as do unmetered ones, without being counted
+	if j.Metered != nil && !j.Metered(req) {
+		decision.Allowed = true
+		return decision
+	}
+	countOnly := j.DryRun || len(j.CountOnlyIPs) > 0 && j.isCountOnly(req)
+
+	// a served sentence wipes the slate clean, so stale visits from the offense can't re-trigger a block
+	if j.releaseExpired(key, decision.Time) {
+		if resetter, ok := j.visitors.(visitorResetter); ok {
+			resetter.Reset(key)
+			resetter.Reset(errorBucket(key))
+		}
+	}
+
+	if j.ErrorBudget > 0 {
+		over, err := j.overErrorBudget(key, decision.Time.Add(-rule.Window))
+		if err != nil {
+			decision.Err = err
+			decision.Allowed = j.FailOpen
+			return decision
+		}
+		if over {
+			return j.reject(decision, rule, countOnly)
+		}
+	}
+
+	if j.MaxAccountIPs > 0 && j.AccountFunc != nil && j.isSharedAccount(req, &decision, rule.Window) {
+		return j.reject(decision, rule, countOnly)
+	}
+
+	if len(j.KeyLimits) > 0 {
+		keyLimit, bucket, over, err := j.overKeyLimit(req, decision.Time)
+		if err != nil {
+			decision.Err = err
+			decision.Allowed = j.FailOpen
+			return decision
+		}
+		if over {
+			decision.Limit = keyLimit.Limit
+			if countOnly {
+				decision.Allowed, decision.WouldBlock = true, true
+				if j.DryRun && keyLimit.Cooloff > 0 {
+					j.wouldSentence(bucket, keyLimit.Cooloff, decision.Time)
+				}
+			} else if keyLimit.Cooloff > 0 {
+				j.sentence(bucket, keyLimit.Cooloff, decision.Time)
+			}
+			// the cooloff is served by the bucket, not the visitor's own key
+			if !decision.Allowed {
+				decision.RetryAfter = j.sentenceLeft(bucket, decision.Time)
+			}
+			return decision
+		}
+	}
+
+	if j.SlowRequest > 0 && j.isSlowVisitor(key) {
+		rule.AllowedRequests = j.slowLimit(rule.AllowedRequests)
+		decision.Limit = rule.Limit
+	}
+
+	if j.LeadingEdge {
+		decision = j.decideLeadingEdge(decision, rule)
+		if !decision.Allowed && countOnly && decision.Err == nil {
+			decision.Allowed, decision.WouldBlock = true, true
+		}
+		return decision
+	}
+
+	since := decision.Time.Add(-rule.Window)
+	// retries of an already counted request are checked against the budget without consuming it
+	retry := j.isRetry(req, key, decision.Time)
+	unlogger, unloggable := j.visitors.(visitUnlogger)
+	switch {
+	case retry:
+		decision.Count, decision.Err = j.countVisits(key, since)
+	case j.LogAllowedOnly && !unloggable:
+		// count the request as if logged, logging it only once it's allowed
+		decision.Count, decision.Err = j.countVisits(key, since)
+		decision.Count++
+	default:
+		decision.Count, decision.Err = j.logAndCount(key, since)
+	}
+	if decision.Err != nil {
+		decision.Allowed = j.FailOpen
+		return decision
+	}
+	decision.setRemaining()
+	j.setReset(&decision)
+
+	extra, extraCount, overExtra, err := j.overExtraLimits(key, decision.Time, retry)
+	if err != nil {
+		decision.Err = err
+		decision.Allowed = j.FailOpen
+		return decision
+	}
+	withinRule := decision.Count <= rule.AllowedRequests
+	sentenced := j.isSentenced(key, decision.Time) || j.DryRun && j.wouldBeSentenced(key, decision.Time)
+	if !sentenced && withinRule && !overExtra {
+		if j.LogAllowedOnly && !unloggable && !retry {
+			j.visitors.LogVisit(key)
+		}
+		if j.LogAllowedOnly && !retry {
+			j.logExtraLimits(key)
+		}
+		decision.Allowed = true
+		return decision
+	}
+
+	// the visit was logged atomically with the count, take it back now the request is blocked
+	if j.LogAllowedOnly && unloggable && !retry {
+		unlogger.UnlogVisit(key)
+	}
+	// report the most restrictive limit the request broke
+	if overExtra && (withinRule || extra.Window > rule.Window) {
+		rule = extra
+		decision.Limit, decision.Count = extra.Limit, extraCount
+		decision.setRemaining()
+	}
+	return j.reject(decision, rule, countOnly)
+}
+
+// reject blocks the request and sentences the visitor, or lets a count-only client through flagged as WouldBlock
+func (j *Jail) reject(decision Decision, rule rule, countOnly bool) Decision {
+	if countOnly {
+		decision.Allowed, decision.WouldBlock = true, true
+		if j.DryRun && rule.Cooloff > 0 {
+			j.wouldSentence(decision.Key, rule.Cooloff, decision.Time)
+		}
+		return decision
+	}
+
+	// without a cooloff the block lasts only as long as the window is over the limit
+	if rule.Cooloff > 0 {
+		j.sentence(decision.Key, rule.Cooloff, decision.Time)
+	}
+	return decision
+}
+
+// visitUnlogger is implemented by visitor logs that can take back a visit, letting LogAllowedOnly log and count
+// atomically and undo the visit if the request is blocked, instead of checking and logging in separate steps
+type visitUnlogger interface {
+	// UnlogVisit removes the visitor's most recent visit
+	UnlogVisit(key string)
+}
+
+// logAndCount logs a visit and counts the key's visits since the provided time, atomically if the visitor log
+// supports it. Only a FallibleVisitorLog can return an error.
+func (j *Jail) logAndCount(key string, since time.Time) (int, error) {
+	if fallible, ok := j.visitors.(FallibleVisitorLog); ok {
+		return fallible.TryIncrementAndCount(key, since)
+	}
+	if incrementer, ok := j.visitors.(IncrementingVisitorLog); ok {
+		return incrementer.IncrementAndCount(key, since), nil
+	}
+	j.visitors.LogVisit(key)
+	return j.visitors.CountVisits(key, since), nil
+}
+
+// identify resolves the visitor behind a request and the rule that applies to it, without counting anything.
+// Requests with no visitor key get a decision with an empty Key.
+func (j *Jail) identify(req *http.Request) (Decision, rule) {
+	decision := Decision{Time: j.now()}
+
+	// rewrite RemoteAddr if proxied
+	if j.isProxied {
+		decision.ForwardedFor = j.forwardedChain(req)
+		req.RemoteAddr = j.clientAddr(req, decision.ForwardedFor)
+	}
+
+	visitor := j.key(req)
+	if visitor == "" {
+		visitor = j.UnknownVisitorKey
+	}
+	if visitor == "" {
+		return decision, rule{}
+	}
+
+	rule := j.ruleFor(req, visitor)
+	decision.Key = rule.bucket(visitor)
+	decision.Limit = rule.Limit
+	return decision, rule
+}
+
+// decideLeadingEdge throttles the key to one request per window: the first request passes immediately and later
+// ones are blocked until a full window has passed since the last allowed request. Blocked requests aren't logged,
+// so they don't extend the quiet period.
+func (j *Jail) decideLeadingEdge(decision Decision, rule rule) Decision {
+	since := decision.Time.Add(-rule.Window)
+	decision.Count, decision.Err = j.countVisits(decision.Key, since)
+	if decision.Err == nil && decision.Count == 0 {
+		_, decision.Err = j.logAndCount(decision.Key, since)
+		decision.Count = 1
+		decision.Allowed = decision.Err == nil
+	}
+	if decision.Err != nil {
+		decision.Allowed = j.FailOpen
+		return decision
+	}
+	decision.setRemaining()
+	j.setReset(&decision)
+	return decision
+}
+
+// setReset points the decision's reset at the visitor log's own reset time, if it provides one
+func (j *Jail) setReset(d *Decision) {
+	if resetter, ok := j.visitors.(ResettingVisitorLog); ok {
+		d.Reset = resetter.ResetAt(d.Key, d.Time)
+	}
+}
+
+// setRemaining fills in the requests remaining in the window and when it resets
+func (d *Decision) setRemaining() {
+	d.Remaining = d.Limit.AllowedRequests - d.Count
+	if d.Remaining < 0 {
+		d.Remaining = 0
+	}
+	d.Reset = d.Time.Add(d.Limit.Window)
+}
@@ -0,0 +1,375 @@
+package httpjail
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDecisionForwardedChain(t *testing.T) {
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, 0, 5)
+	jail.IsProxied()
+
+	var decisions []Decision
+	jail.OnDecision = func(d Decision) {
+		decisions = append(decisions, d)
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Add("X-Forwarded-For", "203.0.113.7, 10.0.0.1")
+	req.Header.Add("X-Forwarded-For", "10.0.0.2")
+	serveJail(jail, req)
+
+	if len(decisions) != 1 {
+		t.Logf("expected 1 decision, got %d", len(decisions))
+		t.FailNow()
+	}
+
+	expected := []string{"203.0.113.7", "10.0.0.1", "10.0.0.2"}
+	chain := decisions[0].ForwardedFor
+	if len(chain) != len(expected) {
+		t.Logf("incorrect chain: got %v, expected %v", chain, expected)
+		t.FailNow()
+	}
+	for i := range expected {
+		if chain[i] != expected[i] {
+			t.Logf("incorrect chain: got %v, expected %v", chain, expected)
+			t.Fail()
+		}
+	}
+
+	if !decisions[0].Allowed || decisions[0].Count != 1 {
+		t.Logf("incorrect decision: %#v", decisions[0])
+		t.Fail()
+	}
+}
+
+func TestLeadingEdge(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	jail := NewJailForTesting(clock, 10*time.Second, 0, 5)
+	jail.LeadingEdge = true
+
+	if !serveJail(jail, makeRequest("1.2.3.4", false)) {
+		t.Log("leading request denied")
+		t.Fail()
+	}
+
+	// later requests in the interval are blocked even though AllowedRequests isn't reached
+	for i := 0; i < 3; i++ {
+		clock.Advance(time.Second)
+		if serveJail(jail, makeRequest("1.2.3.4", false)) {
+			t.Logf("request %d within the quiet period allowed", i)
+			t.Fail()
+		}
+	}
+
+	// blocked requests don't extend the quiet period
+	clock.Advance(8 * time.Second)
+	if !serveJail(jail, makeRequest("1.2.3.4", false)) {
+		t.Log("request after the quiet period denied")
+		t.Fail()
+	}
+	if serveJail(jail, makeRequest("1.2.3.4", false)) {
+		t.Log("request right after the second leading request allowed")
+		t.Fail()
+	}
+}
+
+func TestEmptyRemoteAddr(t *testing.T) {
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, 0, 1)
+
+	var decisions []Decision
+	jail.OnDecision = func(d Decision) {
+		decisions = append(decisions, d)
+	}
+
+	// without a key for unknown visitors, unattributable requests fail open and aren't tracked
+	for i := 0; i < 3; i++ {
+		if !serveJail(jail, makeRequest("", false)) {
+			t.Logf("request %d with an empty RemoteAddr denied", i)
+			t.Fail()
+		}
+	}
+	if decisions[0].Key != "" || decisions[0].Count != 0 {
+		t.Logf("unattributable request was counted: %#v", decisions[0])
+		t.Fail()
+	}
+
+	// with a key for unknown visitors they share one bucket
+	jail.UnknownVisitorKey = "unknown"
+	if !serveJail(jail, makeRequest("", false)) {
+		t.Log("first unknown visitor request denied")
+		t.Fail()
+	}
+	if serveJail(jail, makeRequest("", false)) {
+		t.Log("second unknown visitor request allowed, should share the unknown bucket")
+		t.Fail()
+	}
+	if !serveJail(jail, makeRequest("1.2.3.4", false)) {
+		t.Log("known visitor denied, shouldn't share the unknown bucket")
+		t.Fail()
+	}
+}
+
+func TestMalformedRequests(t *testing.T) {
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, 0, 1)
+	jail.IsProxied()
+	jail.KeyFunc = KeyFuncChain(KeyByCookie("session"), KeyByHost, KeyByOAuthClientID(nil))
+	jail.HostLimits = map[string]Limit{"example.com": {AllowedRequests: 1, Window: time.Minute}}
+	jail.Routes = []RouteLimit{{Method: "GET", Path: "/", Limit: Limit{AllowedRequests: 1, Window: time.Minute}}}
+	jail.OriginAllowlist = []string{"https://example.com"}
+	jail.FirstPartyLimit = &Limit{AllowedRequests: 1, Window: time.Minute}
+	jail.IdempotencyWindow = time.Minute
+	jail.MaxEventStreams = 1
+
+	malformed := map[string]func() *http.Request{
+		"nil url": func() *http.Request {
+			return &http.Request{Method: "GET", Header: http.Header{}, RemoteAddr: "1.1.1.1"}
+		},
+		"nil header": func() *http.Request {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header = nil
+			req.Host = "a.example.com"
+			req.RemoteAddr = "2.2.2.2"
+			return req
+		},
+		"empty method": func() *http.Request {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Method = ""
+			req.Host = "b.example.com"
+			req.RemoteAddr = "3.3.3.3"
+			return req
+		},
+		"empty request": func() *http.Request {
+			return &http.Request{}
+		},
+	}
+
+	for name, makeReq := range malformed {
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Logf("%s: middleware panicked: %v", name, r)
+					t.Fail()
+				}
+			}()
+
+			if !serveJail(jail, makeReq()) {
+				t.Logf("%s: first request denied", name)
+				t.Fail()
+			}
+		}()
+	}
+
+	// an empty method is treated as GET for route matching
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Method = ""
+	if _, ok := jail.matchRoute(req); !ok {
+		t.Log("request with an empty method didn't match a GET route")
+		t.Fail()
+	}
+}
+
+func TestConnectRequests(t *testing.T) {
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, 0, 1)
+	jail.Routes = []RouteLimit{{Path: "/", Limit: Limit{AllowedRequests: 100, Window: time.Minute}}}
+
+	var decisions []Decision
+	jail.OnDecision = func(d Decision) {
+		decisions = append(decisions, d)
+	}
+
+	connect := func(target string) *http.Request {
+		return &http.Request{
+			Method:     http.MethodConnect,
+			URL:        &url.URL{Host: target},
+			Host:       target,
+			Header:     http.Header{},
+			RemoteAddr: "1.2.3.4",
+		}
+	}
+
+	handler := jail.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, connect("a.example.com:443"))
+	if rec.Code != http.StatusOK {
+		t.Logf("first tunnel: got status %d", rec.Code)
+		t.Fail()
+	}
+
+	// tunnels to a different target are still the same client
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, connect("b.example.com:443"))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Logf("blocked tunnel: got status %d, expected %d", rec.Code, http.StatusTooManyRequests)
+		t.Fail()
+	}
+
+	for _, d := range decisions {
+		if d.Key != "1.2.3.4" {
+			t.Logf("tunnel keyed by %q, expected the client address", d.Key)
+			t.Fail()
+		}
+	}
+}
+
+func TestNoOverAdmission(t *testing.T) {
+	logs := map[string]func(clock Clock) VisitorLog{
+		"default": func(clock Clock) VisitorLog {
+			log := NewDefaultVisitorLog()
+			log.Clock = clock
+			return log
+		},
+		"fixed window": func(clock Clock) VisitorLog {
+			log := NewFixedWindowLog(time.Hour)
+			log.Clock = clock
+			return log
+		},
+	}
+
+	for name, newLog := range logs {
+		for _, allowedOnly := range []bool{false, true} {
+			clock := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+			jail := NewJail(newLog(clock), time.Hour, 0, 10)
+			jail.Clock = clock
+			jail.LogAllowedOnly = allowedOnly
+
+			var admitted int32
+			var wg sync.WaitGroup
+			for i := 0; i < 200; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					if serveJail(jail, makeRequest("1.2.3.4", false)) {
+						atomic.AddInt32(&admitted, 1)
+					}
+				}()
+			}
+			wg.Wait()
+
+			if admitted > 10 {
+				t.Logf("%s, LogAllowedOnly %v: %d concurrent requests admitted, past the limit of 10", name,
+					allowedOnly, admitted)
+				t.Fail()
+			}
+			if count := jail.visitors.CountVisits("1.2.3.4", clock.Now().Add(-time.Hour)); allowedOnly &&
+				count != int(admitted) {
+				t.Logf("%s: %d visits logged for %d admitted requests", name, count, admitted)
+				t.Fail()
+			}
+		}
+	}
+}
+
+func TestMetered(t *testing.T) {
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, 0, 2)
+	jail.Metered = func(req *http.Request) bool {
+		return req.Header.Get("Authorization") != ""
+	}
+
+	authenticated := func() *http.Request {
+		req := makeRequest("1.2.3.4", false)
+		req.Header.Set("Authorization", "Bearer token")
+		return req
+	}
+
+	for i := 0; i < 10; i++ {
+		if !serveJail(jail, makeRequest("1.2.3.4", false)) {
+			t.Logf("anonymous request %d blocked", i+1)
+			t.FailNow()
+		}
+	}
+	if count := jail.visitors.CountVisits("1.2.3.4", time.Time{}); count != 0 {
+		t.Logf("anonymous requests counted %d visits, expected none", count)
+		t.Fail()
+	}
+
+	for i := 0; i < 2; i++ {
+		if !serveJail(jail, authenticated()) {
+			t.Logf("authenticated request %d blocked within the limit", i+1)
+			t.Fail()
+		}
+	}
+	if serveJail(jail, authenticated()) {
+		t.Log("authenticated request allowed past the limit")
+		t.Fail()
+	}
+
+	// the same client browsing anonymously is unaffected by its metered block
+	if !serveJail(jail, makeRequest("1.2.3.4", false)) {
+		t.Log("anonymous request blocked after the client's authenticated requests were")
+		t.Fail()
+	}
+}
+
+func TestAllowedRequestsExact(t *testing.T) {
+	modes := map[string]func(jail *Jail){
+		"log then count":   func(jail *Jail) {},
+		"log allowed only": func(jail *Jail) { jail.LogAllowedOnly = true },
+		"fixed window": func(jail *Jail) {
+			log := NewFixedWindowLog(time.Hour)
+			log.Clock = jail.Clock
+			jail.visitors = log
+		},
+	}
+
+	for name, configure := range modes {
+		jail := NewJailForTesting(NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)), time.Hour, 0, 5)
+		configure(jail)
+
+		for i := 1; i <= 5; i++ {
+			if !serveJail(jail, makeRequest("1.2.3.4", false)) {
+				t.Logf("%s: request %d of 5 blocked", name, i)
+				t.Fail()
+			}
+		}
+		if serveJail(jail, makeRequest("1.2.3.4", false)) {
+			t.Logf("%s: request 6 allowed with AllowedRequests 5", name)
+			t.Fail()
+		}
+	}
+}
+
+func TestAllow(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	jail := NewJailForTesting(clock, time.Minute, time.Hour, 3)
+	decisions := 0
+	jail.OnDecision = func(Decision) { decisions++ }
+
+	for i := 1; i <= 3; i++ {
+		if allowed, retryAfter := jail.Allow(makeRequest("1.2.3.4", false)); !allowed || retryAfter != 0 {
+			t.Logf("request %d under the limit: allowed %v, retry after %s", i, allowed, retryAfter)
+			t.Fail()
+		}
+	}
+
+	// the request over the limit is blocked and sentenced for the cooloff
+	allowed, retryAfter := jail.Allow(makeRequest("1.2.3.4", false))
+	if allowed || retryAfter != time.Hour {
+		t.Logf("request over the limit: allowed %v, retry after %s", allowed, retryAfter)
+		t.Fail()
+	}
+	clock.Advance(2 * time.Minute)
+	if allowed, retryAfter := jail.Allow(makeRequest("1.2.3.4", false)); allowed || retryAfter != 58*time.Minute {
+		t.Logf("request during the sentence: allowed %v, retry after %s", allowed, retryAfter)
+		t.Fail()
+	}
+	if decisions != 5 {
+		t.Logf("Allow fired OnDecision %d times for 5 requests", decisions)
+		t.Fail()
+	}
+
+	// screened requests aren't decided
+	jail.DeniedIPs, _ = ParseNetworks("5.6.7.8")
+	if allowed, _ := jail.Allow(makeRequest("5.6.7.8", false)); allowed || decisions != 5 {
+		t.Logf("denylisted request: allowed %v after %d decisions", allowed, decisions)
+		t.Fail()
+	}
+}
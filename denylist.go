@@ -0,0 +1,35 @@
+package httpjail
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// isDenylisted reports whether the request's client IP is in DeniedIPs
+func (j *Jail) isDenylisted(req *http.Request) bool {
+	return inNetworks(j.requestIP(req), j.DeniedIPs)
+}
+
+// deny rejects a request from a denylisted client with the blocked response. The block doesn't lift, so no reset
+// or Retry-After is sent, and nothing is recorded against the visitor.
+func (j *Jail) deny(w http.ResponseWriter, req *http.Request) {
+	if j.OnBlocked != nil {
+		j.OnBlocked(w, req, 0)
+		return
+	}
+	jsonBody := !j.NoRespond && acceptsJSON(req)
+	if jsonBody {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.WriteHeader(http.StatusTooManyRequests)
+
+	switch {
+	case jsonBody:
+		json.NewEncoder(w).Encode(struct {
+			Error string `json:"error"`
+		}{blockMessage})
+	case !j.NoRespond:
+		fmt.Fprint(w, blockMessage)
+	}
+}
@@ -0,0 +1,55 @@
+package httpjail
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDeniedIPs(t *testing.T) {
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, time.Hour, 100)
+	jail.DeniedIPs, _ = ParseNetworks("203.0.113.7", "198.51.100.0/24")
+	// the denylist wins over the allowlist
+	jail.AllowedIPs, _ = ParseNetworks("198.51.100.0/24")
+	decisions := 0
+	jail.OnDecision = func(Decision) { decisions++ }
+
+	reached := false
+	handler := jail.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		reached = true
+	}))
+	serve := func(addr string) *httptest.ResponseRecorder {
+		reached = false
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, makeRequest(addr, false))
+		return rec
+	}
+
+	for _, addr := range []string{"203.0.113.7:5000", "198.51.100.42:5000"} {
+		rec := serve(addr)
+		if reached || rec.Code != http.StatusTooManyRequests || rec.Body.String() != blockMessage {
+			t.Logf("denylisted client %s: reached handler %v, status %d", addr, reached, rec.Code)
+			t.Fail()
+		}
+		if rec.Header().Get("Retry-After") != "" {
+			t.Logf("denylisted client %s told to retry", addr)
+			t.Fail()
+		}
+		if count := jail.visitors.CountVisits(stripPort(addr), time.Time{}); count != 0 {
+			t.Logf("denylisted client %s counted %d visits", addr, count)
+			t.Fail()
+		}
+	}
+	if decisions != 0 || len(jail.Sentences) != 0 {
+		t.Logf("denylisted requests made %d decisions and %d sentences", decisions, len(jail.Sentences))
+		t.Fail()
+	}
+
+	for _, addr := range []string{"203.0.113.8:5000", "198.51.101.1:5000"} {
+		if serve(addr); !reached {
+			t.Logf("client %s outside the denylist blocked", addr)
+			t.Fail()
+		}
+	}
+}
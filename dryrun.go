@@ -0,0 +1,35 @@
+package httpjail
+
+import "time"
+
+// wouldSentence records the sentence a dry run would have given the key, calling OnSentence if the key wasn't
+// already serving one
+func (j *Jail) wouldSentence(key string, cooloff time.Duration, now time.Time) {
+	if j.NoSentencing {
+		return
+	}
+
+	j.sentenceMux.Lock()
+	release, jailed := j.wouldSentences[key]
+	sentenced := !jailed || !release.After(now)
+	if sentenced {
+		if j.wouldSentences == nil {
+			j.wouldSentences = make(map[string]time.Time)
+		}
+		release = now.Add(cooloff)
+		j.wouldSentences[key] = release
+	}
+	j.sentenceMux.Unlock()
+
+	if sentenced && j.OnSentence != nil {
+		j.OnSentence(key, release)
+	}
+}
+
+// wouldBeSentenced reports whether the key would be serving a sentence if the dry run were enforced
+func (j *Jail) wouldBeSentenced(key string, now time.Time) bool {
+	j.sentenceMux.RLock()
+	release, jailed := j.wouldSentences[key]
+	j.sentenceMux.RUnlock()
+	return jailed && release.After(now)
+}
@@ -0,0 +1,79 @@
+package httpjail
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDryRun(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	jail := NewJailForTesting(clock, time.Minute, time.Hour, 2)
+	jail.DryRun = true
+	jail.WouldBlockHeader = true
+
+	var wouldBlock, firstBlocks []string
+	var sentenced []time.Time
+	jail.OnDecision = func(decision Decision) {
+		if decision.WouldBlock {
+			wouldBlock = append(wouldBlock, decision.Key)
+		}
+	}
+	jail.OnFirstBlock = func(key string) { firstBlocks = append(firstBlocks, key) }
+	jail.OnSentence = func(key string, release time.Time) { sentenced = append(sentenced, release) }
+
+	handler := jail.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(successRes))
+	}))
+	serve := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, makeRequest("1.2.3.4", false))
+		return rec
+	}
+
+	for i := 1; i <= 5; i++ {
+		rec := serve()
+		if rec.Code != http.StatusOK || rec.Body.String() != successRes {
+			t.Logf("request %d blocked in a dry run: %d", i, rec.Code)
+			t.Fail()
+		}
+		if flagged := rec.Header().Get(headerWouldBlock) == "true"; flagged != (i > 2) {
+			t.Logf("request %d flagged as a would-be block: %v", i, flagged)
+			t.Fail()
+		}
+	}
+
+	if len(wouldBlock) != 3 {
+		t.Logf("OnDecision saw %d would-be blocks, expected 3", len(wouldBlock))
+		t.Fail()
+	}
+	if len(firstBlocks) != 1 || len(sentenced) != 1 || !sentenced[0].Equal(clock.Now().Add(time.Hour)) {
+		t.Logf("OnFirstBlock fired %d times and OnSentence %d times (%v), expected once each",
+			len(firstBlocks), len(sentenced), sentenced)
+		t.Fail()
+	}
+	if len(jail.Sentences) != 0 {
+		t.Log("dry run recorded a real sentence")
+		t.Fail()
+	}
+
+	// the would-be sentence outlasts the window, so requests during it are still flagged
+	clock.Advance(30 * time.Minute)
+	if rec := serve(); rec.Code != http.StatusOK || rec.Header().Get(headerWouldBlock) != "true" {
+		t.Log("request during the would-be sentence not flagged")
+		t.Fail()
+	}
+	clock.Advance(time.Hour)
+	if rec := serve(); rec.Header().Get(headerWouldBlock) != "" {
+		t.Log("request after the would-be sentence flagged")
+		t.Fail()
+	}
+
+	// denylisted clients are let through too
+	jail.DeniedIPs, _ = ParseNetworks("1.2.3.4")
+	if rec := serve(); rec.Code != http.StatusOK {
+		t.Logf("denylisted client blocked in a dry run: %d", rec.Code)
+		t.Fail()
+	}
+}
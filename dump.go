@@ -0,0 +1,89 @@
+package httpjail
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// DumpTo writes every visit in the log to w as JSON, for reloading with LoadFrom after a restart. Wrap w in a
+// gzip.Writer (and close it) to compress the dump; LoadFrom detects compressed dumps.
+func (l *DefaultVisitorLog) DumpTo(w io.Writer) error {
+	// every shard is locked, in order, for a consistent snapshot, but only while it's copied so a slow writer
+	// doesn't hold up requests
+	visits := make(map[string][]time.Time)
+	for i := range l.shards {
+		l.shards[i].mux.Lock()
+	}
+	for i := range l.shards {
+		for key, keyVisits := range l.shards[i].visits {
+			visits[key] = append([]time.Time(nil), keyVisits...)
+		}
+	}
+	for i := range l.shards {
+		l.shards[i].mux.Unlock()
+	}
+	return json.NewEncoder(w).Encode(visits)
+}
+
+// LoadFrom reads visits written by DumpTo, plain or gzip-compressed, replacing the log's visits for every key in
+// the dump. Visits are kept as dumped, so ones that fell out of the window during the restart age out as usual.
+// Visitors are loaded least recently active first, so past MaxVisitors the ones evicted are the least recent.
+func (l *DefaultVisitorLog) LoadFrom(r io.Reader) error {
+	buffered := bufio.NewReader(r)
+	var source io.Reader = buffered
+	if magic, err := buffered.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		unzipped, err := gzip.NewReader(buffered)
+		if err != nil {
+			return err
+		}
+		defer unzipped.Close()
+		source = unzipped
+	}
+
+	var visits map[string][]time.Time
+	if err := json.NewDecoder(source).Decode(&visits); err != nil {
+		return err
+	}
+
+	// counting expects each visitor's visits in time order
+	keys := make([]string, 0, len(visits))
+	for key, keyVisits := range visits {
+		sort.Slice(keyVisits, func(i, k int) bool {
+			return keyVisits[i].Before(keyVisits[k])
+		})
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, k int) bool {
+		return lastVisit(visits[keys[i]]).Before(lastVisit(visits[keys[k]]))
+	})
+
+	for _, key := range keys {
+		shard := l.shard(key)
+		l.makeRoom(shard, key)
+
+		shard.mux.Lock()
+		if shard.visits == nil {
+			shard.visits = make(map[string][]time.Time)
+		}
+		if _, tracked := shard.visits[key]; !tracked {
+			atomic.AddInt64(&l.tracked, 1)
+		}
+		shard.visits[key] = visits[key]
+		shard.touch(key)
+		shard.mux.Unlock()
+	}
+	return nil
+}
+
+// lastVisit returns the latest of visits in time order, the zero time if there are none
+func lastVisit(visits []time.Time) time.Time {
+	if len(visits) == 0 {
+		return time.Time{}
+	}
+	return visits[len(visits)-1]
+}
@@ -0,0 +1,122 @@
+package httpjail
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestDumpAndLoad(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		clock := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+		source := NewDefaultVisitorLog()
+		source.Clock = clock
+
+		var keys []string
+		for i := 0; i < 10; i++ {
+			key := fmt.Sprintf("10.0.0.%d", i)
+			keys = append(keys, key)
+			for n := 0; n <= i; n++ {
+				source.LogVisit(key)
+				clock.Advance(time.Second)
+			}
+		}
+
+		var dump bytes.Buffer
+		if compress {
+			zipped := gzip.NewWriter(&dump)
+			if err := source.DumpTo(zipped); err != nil {
+				t.Log(err)
+				t.FailNow()
+			}
+			zipped.Close()
+		} else if err := source.DumpTo(&dump); err != nil {
+			t.Log(err)
+			t.FailNow()
+		}
+
+		restored := NewDefaultVisitorLog()
+		restored.Clock = clock
+		if err := restored.LoadFrom(&dump); err != nil {
+			t.Logf("compressed %v: %s", compress, err)
+			t.FailNow()
+		}
+
+		for _, since := range []time.Time{time.Time{}, clock.Now().Add(-20 * time.Second)} {
+			for _, key := range keys {
+				if got, want := restored.CountVisits(key, since), source.CountVisits(key, since); got != want {
+					t.Logf("compressed %v: %s restored with %d visits since %s, expected %d", compress, key, got, since, want)
+					t.Fail()
+				}
+			}
+		}
+	}
+
+	if err := NewDefaultVisitorLog().LoadFrom(bytes.NewBufferString("not json")); err == nil {
+		t.Log("invalid dump loaded")
+		t.Fail()
+	}
+}
+
+func TestLoadFromOrdersAndCaps(t *testing.T) {
+	clock := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	restored := NewDefaultVisitorLog()
+	restored.Clock = clock
+	restored.MaxVisitors = 3
+
+	// a hand-edited or merged dump, visits out of order
+	dump := `{
+		"10.0.0.1": ["2020-01-01T00:00:01Z"],
+		"10.0.0.2": ["2020-01-01T00:00:09Z", "2020-01-01T00:00:02Z"],
+		"10.0.0.3": ["2020-01-01T00:00:03Z"],
+		"10.0.0.4": ["2020-01-01T00:00:04Z"],
+		"10.0.0.5": ["2020-01-01T00:00:05Z", "2019-12-31T23:00:00Z"]
+	}`
+	if err := restored.LoadFrom(bytes.NewBufferString(dump)); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if restored.visitors() != 3 {
+		t.Logf("%d visitors loaded, expected MaxVisitors of 3", restored.visitors())
+		t.Fail()
+	}
+	for key, want := range map[string]int{"10.0.0.1": 0, "10.0.0.3": 0, "10.0.0.2": 2, "10.0.0.4": 1, "10.0.0.5": 1} {
+		if got := restored.CountVisits(key, clock.Now()); got != want {
+			t.Logf("%s restored with %d visits since midnight, expected %d", key, got, want)
+			t.Fail()
+		}
+	}
+}
+
+// loggingWriter logs a visit on every write, as a request would while a dump is written
+type loggingWriter struct {
+	log *DefaultVisitorLog
+}
+
+func (w loggingWriter) Write(b []byte) (int, error) {
+	w.log.LogVisit("10.0.0.1")
+	return len(b), nil
+}
+
+func TestDumpToReleasesLocks(t *testing.T) {
+	source := NewDefaultVisitorLog()
+	source.LogVisit("10.0.0.1")
+
+	done := make(chan error)
+	go func() {
+		done <- source.DumpTo(loggingWriter{source})
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+		}
+	case <-time.After(5 * time.Second):
+		t.Log("visits couldn't be logged while the dump was written")
+		t.Fail()
+	}
+}
@@ -0,0 +1,62 @@
+package httpjail
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"time"
+)
+
+// errorBucket namespaces a visitor's error responses in the visitor log
+func errorBucket(key string) string {
+	return "errors|" + key
+}
+
+// overErrorBudget reports whether the visitor has used up its ErrorBudget since the provided time
+func (j *Jail) overErrorBudget(key string, since time.Time) (bool, error) {
+	count, err := j.countVisits(errorBucket(key), since)
+	return count >= j.ErrorBudget, err
+}
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+// WriteHeader records the status before writing it
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Write records an implicit 200 status before writing the body
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Flush flushes the underlying writer if it supports flushing
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Hijack hands the connection to the handler if the underlying writer allows it
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	return hijacker.Hijack()
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
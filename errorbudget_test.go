@@ -0,0 +1,87 @@
+package httpjail
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestErrorBudget(t *testing.T) {
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, 0, 100)
+	jail.ErrorBudget = 3
+
+	handler := jail.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/" {
+			http.NotFound(w, req)
+			return
+		}
+		w.Write([]byte(successRes))
+	}))
+
+	request := func(ip, path string) int {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = ip
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// a scanner probing for paths uses up its error budget
+	for i := 0; i < 3; i++ {
+		if status := request("1.1.1.1", "/wp-admin"); status != http.StatusNotFound {
+			t.Logf("probe %d: got status %d, expected the handler's 404", i, status)
+			t.Fail()
+		}
+	}
+	if status := request("1.1.1.1", "/"); status != http.StatusTooManyRequests {
+		t.Logf("scanner over its error budget got status %d, expected to be blocked", status)
+		t.Fail()
+	}
+
+	// a well-behaved client making many successful requests is unaffected
+	for i := 0; i < 10; i++ {
+		if status := request("2.2.2.2", "/"); status != http.StatusOK {
+			t.Logf("successful request %d got status %d", i, status)
+			t.Fail()
+		}
+	}
+}
+
+func TestErrorBudgetHijack(t *testing.T) {
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, 0, 100)
+	jail.ErrorBudget = 3
+
+	server := httptest.NewServer(jail.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if _, ok := w.(http.Flusher); !ok {
+			t.Log("handler under an error budget can't flush")
+			t.Fail()
+		}
+		if unwrapper, ok := w.(interface{ Unwrap() http.ResponseWriter }); !ok || unwrapper.Unwrap() == nil {
+			t.Log("handler under an error budget can't unwrap its writer")
+			t.Fail()
+		}
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Logf("handler under an error budget can't hijack: %s", err)
+			t.Fail()
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 8\r\nConnection: close\r\n\r\nhijacked")
+		buf.Flush()
+	})))
+	defer server.Close()
+
+	res, err := http.Get(server.URL)
+	if err != nil {
+		t.Logf("request to hijacking handler failed: %s", err)
+		t.FailNow()
+	}
+	defer res.Body.Close()
+	if body, _ := io.ReadAll(res.Body); string(body) != "hijacked" {
+		t.Logf("got %q from hijacking handler", body)
+		t.Fail()
+	}
+}
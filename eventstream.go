@@ -0,0 +1,62 @@
+package httpjail
+
+import (
+	"net/http"
+	"strings"
+)
+
+// isEventStream reports whether the request is opening a Server-Sent Events stream
+func isEventStream(req *http.Request) bool {
+	return strings.Contains(req.Header.Get("Accept"), "text/event-stream")
+}
+
+// serveEventStream holds one of the visitor's event stream slots for the life of the stream. Streams are long-lived,
+// so they're limited by concurrency rather than counted as requests, though sentenced visitors can't open them.
+func (j *Jail) serveEventStream(w http.ResponseWriter, req *http.Request, next http.Handler) {
+	decision, rule := j.identify(req)
+	if decision.Key == "" {
+		next.ServeHTTP(w, req)
+		return
+	}
+	// there's no telling when a stream slot frees up, so rejected visitors are asked to wait a window
+	decision.Limit = Limit{AllowedRequests: j.MaxEventStreams, Window: rule.Window}
+	decision.RetryAfter = rule.Window
+
+	sentenced := j.isSentenced(decision.Key, decision.Time)
+	if sentenced {
+		decision.RetryAfter = j.sentenceLeft(decision.Key, decision.Time)
+	}
+
+	j.mux.Lock()
+	if j.streams == nil {
+		j.streams = make(map[string]int)
+	}
+	decision.Count = j.streams[decision.Key] + 1
+	decision.Allowed = !sentenced && decision.Count <= j.MaxEventStreams
+	if !decision.Allowed && j.DryRun {
+		decision.Allowed, decision.WouldBlock = true, true
+	}
+	if decision.Allowed {
+		j.streams[decision.Key]++
+	}
+	j.mux.Unlock()
+
+	decision.setRemaining()
+	if j.OnDecision != nil {
+		j.OnDecision(decision)
+	}
+
+	if !decision.Allowed {
+		j.block(w, req, decision)
+		return
+	}
+
+	defer func() {
+		j.mux.Lock()
+		if j.streams[decision.Key]--; j.streams[decision.Key] <= 0 {
+			delete(j.streams, decision.Key)
+		}
+		j.mux.Unlock()
+	}()
+	next.ServeHTTP(w, req)
+}
@@ -0,0 +1,118 @@
+package httpjail
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEventStreamLimit(t *testing.T) {
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, 0, 1)
+	jail.MaxEventStreams = 2
+
+	release := make(chan struct{})
+	opened := make(chan struct{})
+	handler := jail.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if isEventStream(req) {
+			opened <- struct{}{}
+			<-release
+		}
+		w.Write([]byte(successRes))
+	}))
+
+	stream := func() *http.Request {
+		req := makeRequest("1.2.3.4", false)
+		req.Header.Set("Accept", "text/event-stream")
+		return req
+	}
+
+	// hold two streams open
+	done := make(chan struct{})
+	for i := 0; i < 2; i++ {
+		go func() {
+			handler.ServeHTTP(httptest.NewRecorder(), stream())
+			done <- struct{}{}
+		}()
+		<-opened
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, stream())
+	if rec.Body.String() == successRes {
+		t.Log("third concurrent stream allowed")
+		t.Fail()
+	}
+
+	// streams don't consume the regular request budget
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, makeRequest("1.2.3.4", false))
+	if rec.Body.String() != successRes {
+		t.Log("regular request denied while streams were open")
+		t.Fail()
+	}
+
+	close(release)
+	<-done
+	<-done
+
+	// closed streams free their slots
+	release = make(chan struct{})
+	go func() {
+		handler.ServeHTTP(httptest.NewRecorder(), stream())
+		done <- struct{}{}
+	}()
+	<-opened
+	close(release)
+	<-done
+}
+
+func TestEventStreamBlocks(t *testing.T) {
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, time.Hour, 1)
+	jail.MaxEventStreams = 1
+
+	release := make(chan struct{})
+	opened := make(chan struct{})
+	handler := jail.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if isEventStream(req) {
+			opened <- struct{}{}
+			<-release
+		}
+		w.Write([]byte(successRes))
+	}))
+
+	stream := func(ip string) *httptest.ResponseRecorder {
+		req := makeRequest(ip, false)
+		req.Header.Set("Accept", "text/event-stream")
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// a visitor over its stream limit is asked to wait a window
+	done := make(chan struct{})
+	go func() {
+		stream("1.2.3.4")
+		done <- struct{}{}
+	}()
+	<-opened
+	rec := stream("1.2.3.4")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "60" {
+		t.Logf("stream over the limit got %d with Retry-After %q, expected 429 with 60", rec.Code,
+			rec.Header().Get("Retry-After"))
+		t.Fail()
+	}
+	close(release)
+	<-done
+
+	// a sentenced visitor can't open a stream until its sentence is served
+	for i := 0; i < 2; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), makeRequest("5.6.7.8", false))
+	}
+	rec = stream("5.6.7.8")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "3600" {
+		t.Logf("sentenced visitor's stream got %d with Retry-After %q, expected 429 with 3600", rec.Code,
+			rec.Header().Get("Retry-After"))
+		t.Fail()
+	}
+}
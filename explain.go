@@ -0,0 +1,117 @@
+package httpjail
+
+import (
+	"strings"
+	"time"
+)
+
+// Explanation describes why a visitor key is or isn't currently blocked
+type Explanation struct {
+	// visitor key, as reported in Decision.Key
+	Key string `json:"key"`
+	// rule the key is counted under: "default", a route ("route:METHOD PATH"), "host:HOST", "class:CLASS",
+	// "first-party", one of ExtraLimits ("limit:N/WINDOW"), one of KeyLimits ("key:NAME") or "errors" for an
+	// ErrorBudget bucket
+	Rule  string `json:"rule"`
+	Limit Limit  `json:"limit"`
+	// visits counted in the current window
+	Count int `json:"count"`
+	// requests left in the current window
+	Remaining int `json:"remaining"`
+	// would the key's next request be blocked?
+	Blocked bool `json:"blocked"`
+	// is the key serving a sentence, and when is it released?
+	Sentenced bool      `json:"sentenced"`
+	Release   time.Time `json:"release,omitempty"`
+	// sentences the key has received, counted when EscalateCooloff is set
+	Offenses int `json:"offenses,omitempty"`
+}
+
+// Explain describes the key's current state: its count against the rule it's counted under, and any sentence it's
+// serving. It reads the visitor log without logging a visit or dropping old ones, which may still count under a
+// longer window.
+func (j *Jail) Explain(key string) Explanation {
+	now := j.now()
+	scope, limit := j.ruleForKey(key)
+
+	explanation := Explanation{
+		Key:   key,
+		Rule:  scope,
+		Limit: limit,
+		Count: j.CountVisitsBatch([]string{key}, now.Add(-limit.Window))[key],
+	}
+	if remaining := limit.AllowedRequests - explanation.Count; remaining > 0 {
+		explanation.Remaining = remaining
+	}
+
+	j.sentenceMux.RLock()
+	release, jailed := j.Sentences[key]
+	explanation.Offenses = j.offenses[key]
+	j.sentenceMux.RUnlock()
+
+	if jailed && release.After(now) {
+		explanation.Sentenced = true
+		explanation.Release = release
+	}
+	explanation.Blocked = explanation.Sentenced || explanation.Remaining == 0
+	return explanation
+}
+
+// ruleForKey recovers the rule a bucket key is counted under from its scope prefix, as added by rule.bucket,
+// KeyLimit.bucket and errorBucket
+func (j *Jail) ruleForKey(key string) (string, Limit) {
+	// error buckets wrap the visitor's own key, and are counted over its window
+	if strings.HasPrefix(key, errorBucket("")) {
+		_, limit := j.ruleForKey(strings.TrimPrefix(key, errorBucket("")))
+		return "errors", Limit{AllowedRequests: j.ErrorBudget, Window: limit.Window}
+	}
+
+	j.limitsMux.RLock()
+	defer j.limitsMux.RUnlock()
+
+	if sep := strings.IndexByte(key, '|'); sep >= 0 {
+		scope := key[:sep]
+		switch {
+		case strings.HasPrefix(scope, "class:"):
+			if limit, ok := j.ClassLimits[strings.TrimPrefix(scope, "class:")]; ok {
+				return scope, limit
+			}
+		case strings.HasPrefix(scope, "route:"):
+			for _, route := range j.Routes {
+				if scope == "route:"+route.Method+" "+route.pattern() {
+					return scope, route.Limit
+				}
+			}
+		case strings.HasPrefix(scope, "host:"):
+			if limit, ok := j.HostLimits[strings.TrimPrefix(scope, "host:")]; ok {
+				return scope, limit
+			}
+		case strings.HasPrefix(scope, "limit:"):
+			for _, limit := range j.ExtraLimits {
+				if extraRule(limit).scope == scope {
+					return scope, limit
+				}
+			}
+		case strings.HasPrefix(scope, "key:"):
+			for _, keyLimit := range j.KeyLimits {
+				if keyLimit.bucket("") == scope+"|" {
+					return scope, keyLimit.Limit
+				}
+			}
+		case scope == "first-party" && j.FirstPartyLimit != nil:
+			return scope, *j.FirstPartyLimit
+		}
+	}
+
+	if provider, ok := j.visitors.(LimitProvider); ok {
+		if allowed, window, ok := provider.Limit(key); ok {
+			return "default", Limit{AllowedRequests: allowed, Window: window, Cooloff: j.Cooloff}
+		}
+	}
+	return "default", Limit{
+		AllowedRequests: j.AllowedRequests,
+		SoftLimit:       j.SoftLimit,
+		Window:          j.Window,
+		Cooloff:         j.Cooloff,
+	}
+}
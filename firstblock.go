@@ -0,0 +1,46 @@
+package httpjail
+
+import "time"
+
+// trackBlocked records whether the visitor's latest request was blocked, reporting whether it just crossed from
+// allowed to blocked
+func (j *Jail) trackBlocked(key string, blocked bool, now time.Time) bool {
+	j.mux.Lock()
+	defer j.mux.Unlock()
+
+	if !blocked {
+		delete(j.blocked, key)
+		return false
+	}
+
+	if j.blocked == nil {
+		j.blocked = make(map[string]time.Time)
+	}
+	_, already := j.blocked[key]
+	j.blocked[key] = now
+	return !already
+}
+
+// pruneBlocked forgets visitors last blocked before the provided time that aren't serving a sentence, so visitors
+// that never return don't accumulate
+func (j *Jail) pruneBlocked(now, before time.Time) {
+	var stale []string
+	j.mux.Lock()
+	for key, lastBlock := range j.blocked {
+		if lastBlock.Before(before) {
+			stale = append(stale, key)
+		}
+	}
+	j.mux.Unlock()
+
+	for _, key := range stale {
+		if j.isSentenced(key, now) {
+			continue
+		}
+		j.mux.Lock()
+		if lastBlock, ok := j.blocked[key]; ok && lastBlock.Before(before) {
+			delete(j.blocked, key)
+		}
+		j.mux.Unlock()
+	}
+}
@@ -0,0 +1,52 @@
+package httpjail
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOnFirstBlock(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	jail := NewJailForTesting(clock, time.Minute, 0, 2)
+
+	var fired []string
+	jail.OnFirstBlock = func(key string) {
+		fired = append(fired, key)
+	}
+
+	for i := 0; i < 10; i++ {
+		serveJail(jail, makeRequest("1.2.3.4", false))
+	}
+	if len(fired) != 1 || fired[0] != "1.2.3.4" {
+		t.Logf("hook fired %v for 8 blocked requests, expected once", fired)
+		t.Fail()
+	}
+
+	// another visitor crossing over is its own transition
+	for i := 0; i < 5; i++ {
+		serveJail(jail, makeRequest("5.6.7.8", false))
+	}
+	if len(fired) != 2 {
+		t.Logf("hook fired %v, expected once per visitor", fired)
+		t.Fail()
+	}
+
+	// once allowed again, the next block is a new transition
+	clock.Advance(2 * time.Minute)
+	serveJail(jail, makeRequest("1.2.3.4", false))
+	for i := 0; i < 5; i++ {
+		serveJail(jail, makeRequest("1.2.3.4", false))
+	}
+	if len(fired) != 3 {
+		t.Logf("hook fired %v, expected a second transition for 1.2.3.4", fired)
+		t.Fail()
+	}
+
+	// cleanup forgets blocked visitors that went away
+	clock.Advance(2 * time.Minute)
+	jail.Cleanup()
+	if len(jail.blocked) != 0 {
+		t.Logf("%d blocked visitors tracked after cleanup", len(jail.blocked))
+		t.Fail()
+	}
+}
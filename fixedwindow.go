@@ -0,0 +1,99 @@
+package httpjail
+
+import (
+	"sync"
+	"time"
+)
+
+// FixedWindowLog counts visits in fixed windows aligned to multiples of its window length. It keeps a single
+// counter per visitor instead of every timestamp, but a client can spend its full allowance at the end of one
+// window and again at the start of the next, briefly reaching twice the configured rate. DefaultVisitorLog is an
+// exact sliding window and doesn't allow that burst.
+type FixedWindowLog struct {
+	mux      sync.Mutex
+	window   time.Duration
+	counters map[string]fixedWindow
+	// source of the current time, defaults to the system clock
+	Clock Clock
+}
+
+// fixedWindow is a visitor's count within the window starting at start
+type fixedWindow struct {
+	start time.Time
+	count int
+}
+
+// NewFixedWindowLog instantiates a FixedWindowLog with the provided window length
+func NewFixedWindowLog(window time.Duration) *FixedWindowLog {
+	return &FixedWindowLog{
+		window:   window,
+		counters: make(map[string]fixedWindow),
+		Clock:    realClock{},
+	}
+}
+
+// windowStart returns the start of the fixed window containing t. A t exactly on a boundary starts a new window.
+func (l *FixedWindowLog) windowStart(t time.Time) time.Time {
+	return t.Truncate(l.window)
+}
+
+// ResetAt returns the boundary at which the window containing now resets, the first instant of the next window.
+// The key is ignored, every visitor's windows share the same boundaries.
+func (l *FixedWindowLog) ResetAt(key string, now time.Time) time.Time {
+	return l.windowStart(now).Add(l.window)
+}
+
+// LogVisit logs a visitor request in the current window
+func (l *FixedWindowLog) LogVisit(key string) {
+	l.IncrementAndCount(key, time.Time{})
+}
+
+// IncrementAndCount logs a visitor request in the current window and returns the window's count. since is ignored,
+// as in CountVisits.
+func (l *FixedWindowLog) IncrementAndCount(key string, since time.Time) int {
+	start := l.windowStart(nowFrom(l.Clock))
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	counter := l.counters[key]
+	if !counter.start.Equal(start) {
+		counter = fixedWindow{start: start}
+	}
+	counter.count++
+	l.counters[key] = counter
+	return counter.count
+}
+
+// CountVisits counts the visitor's visits in the current fixed window. since is ignored, the window length is
+// fixed when the log is created.
+func (l *FixedWindowLog) CountVisits(key string, since time.Time) int {
+	start := l.windowStart(nowFrom(l.Clock))
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	counter, ok := l.counters[key]
+	if !ok || !counter.start.Equal(start) {
+		return 0
+	}
+	return counter.count
+}
+
+// UnlogVisit takes a visit back out of the visitor's current window
+func (l *FixedWindowLog) UnlogVisit(key string) {
+	start := l.windowStart(nowFrom(l.Clock))
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	counter, ok := l.counters[key]
+	if ok && counter.start.Equal(start) && counter.count > 0 {
+		counter.count--
+		l.counters[key] = counter
+	}
+}
+
+// Reset drops a visitor's count
+func (l *FixedWindowLog) Reset(key string) {
+	l.mux.Lock()
+	delete(l.counters, key)
+	l.mux.Unlock()
+}
@@ -0,0 +1,196 @@
+package httpjail
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// countBoundaryBurst sends a burst just before and just after a window boundary, returning how many got through
+func countBoundaryBurst(jail *Jail, clock *FakeClock, burst int) int {
+	allowed := 0
+	for i := 0; i < burst; i++ {
+		if serveJail(jail, makeRequest("1.2.3.4", false)) {
+			allowed++
+		}
+	}
+
+	clock.Advance(time.Second)
+	for i := 0; i < burst; i++ {
+		if serveJail(jail, makeRequest("1.2.3.4", false)) {
+			allowed++
+		}
+	}
+	return allowed
+}
+
+func TestFixedWindowBoundaryDoubling(t *testing.T) {
+	window := time.Minute
+	allowedRequests := 5
+	// one second before a window boundary
+	start := time.Date(2020, 1, 1, 0, 0, 59, 0, time.UTC)
+
+	fixedClock := NewFakeClock(start)
+	fixedLog := NewFixedWindowLog(window)
+	fixedLog.Clock = fixedClock
+	fixedJail := NewJail(fixedLog, window, 0, allowedRequests)
+	fixedJail.Clock = fixedClock
+
+	allowed := countBoundaryBurst(fixedJail, fixedClock, allowedRequests)
+	if allowed != 2*allowedRequests {
+		t.Logf("fixed window allowed %d requests across the boundary, expected %d", allowed, 2*allowedRequests)
+		t.Fail()
+	}
+
+	slidingClock := NewFakeClock(start)
+	slidingJail := NewJailForTesting(slidingClock, window, 0, allowedRequests)
+
+	allowed = countBoundaryBurst(slidingJail, slidingClock, allowedRequests)
+	if allowed != allowedRequests {
+		t.Logf("sliding window allowed %d requests across the boundary, expected %d", allowed, allowedRequests)
+		t.Fail()
+	}
+}
+
+func TestFixedWindowLogResets(t *testing.T) {
+	clock := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	visitorLog := NewFixedWindowLog(time.Minute)
+	visitorLog.Clock = clock
+
+	for i := 0; i < 3; i++ {
+		visitorLog.LogVisit("key")
+	}
+	if count := visitorLog.CountVisits("key", time.Time{}); count != 3 {
+		t.Logf("incorrect visit count: got %d, expected %d", count, 3)
+		t.Fail()
+	}
+
+	clock.Advance(time.Minute)
+	if count := visitorLog.CountVisits("key", time.Time{}); count != 0 {
+		t.Logf("count carried into the next window: got %d", count)
+		t.Fail()
+	}
+}
+
+func TestFixedWindowBoundaryAttribution(t *testing.T) {
+	window := time.Minute
+	boundary := time.Date(2020, 1, 1, 0, 1, 0, 0, time.UTC)
+	clock := NewFakeClock(boundary.Add(-time.Nanosecond))
+	visitorLog := NewFixedWindowLog(window)
+	visitorLog.Clock = clock
+	jail := NewJail(visitorLog, window, 0, 1)
+	jail.Clock = clock
+
+	var decision Decision
+	jail.OnDecision = func(d Decision) {
+		decision = d
+	}
+
+	// the last instant of the old window
+	serveJail(jail, makeRequest("1.2.3.4", false))
+	if !decision.Reset.Equal(boundary) {
+		t.Logf("reset at %v, expected the boundary %v", decision.Reset, boundary)
+		t.Fail()
+	}
+
+	// a request exactly on the boundary belongs to the new window, every time
+	for i := 0; i < 10; i++ {
+		clock.Set(boundary)
+		if !serveJail(jail, makeRequest("1.2.3.4", false)) || decision.Count != 1 {
+			t.Logf("boundary request counted %d, expected it to start the new window", decision.Count)
+			t.FailNow()
+		}
+		if !decision.Reset.Equal(boundary.Add(window)) {
+			t.Logf("boundary request resets at %v, expected %v", decision.Reset, boundary.Add(window))
+			t.Fail()
+		}
+		visitorLog.Reset("1.2.3.4")
+	}
+
+	// the batch count agrees with the single-key count at the boundary
+	clock.Set(boundary.Add(-time.Nanosecond))
+	visitorLog.LogVisit("1.2.3.4")
+	clock.Set(boundary)
+	visitorLog.LogVisit("1.2.3.4")
+	if counts := visitorLog.CountVisitsBatch([]string{"1.2.3.4"}, time.Time{}); counts["1.2.3.4"] != 1 {
+		t.Logf("batch counted %d at the boundary, expected 1", counts["1.2.3.4"])
+		t.Fail()
+	}
+	if count := visitorLog.CountVisits("1.2.3.4", time.Time{}); count != 1 {
+		t.Logf("counted %d at the boundary, expected 1", count)
+		t.Fail()
+	}
+}
+
+func TestFixedWindowResetHeader(t *testing.T) {
+	clock := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 20, 0, time.UTC))
+	visitorLog := NewFixedWindowLog(time.Minute)
+	visitorLog.Clock = clock
+	jail := NewJail(visitorLog, time.Minute, 0, 5)
+	jail.Clock = clock
+
+	handler := jail.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	next := time.Date(2020, 1, 1, 0, 1, 0, 0, time.UTC)
+
+	for _, elapsed := range []time.Duration{0, 30 * time.Second, 9 * time.Second} {
+		clock.Advance(elapsed)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, makeRequest("1.2.3.4", false))
+
+		expected := strconv.FormatInt(next.Unix(), 10)
+		if reset := w.Header().Get(headerReset); reset != expected {
+			t.Logf("at %v reset header is %s, expected the next boundary %s", clock.Now(), reset, expected)
+			t.Fail()
+		}
+	}
+
+	// a sliding window still resets one window from now
+	slidingClock := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 20, 0, time.UTC))
+	sliding := NewJailForTesting(slidingClock, time.Minute, 0, 5)
+	var decision Decision
+	sliding.OnDecision = func(d Decision) {
+		decision = d
+	}
+	serveJail(sliding, makeRequest("1.2.3.4", false))
+	if !decision.Reset.Equal(slidingClock.Now().Add(time.Minute)) {
+		t.Logf("sliding window resets at %v, expected a window from now", decision.Reset)
+		t.Fail()
+	}
+}
+
+func TestFixedWindowRetryAfter(t *testing.T) {
+	clock := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	visitorLog := NewFixedWindowLog(time.Minute)
+	visitorLog.Clock = clock
+	jail := NewJail(visitorLog, time.Minute, 0, 1)
+	jail.Clock = clock
+	handler := jail.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), makeRequest("1.2.3.4", false))
+	clock.Advance(50 * time.Second)
+	req := makeRequest("1.2.3.4", false)
+	req.Header.Set("Accept", "application/json")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+
+	// blocked at 00:00:50, the window reopens at 00:01:00 rather than a whole window later
+	next := time.Date(2020, 1, 1, 0, 1, 0, 0, time.UTC)
+	if w.Header().Get("Retry-After") != "10" || w.Header().Get(headerReset) != strconv.FormatInt(next.Unix(), 10) {
+		t.Logf("blocked at %v with Retry-After %s and reset %s, expected both at the next boundary %v",
+			clock.Now(), w.Header().Get("Retry-After"), w.Header().Get(headerReset), next)
+		t.Fail()
+	}
+
+	var body blockBody
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if body.RetryAfter != 10 || !body.Reset.Equal(next) {
+		t.Logf("blocked body retries after %d at %v, expected 10 at %v", body.RetryAfter, body.Reset, next)
+		t.Fail()
+	}
+}
@@ -0,0 +1,122 @@
+package httpjail
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+const (
+	// defaultMaxForwardedHops is the number of X-Forwarded-For hops parsed when MaxForwardedHops isn't set
+	defaultMaxForwardedHops = 50
+	// defaultMaxForwardedLength is the number of X-Forwarded-For bytes considered when MaxForwardedLength isn't set
+	defaultMaxForwardedLength = 4096
+)
+
+// proxyHeader returns the header carrying the client IP in proxy mode
+func (j *Jail) proxyHeader() string {
+	if j.ProxyHeader == "" {
+		return "X-Forwarded-For"
+	}
+	return j.ProxyHeader
+}
+
+// forwardedChain splits the request's X-Forwarded-For headers into hops, client first. Clients control the
+// header, so parsing stops after MaxForwardedHops hops or MaxForwardedLength bytes to keep oversized headers cheap.
+func (j *Jail) forwardedChain(req *http.Request) []string {
+	maxHops := j.MaxForwardedHops
+	if maxHops <= 0 {
+		maxHops = defaultMaxForwardedHops
+	}
+	remaining := j.MaxForwardedLength
+	if remaining <= 0 {
+		remaining = defaultMaxForwardedLength
+	}
+
+	var chain []string
+	for _, header := range req.Header.Values(j.proxyHeader()) {
+		if len(header) > remaining {
+			// a hop cut off by the length cap is incomplete, drop it
+			header = header[:remaining]
+			if cut := strings.LastIndexByte(header, ','); cut >= 0 {
+				header = header[:cut]
+			} else {
+				header = ""
+			}
+			remaining = 0
+		} else {
+			remaining -= len(header)
+		}
+
+		for len(header) > 0 && len(chain) < maxHops {
+			var hop string
+			if comma := strings.IndexByte(header, ','); comma >= 0 {
+				hop, header = header[:comma], header[comma+1:]
+			} else {
+				hop, header = header, ""
+			}
+			if hop = strings.TrimSpace(hop); hop != "" {
+				chain = append(chain, hop)
+			}
+		}
+
+		if remaining == 0 || len(chain) >= maxHops {
+			break
+		}
+	}
+	return chain
+}
+
+// forwardedClient picks the client IP from an X-Forwarded-For chain: the left-most hop, which the first proxy
+// recorded. Hops that don't parse as an IP (optionally with a port) are skipped, so a client prefixing garbage is
+// keyed by the address the proxy appended after it. ok is false if no hop is an IP.
+func forwardedClient(chain []string) (string, bool) {
+	for _, hop := range chain {
+		if host, _, err := net.SplitHostPort(hop); err == nil {
+			hop = host
+		}
+		if ip := net.ParseIP(hop); ip != nil {
+			return ip.String(), true
+		}
+	}
+	return "", false
+}
+
+// trustedClient picks the client IP TrustedProxyCount hops from the right of the X-Forwarded-For chain: the address
+// the outermost trusted proxy saw. Hops left of it may be injected by the client, so only the right end is parsed.
+// ok is false if the chain is too short or that hop isn't an IP.
+func (j *Jail) trustedClient(req *http.Request) (string, bool) {
+	headers := req.Header.Values(j.proxyHeader())
+	seen := 0
+	for i := len(headers) - 1; i >= 0; i-- {
+		header := headers[i]
+		for len(header) > 0 {
+			var hop string
+			if comma := strings.LastIndexByte(header, ','); comma >= 0 {
+				hop, header = header[comma+1:], header[:comma]
+			} else {
+				hop, header = header, ""
+			}
+			if hop = strings.TrimSpace(hop); hop == "" {
+				continue
+			}
+			if seen++; seen == j.TrustedProxyCount {
+				return forwardedClient([]string{hop})
+			}
+		}
+	}
+	return "", false
+}
+
+// clientAddr resolves the address of the client behind the proxy from the request's forwarded chain, falling back
+// to RemoteAddr
+func (j *Jail) clientAddr(req *http.Request, chain []string) string {
+	if j.TrustedProxyCount > 0 {
+		if client, ok := j.trustedClient(req); ok {
+			return client
+		}
+	} else if client, ok := forwardedClient(chain); ok {
+		return client
+	}
+	return req.RemoteAddr
+}
@@ -0,0 +1,243 @@
+package httpjail
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestForwardedChainBounded(t *testing.T) {
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, 0, 1)
+	jail.IsProxied()
+
+	// a client-supplied header with hundreds of thousands of hops
+	abusive := "203.0.113.9" + strings.Repeat(", 10.0.0.1", 200000)
+	req := makeRequest("", false)
+	req.Header.Set("X-Forwarded-For", abusive)
+
+	chain := jail.forwardedChain(req)
+	if len(chain) > defaultMaxForwardedHops {
+		t.Logf("parsed %d hops, expected at most %d", len(chain), defaultMaxForwardedHops)
+		t.Fail()
+	}
+	if len(chain) == 0 || chain[0] != "203.0.113.9" {
+		t.Logf("incorrect client hop: %v", chain[:1])
+		t.Fail()
+	}
+
+	var decision Decision
+	jail.OnDecision = func(d Decision) {
+		decision = d
+	}
+	serveJail(jail, req)
+	if decision.Key != "203.0.113.9" {
+		t.Logf("abusive header produced key of length %d, expected the client IP", len(decision.Key))
+		t.Fail()
+	}
+
+	// the length cap applies before the hop cap, dropping a hop it cuts through
+	jail.MaxForwardedLength = 20
+	req.Header.Set("X-Forwarded-For", "203.0.113.9, 198.51.100.20, 10.0.0.1")
+	chain = jail.forwardedChain(req)
+	if len(chain) != 1 || chain[0] != "203.0.113.9" {
+		t.Logf("incorrect length-capped chain: %v", chain)
+		t.Fail()
+	}
+
+	jail.MaxForwardedHops = 2
+	jail.MaxForwardedLength = 0
+	chain = jail.forwardedChain(req)
+	if len(chain) != 2 {
+		t.Logf("incorrect hop-capped chain: %v", chain)
+		t.Fail()
+	}
+}
+
+// FuzzExtractKey feeds arbitrary RemoteAddr and X-Forwarded-For values (newlines separate repeated headers) through
+// key extraction in proxy mode
+func FuzzExtractKey(f *testing.F) {
+	f.Add("1.2.3.4", "")
+	f.Add("", "203.0.113.9")
+	f.Add("1.2.3.4:5678", "203.0.113.9, 10.0.0.1")
+	f.Add("[2001:db8::1]:443", "2001:db8::2, [2001:db8::3]:80")
+	f.Add("", " , ,,\n,")
+	f.Add("10.0.0.1", "198.51.100.7\n203.0.113.9, 10.0.0.2")
+	f.Add("", strings.Repeat("10.0.0.1,", 1000))
+	f.Add("\x00", "[::1\xff]")
+
+	f.Fuzz(func(t *testing.T, remoteAddr, forwarded string) {
+		jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, 0, 1)
+		jail.IsProxied()
+		jail.MaxForwardedHops = 8
+		jail.MaxForwardedLength = 256
+		jail.UnknownVisitorKey = "unknown"
+
+		req := makeRequest(remoteAddr, false)
+		for _, header := range strings.Split(forwarded, "\n") {
+			req.Header.Add("X-Forwarded-For", header)
+		}
+
+		decision, _ := jail.identify(req)
+		if decision.Key == "" {
+			t.Logf("no key extracted from RemoteAddr %q, X-Forwarded-For %q", remoteAddr, forwarded)
+			t.FailNow()
+		}
+
+		if len(decision.ForwardedFor) > jail.MaxForwardedHops {
+			t.Logf("parsed %d hops, past the cap of %d", len(decision.ForwardedFor), jail.MaxForwardedHops)
+			t.Fail()
+		}
+		length := 0
+		for _, hop := range decision.ForwardedFor {
+			if hop == "" || hop != strings.TrimSpace(hop) || strings.Contains(hop, ",") {
+				t.Logf("malformed hop %q", hop)
+				t.Fail()
+			}
+			length += len(hop)
+		}
+		if length > jail.MaxForwardedLength {
+			t.Logf("parsed %d bytes of hops, past the cap of %d", length, jail.MaxForwardedLength)
+			t.Fail()
+		}
+	})
+}
+
+func TestForwardedClient(t *testing.T) {
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, 0, 1)
+	jail.IsProxied()
+
+	var decision Decision
+	jail.OnDecision = func(d Decision) {
+		decision = d
+	}
+
+	cases := []struct {
+		forwarded []string
+		expected  string
+	}{
+		{[]string{"203.0.113.9, 198.51.100.20, 10.0.0.1"}, "203.0.113.9"},
+		{[]string{" 203.0.113.9 ,10.0.0.1 "}, "203.0.113.9"},
+		{[]string{"203.0.113.9:4711, 10.0.0.1"}, "203.0.113.9"},
+		{[]string{"[2001:DB8::1]:443, 10.0.0.1"}, "2001:db8::1"},
+		{[]string{"2001:db8::1", "10.0.0.1"}, "2001:db8::1"},
+		{[]string{"unknown, 198.51.100.20"}, "198.51.100.20"},
+		{[]string{"not-an-ip"}, "192.0.2.1"},
+	}
+
+	for _, c := range cases {
+		req := makeRequest("192.0.2.1:1234", false)
+		for _, header := range c.forwarded {
+			req.Header.Add("X-Forwarded-For", header)
+		}
+		serveJail(jail, req)
+		if decision.Key != c.expected {
+			t.Logf("X-Forwarded-For %q keyed as %q, expected %q", c.forwarded, decision.Key, c.expected)
+			t.Fail()
+		}
+	}
+
+	// every hop chain from one client counts against the same visitor
+	first := makeRequest("192.0.2.1", false)
+	first.Header.Set("X-Forwarded-For", "198.51.100.7, 10.0.0.1")
+	second := makeRequest("192.0.2.2", false)
+	second.Header.Set("X-Forwarded-For", "198.51.100.7, 10.0.0.2, 10.0.0.3")
+	if !serveJail(jail, first) {
+		t.Log("first request denied")
+		t.Fail()
+	}
+	if serveJail(jail, second) {
+		t.Log("request through a different proxy chain counted as a new visitor")
+		t.Fail()
+	}
+}
+
+func TestTrustedProxyCount(t *testing.T) {
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, 0, 1)
+	jail.IsProxied()
+
+	var decision Decision
+	jail.OnDecision = func(d Decision) {
+		decision = d
+	}
+
+	cases := []struct {
+		trusted   int
+		forwarded []string
+		expected  string
+	}{
+		// the load balancer appends the client it saw
+		{1, []string{"203.0.113.9"}, "203.0.113.9"},
+		// a spoofed left-most hop is ignored
+		{1, []string{"1.1.1.1, 203.0.113.9"}, "203.0.113.9"},
+		// CDN then load balancer, across repeated headers
+		{2, []string{"1.1.1.1, 203.0.113.9", "198.51.100.20"}, "203.0.113.9"},
+		{2, []string{"203.0.113.9, , 198.51.100.20 "}, "203.0.113.9"},
+		// too short a chain falls back to the real peer
+		{2, []string{"203.0.113.9"}, "192.0.2.1"},
+		{1, nil, "192.0.2.1"},
+		// so does a trusted hop that isn't an IP
+		{1, []string{"203.0.113.9, garbage"}, "192.0.2.1"},
+	}
+
+	for _, c := range cases {
+		jail.TrustedProxyCount = c.trusted
+		req := makeRequest("192.0.2.1:1234", false)
+		for _, header := range c.forwarded {
+			req.Header.Add("X-Forwarded-For", header)
+		}
+		serveJail(jail, req)
+		if decision.Key != c.expected {
+			t.Logf("%d trusted proxies, X-Forwarded-For %q: keyed as %q, expected %q", c.trusted, c.forwarded,
+				decision.Key, c.expected)
+			t.Fail()
+		}
+	}
+
+	// padding the left of the chain can't push the real client past the hop cap
+	jail.TrustedProxyCount = 1
+	req := makeRequest("192.0.2.1:1234", false)
+	req.Header.Set("X-Forwarded-For", strings.Repeat("1.1.1.1, ", 1000)+"203.0.113.77")
+	serveJail(jail, req)
+	if decision.Key != "203.0.113.77" {
+		t.Logf("padded chain keyed as %q", decision.Key)
+		t.Fail()
+	}
+}
+
+func TestProxyHeader(t *testing.T) {
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, 0, 1)
+	jail.IsProxied()
+	jail.ProxyHeader = "X-Real-IP"
+
+	var decision Decision
+	jail.OnDecision = func(d Decision) {
+		decision = d
+	}
+
+	req := makeRequest("10.0.0.1:1234", false)
+	req.Header.Set("X-Real-IP", "203.0.113.9")
+	req.Header.Set("X-Forwarded-For", "1.1.1.1")
+	if !serveJail(jail, req) || decision.Key != "203.0.113.9" {
+		t.Logf("X-Real-IP request keyed as %q", decision.Key)
+		t.Fail()
+	}
+
+	// the same client through another nginx worker is the same visitor
+	req = makeRequest("10.0.0.2:5678", false)
+	req.Header.Set("X-Real-IP", "203.0.113.9")
+	if serveJail(jail, req) {
+		t.Log("second request from the X-Real-IP client allowed")
+		t.Fail()
+	}
+	if count := jail.visitors.CountVisits("203.0.113.9", time.Time{}); count != 2 {
+		t.Logf("X-Real-IP client tracked with %d visits, expected 2", count)
+		t.Fail()
+	}
+
+	// without the header the socket address is used
+	serveJail(jail, makeRequest("10.0.0.3:9999", false))
+	if decision.Key != "10.0.0.3" {
+		t.Logf("request without X-Real-IP keyed as %q", decision.Key)
+		t.Fail()
+	}
+}
@@ -1,3 +1,3 @@
 module github.com/nate-anderson/httpjail
 
-go 1.15
+go 1.18
@@ -0,0 +1,169 @@
+package httpjail
+
+import (
+	"sync"
+	"time"
+)
+
+// GossipPeer receives visitor counts gossiped by another node
+type GossipPeer interface {
+	ReceiveGossip(node string, counts map[string]int)
+}
+
+// GossipVisitorLog wraps a local VisitorLog and merges in the visitor counts gossiped by other nodes, giving each
+// node an approximate cluster-wide view without a shared store. Remote counts are the visits a peer saw in its
+// window as of its last gossip, so the shared limit is only approximate and lags by up to one gossip interval.
+type GossipVisitorLog struct {
+	node   string
+	local  VisitorLog
+	window time.Duration
+	// source of the current time, defaults to the system clock
+	Clock Clock
+
+	mux sync.Mutex
+	// keys logged locally that may still have visits in the window
+	keys map[string]struct{}
+	// latest counts received from each node
+	remote map[string]gossipCounts
+	stop   chan struct{}
+}
+
+// gossipCounts is a snapshot of a node's counts and when it arrived
+type gossipCounts struct {
+	counts   map[string]int
+	received time.Time
+}
+
+// NewGossipVisitorLog wraps local as the named node, gossiping counts over the provided window
+func NewGossipVisitorLog(node string, local VisitorLog, window time.Duration) *GossipVisitorLog {
+	return &GossipVisitorLog{
+		node:   node,
+		local:  local,
+		window: window,
+		Clock:  realClock{},
+		keys:   make(map[string]struct{}),
+		remote: make(map[string]gossipCounts),
+	}
+}
+
+// LogVisit logs a visitor request locally
+func (l *GossipVisitorLog) LogVisit(key string) {
+	l.local.LogVisit(key)
+
+	l.mux.Lock()
+	l.keys[key] = struct{}{}
+	l.mux.Unlock()
+}
+
+// Reset drops a visitor's local visits, if the local log supports it. Counts gossiped by other nodes are kept
+// until they next gossip.
+func (l *GossipVisitorLog) Reset(key string) {
+	if resetter, ok := l.local.(visitorResetter); ok {
+		resetter.Reset(key)
+	}
+
+	l.mux.Lock()
+	delete(l.keys, key)
+	l.mux.Unlock()
+}
+
+// CountVisits counts the visitor's local visits plus the latest counts gossiped by other nodes
+func (l *GossipVisitorLog) CountVisits(key string, since time.Time) int {
+	count := l.local.CountVisits(key, since)
+	now := nowFrom(l.Clock)
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	for node, snapshot := range l.remote {
+		// gossip older than a window describes visits that have since expired
+		if now.Sub(snapshot.received) > l.window {
+			delete(l.remote, node)
+			continue
+		}
+		count += snapshot.counts[key]
+	}
+	return count
+}
+
+// ReceiveGossip replaces the counts held for a node
+func (l *GossipVisitorLog) ReceiveGossip(node string, counts map[string]int) {
+	if node == l.node {
+		return
+	}
+
+	l.mux.Lock()
+	l.remote[node] = gossipCounts{counts: counts, received: nowFrom(l.Clock)}
+	l.mux.Unlock()
+}
+
+// Snapshot returns this node's local count for every visitor with visits in the window
+func (l *GossipVisitorLog) Snapshot() map[string]int {
+	since := nowFrom(l.Clock).Add(-l.window)
+
+	l.mux.Lock()
+	keys := make([]string, 0, len(l.keys))
+	for key := range l.keys {
+		keys = append(keys, key)
+	}
+	l.mux.Unlock()
+
+	counts := make(map[string]int, len(keys))
+	var idle []string
+	for _, key := range keys {
+		if count := l.local.CountVisits(key, since); count > 0 {
+			counts[key] = count
+		} else {
+			idle = append(idle, key)
+		}
+	}
+
+	l.mux.Lock()
+	for _, key := range idle {
+		delete(l.keys, key)
+	}
+	l.mux.Unlock()
+	return counts
+}
+
+// Gossip sends this node's snapshot to each peer
+func (l *GossipVisitorLog) Gossip(peers ...GossipPeer) {
+	snapshot := l.Snapshot()
+	for _, peer := range peers {
+		peer.ReceiveGossip(l.node, snapshot)
+	}
+}
+
+// StartGossip gossips to peers every interval in a background goroutine until Close is called
+func (l *GossipVisitorLog) StartGossip(interval time.Duration, peers ...GossipPeer) {
+	l.mux.Lock()
+	if l.stop != nil {
+		l.mux.Unlock()
+		return
+	}
+	stop := make(chan struct{})
+	l.stop = stop
+	l.mux.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				l.Gossip(peers...)
+			case <-stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops background gossip
+func (l *GossipVisitorLog) Close() {
+	l.mux.Lock()
+	if l.stop != nil {
+		close(l.stop)
+		l.stop = nil
+	}
+	l.mux.Unlock()
+}
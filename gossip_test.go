@@ -0,0 +1,62 @@
+package httpjail
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGossipVisitorLogSharedLimit(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	window := time.Minute
+
+	newNode := func(name string) (*GossipVisitorLog, *Jail) {
+		local := NewDefaultVisitorLog()
+		local.Clock = clock
+		gossipLog := NewGossipVisitorLog(name, local, window)
+		gossipLog.Clock = clock
+
+		jail := NewJail(gossipLog, window, 0, 4)
+		jail.Clock = clock
+		return gossipLog, jail
+	}
+
+	logA, jailA := newNode("a")
+	logB, jailB := newNode("b")
+
+	// the client spreads its requests across both nodes
+	for i := 0; i < 2; i++ {
+		if !serveJail(jailA, makeRequest("1.2.3.4", false)) {
+			t.Logf("node a denied request %d", i)
+			t.Fail()
+		}
+		if !serveJail(jailB, makeRequest("1.2.3.4", false)) {
+			t.Logf("node b denied request %d", i)
+			t.Fail()
+		}
+	}
+
+	logA.Gossip(logB)
+	logB.Gossip(logA)
+
+	if count := logA.CountVisits("1.2.3.4", clock.Now().Add(-window)); count != 4 {
+		t.Logf("incorrect cluster-wide count on node a: got %d, expected %d", count, 4)
+		t.Fail()
+	}
+
+	// both nodes now see the shared limit as spent
+	if serveJail(jailA, makeRequest("1.2.3.4", false)) {
+		t.Log("node a allowed a request over the shared limit")
+		t.Fail()
+	}
+	if serveJail(jailB, makeRequest("1.2.3.4", false)) {
+		t.Log("node b allowed a request over the shared limit")
+		t.Fail()
+	}
+
+	// stale gossip expires with the window
+	clock.Advance(window + time.Second)
+	if count := logA.CountVisits("1.2.3.4", clock.Now().Add(-window)); count != 0 {
+		t.Logf("stale gossip still counted: got %d", count)
+		t.Fail()
+	}
+}
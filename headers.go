@@ -0,0 +1,60 @@
+package httpjail
+
+import (
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	headerLimit      = "X-RateLimit-Limit"
+	headerRemaining  = "X-RateLimit-Remaining"
+	headerReset      = "X-RateLimit-Reset"
+	headerWarning    = "X-RateLimit-Warning"
+	headerWouldBlock = "X-RateLimit-Would-Block"
+	headerRetry      = "Retry-After"
+)
+
+// setRateLimitHeaders writes the decision's limit, remaining requests and reset time (as a unix timestamp)
+func setRateLimitHeaders(header http.Header, decision Decision) {
+	header.Set(headerLimit, strconv.Itoa(decision.Limit.AllowedRequests))
+	header.Set(headerRemaining, strconv.Itoa(decision.Remaining))
+	header.Set(headerReset, strconv.FormatInt(decision.Reset.Unix(), 10))
+}
+
+// setRetryAfter writes the wait as whole seconds, rounded up so clients never retry before the block lifts
+func setRetryAfter(header http.Header, wait time.Duration) {
+	header.Set(headerRetry, strconv.FormatInt(retrySeconds(wait), 10))
+}
+
+// retrySeconds rounds a wait up to whole seconds
+func retrySeconds(wait time.Duration) int64 {
+	seconds := int64(wait / time.Second)
+	if wait%time.Second > 0 {
+		seconds++
+	}
+	return seconds
+}
+
+// jitter adds a random delay of up to RetryAfterJitter to an advertised wait
+func (j *Jail) jitter(wait time.Duration) time.Duration {
+	if j.RetryAfterJitter <= 0 {
+		return wait
+	}
+	return wait + time.Duration(j.random()*float64(j.RetryAfterJitter))
+}
+
+// random returns a pseudo-random number in [0, 1) from the jail's Random source
+func (j *Jail) random() float64 {
+	if j.Random != nil {
+		return j.Random()
+	}
+	return rand.Float64()
+}
+
+// declareRateLimitTrailers announces the X-RateLimit-* trailers, which must happen before the body is written
+func declareRateLimitTrailers(header http.Header) {
+	header.Add("Trailer", strings.Join([]string{headerLimit, headerRemaining, headerReset}, ", "))
+}
@@ -0,0 +1,348 @@
+package httpjail
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRateLimitTrailers(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	jail := NewJailForTesting(clock, time.Minute, 0, 5)
+	jail.UseTrailers = true
+
+	streaming := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		for i := 0; i < 3; i++ {
+			fmt.Fprintf(w, "chunk %d\n", i)
+			w.(http.Flusher).Flush()
+		}
+	})
+
+	srv := httptest.NewServer(jail.Middleware(streaming))
+	defer srv.Close()
+
+	res, err := http.Get(srv.URL)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	defer res.Body.Close()
+
+	if len(res.TransferEncoding) == 0 || res.TransferEncoding[0] != "chunked" {
+		t.Logf("response was not chunked: %v", res.TransferEncoding)
+		t.Fail()
+	}
+
+	if res.Header.Get(headerRemaining) != "" {
+		t.Log("rate limit info sent as a header instead of a trailer")
+		t.Fail()
+	}
+
+	// trailers are only populated once the body is consumed
+	if _, err := ioutil.ReadAll(res.Body); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if limit := res.Trailer.Get(headerLimit); limit != "5" {
+		t.Logf("incorrect limit trailer: got %q", limit)
+		t.Fail()
+	}
+	if remaining := res.Trailer.Get(headerRemaining); remaining != "4" {
+		t.Logf("incorrect remaining trailer: got %q", remaining)
+		t.Fail()
+	}
+
+	reset := strconv.FormatInt(clock.Now().Add(time.Minute).Unix(), 10)
+	if res.Trailer.Get(headerReset) != reset {
+		t.Logf("incorrect reset trailer: got %q, expected %q", res.Trailer.Get(headerReset), reset)
+		t.Fail()
+	}
+}
+
+func TestSoftLimitWarning(t *testing.T) {
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, 0, 4)
+	jail.SoftLimit = 2
+
+	handler := jail.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(successRes))
+	}))
+
+	// under the soft limit, over the soft limit, over the hard limit
+	expected := []struct {
+		allowed, warned bool
+	}{
+		{true, false},
+		{true, false},
+		{true, true},
+		{true, true},
+		{false, false},
+	}
+
+	for i, e := range expected {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, makeRequest("1.2.3.4", false))
+
+		allowed := rec.Body.String() == successRes
+		warned := rec.Header().Get(headerWarning) != ""
+		if allowed != e.allowed || warned != e.warned {
+			t.Logf("request %d: allowed %t warned %t, expected allowed %t warned %t", i+1, allowed, warned, e.allowed, e.warned)
+			t.Fail()
+		}
+	}
+}
+
+func TestRetryAfterJitter(t *testing.T) {
+	cooloff := time.Minute
+	jitter := 30 * time.Second
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, cooloff, 0)
+	jail.RetryAfterJitter = jitter
+
+	handler := jail.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+
+	advertised := make(map[int]bool)
+	for i := 0; i < 50; i++ {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, makeRequest(fmt.Sprintf("10.0.0.%d", i), false))
+
+		seconds, err := strconv.Atoi(rec.Header().Get("Retry-After"))
+		if err != nil {
+			t.Logf("invalid Retry-After: %s", err)
+			t.FailNow()
+		}
+		if seconds < int(cooloff.Seconds()) || seconds > int((cooloff+jitter).Seconds()) {
+			t.Logf("Retry-After %d outside the jitter bounds", seconds)
+			t.Fail()
+		}
+		advertised[seconds] = true
+	}
+
+	if len(advertised) < 2 {
+		t.Logf("Retry-After didn't vary: %v", advertised)
+		t.Fail()
+	}
+}
+
+func TestRetryAfterJitterSeeded(t *testing.T) {
+	cooloff := time.Minute
+	jitter := 30 * time.Second
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, cooloff, 0)
+	jail.RetryAfterJitter = jitter
+	jail.Random = rand.New(rand.NewSource(42)).Float64
+
+	handler := jail.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+
+	// the same seed replays the exact jitter sequence
+	expected := rand.New(rand.NewSource(42))
+	for i := 0; i < 10; i++ {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, makeRequest(fmt.Sprintf("10.0.0.%d", i), false))
+
+		want := strconv.FormatInt(retrySeconds(cooloff+time.Duration(expected.Float64()*float64(jitter))), 10)
+		if got := rec.Header().Get("Retry-After"); got != want {
+			t.Logf("request %d: Retry-After %s, expected %s", i, got, want)
+			t.Fail()
+		}
+	}
+
+	// a fixed source pins the jitter exactly
+	jail.Random = func() float64 { return 0.5 }
+	if wait := jail.jitter(cooloff); wait != cooloff+jitter/2 {
+		t.Logf("jitter with a fixed source gave %s, expected %s", wait, cooloff+jitter/2)
+		t.Fail()
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	retryAfter := func(jail *Jail) string {
+		rec := httptest.NewRecorder()
+		jail.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {})).
+			ServeHTTP(rec, makeRequest("1.2.3.4", false))
+		return rec.Header().Get(headerRetry)
+	}
+
+	// with a cooloff, repeat offenders see the time left on their sentence count down
+	clock := NewFakeClock(time.Now())
+	jail := NewJailForTesting(clock, time.Minute, 90*time.Second, 1)
+	if wait := retryAfter(jail); wait != "" {
+		t.Logf("allowed request advertised Retry-After %s", wait)
+		t.Fail()
+	}
+	for _, expected := range []string{"90", "60", "30"} {
+		if wait := retryAfter(jail); wait != expected {
+			t.Logf("sentenced request: Retry-After %s, expected %s", wait, expected)
+			t.Fail()
+		}
+		clock.Advance(30 * time.Second)
+	}
+
+	// without a cooloff the block lasts until the window clears
+	clock = NewFakeClock(time.Now())
+	jail = NewJailForTesting(clock, time.Minute, 0, 1)
+	retryAfter(jail)
+	if wait := retryAfter(jail); wait != "60" {
+		t.Logf("window block: Retry-After %s, expected 60", wait)
+		t.Fail()
+	}
+}
+
+func TestRetryAfterRoundsUp(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	jail := NewJailForTesting(clock, time.Minute, 10*time.Second, 1)
+	handler := jail.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), makeRequest("1.2.3.4", false))
+	handler.ServeHTTP(httptest.NewRecorder(), makeRequest("1.2.3.4", false))
+	sentenced := clock.Now()
+
+	cases := []struct {
+		elapsed  time.Duration
+		expected string
+	}{
+		{0, "10"},
+		{time.Millisecond, "10"},
+		{8500 * time.Millisecond, "2"},
+		{9999 * time.Millisecond, "1"},
+	}
+	for _, c := range cases {
+		clock.Set(sentenced.Add(c.elapsed))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, makeRequest("1.2.3.4", false))
+		if wait := rec.Header().Get(headerRetry); wait != c.expected {
+			t.Logf("%s into the sentence: Retry-After %s, expected %s", c.elapsed, wait, c.expected)
+			t.Fail()
+		}
+	}
+}
+
+func TestRateLimitHeaders(t *testing.T) {
+	clock := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	jail := NewJailForTesting(clock, time.Minute, 0, 3)
+	handler := jail.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+
+	reset := strconv.FormatInt(clock.Now().Add(time.Minute).Unix(), 10)
+	for i, remaining := range []string{"2", "1", "0", "0"} {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, makeRequest("1.2.3.4", false))
+
+		blocked := w.Code == http.StatusTooManyRequests
+		if blocked != (i == 3) {
+			t.Logf("request %d: status %d", i+1, w.Code)
+			t.Fail()
+		}
+		if limit := w.Header().Get(headerLimit); limit != "3" {
+			t.Logf("request %d: limit header %q, expected 3", i+1, limit)
+			t.Fail()
+		}
+		if got := w.Header().Get(headerRemaining); got != remaining {
+			t.Logf("request %d: remaining header %q, expected %s", i+1, got, remaining)
+			t.Fail()
+		}
+		if got := w.Header().Get(headerReset); got != reset {
+			t.Logf("request %d: reset header %q, expected %s", i+1, got, reset)
+			t.Fail()
+		}
+	}
+
+	// the headers can be switched off
+	jail.NoRateLimitHeaders = true
+	clock.Advance(2 * time.Minute)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, makeRequest("1.2.3.4", false))
+	if w.Header().Get(headerRemaining) != "" {
+		t.Log("rate limit headers sent with NoRateLimitHeaders set")
+		t.Fail()
+	}
+}
+
+func TestJSONBlockBody(t *testing.T) {
+	clock := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 500, time.UTC))
+	jail := NewJailForTesting(clock, time.Minute, 0, 1)
+	handler := jail.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+
+	request := func() *httptest.ResponseRecorder {
+		req := makeRequest("1.2.3.4", false)
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, req)
+		return w
+	}
+
+	request()
+	clock.Advance(20 * time.Second)
+	w := request()
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Content-Type") != "application/json" {
+		t.Logf("blocked JSON request got %d %s", w.Code, w.Header().Get("Content-Type"))
+		t.FailNow()
+	}
+
+	var body blockBody
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	header, _ := strconv.ParseInt(w.Header().Get(headerReset), 10, 64)
+	if body.Reset.Unix() != header {
+		t.Logf("body resets at %v, header at %d", body.Reset, header)
+		t.Fail()
+	}
+	if body.RetryAfter != 60 || body.Error == "" {
+		t.Logf("incorrect body: %+v", body)
+		t.Fail()
+	}
+
+	// clients that don't ask for JSON get the plain message
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, makeRequest("1.2.3.4", false))
+	if rec.Body.String() != blockMessage {
+		t.Logf("plain block body %q", rec.Body.String())
+		t.Fail()
+	}
+}
+
+func TestOnBlocked(t *testing.T) {
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, 0, 1)
+	jail.OnBlocked = func(w http.ResponseWriter, req *http.Request, retryAfter time.Duration) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusTooManyRequests)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"code":        "rate_limited",
+			"retry_after": retryAfter.Seconds(),
+		})
+	}
+	handler := jail.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(successRes))
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), makeRequest("1.2.3.4", false))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, makeRequest("1.2.3.4", false))
+
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Content-Type") != "application/json" {
+		t.Logf("custom blocked response got %d %s", w.Code, w.Header().Get("Content-Type"))
+		t.FailNow()
+	}
+	var body struct {
+		Code       string  `json:"code"`
+		RetryAfter float64 `json:"retry_after"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if body.Code != "rate_limited" || body.RetryAfter != 60 {
+		t.Logf("incorrect custom body: %+v", body)
+		t.Fail()
+	}
+	// the jail's headers are still set
+	if w.Header().Get("Retry-After") != "60" || w.Header().Get(headerRemaining) != "0" {
+		t.Logf("custom blocked response missing headers: %v", w.Header())
+		t.Fail()
+	}
+}
@@ -0,0 +1,37 @@
+package httpjail
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+)
+
+// HTTP/2 multiplexes many concurrent requests (streams) over one connection, and every stream on a connection
+// shares its RemoteAddr. net/http doesn't expose stream identifiers, so a jail can't key by stream: all streams on a
+// connection count against the same visitor, just as pipelined HTTP/1.1 requests do. Key by IP (the default) to
+// treat every connection from a client as one visitor, or by connection with ConnContext and KeyByConnection to give
+// each connection its own budget even when a proxy hides the client's port.
+
+// connIDKey is the context key holding a connection's ID
+type connIDKey struct{}
+
+// lastConnID is the most recently assigned connection ID
+var lastConnID uint64
+
+// ConnContext tags each connection with a unique ID for KeyByConnection. Install it as http.Server.ConnContext.
+func ConnContext(ctx context.Context, conn net.Conn) context.Context {
+	id := atomic.AddUint64(&lastConnID, 1)
+	return context.WithValue(ctx, connIDKey{}, strconv.FormatUint(id, 10))
+}
+
+// KeyByConnection keys visitors by the connection their request arrived on, so every HTTP/2 stream on a connection
+// shares a budget. Requests from servers without ConnContext installed get an empty key and fall back to the IP.
+func KeyByConnection(req *http.Request) string {
+	id, ok := req.Context().Value(connIDKey{}).(string)
+	if !ok {
+		return ""
+	}
+	return "conn:" + id
+}
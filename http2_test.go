@@ -0,0 +1,81 @@
+package httpjail
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countConcurrentAllowed fires concurrent requests from one HTTP/2 client and counts the successful responses
+func countConcurrentAllowed(t *testing.T, jail *Jail, requests int) int {
+	srv := httptest.NewUnstartedServer(jail.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.ProtoMajor != 2 {
+			t.Logf("request used HTTP/%d, expected HTTP/2", req.ProtoMajor)
+			t.Fail()
+		}
+		w.Write([]byte(successRes))
+	})))
+	srv.EnableHTTP2 = true
+	srv.Config.ConnContext = ConnContext
+	srv.StartTLS()
+	defer srv.Close()
+
+	client := srv.Client()
+
+	// open the connection first so every request multiplexes over it
+	warmup, err := client.Get(srv.URL)
+	if err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	ioutil.ReadAll(warmup.Body)
+	warmup.Body.Close()
+
+	var wg sync.WaitGroup
+	var mux sync.Mutex
+	allowed := 0
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := client.Get(srv.URL)
+			if err != nil {
+				t.Log(err)
+				t.Fail()
+				return
+			}
+			defer res.Body.Close()
+			body, _ := ioutil.ReadAll(res.Body)
+			if string(body) == successRes {
+				mux.Lock()
+				allowed++
+				mux.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return allowed
+}
+
+func TestHTTP2StreamsShareBudget(t *testing.T) {
+	// streams from one client share a budget whether keyed by address or by connection
+	keyFuncs := map[string]KeyFunc{
+		"address":    nil,
+		"connection": KeyByConnection,
+	}
+
+	for name, keyFunc := range keyFuncs {
+		jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, 0, 5)
+		jail.KeyFunc = keyFunc
+
+		// the warmup request takes one unit of the budget
+		allowed := countConcurrentAllowed(t, jail, 10)
+		if allowed != 4 {
+			t.Logf("keyed by %s: %d concurrent streams allowed, expected %d", name, allowed, 4)
+			t.Fail()
+		}
+	}
+}
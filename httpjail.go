@@ -1,9 +1,15 @@
 package httpjail
 
 import (
+	"container/list"
+	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
+	"sort"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -11,126 +17,879 @@ import (
 type Jail struct {
 	// is the server running behind a proxy or load balancer?
 	isProxied bool
-	// number of requests to allow
+	// exact number of requests allowed per visitor in the window: request AllowedRequests is let through and
+	// request AllowedRequests+1 is blocked
 	AllowedRequests int
+	// number of requests after which allowed responses carry an X-RateLimit-Warning header, 0 disables the warning
+	SoftLimit int
 	// duration to consider request coutn
 	Window time.Duration
-	// should jailed clients recieve no response?
+	// should jailed clients receive an empty body? The 429 status is sent either way.
 	NoRespond bool
 	visitors  VisitorLog
 	// duration to prevent requests after limit is reached
 	Cooloff   time.Duration
 	Sentences map[string]time.Time
+	// limit purely by window, never sentencing visitors or touching Sentences
+	NoSentencing bool
+	// source of the current time, defaults to the system clock
+	Clock Clock
+	// header carrying the client IP in proxy mode, such as X-Real-IP, defaults to X-Forwarded-For. Requests without
+	// it are keyed by RemoteAddr.
+	ProxyHeader string
+	// number of proxies in front of the server that append to X-Forwarded-For. When set, the client IP is taken
+	// this many hops from the right of the chain instead of the spoofable left-most hop, falling back to RemoteAddr
+	// if the chain is shorter.
+	TrustedProxyCount int
+	// maximum number of X-Forwarded-For hops to parse, defaults to 50
+	MaxForwardedHops int
+	// maximum number of X-Forwarded-For bytes to consider, defaults to 4096
+	MaxForwardedLength int
+	// derives the visitor key from a request, defaults to the client IP
+	KeyFunc KeyFunc
+	// key shared by requests with no visitor key, such as an empty RemoteAddr. When empty those requests aren't
+	// limited at all (fail open).
+	UnknownVisitorKey string
+	// limits for specific hosts, each counted separately from the default limit
+	HostLimits map[string]Limit
+	// origins (scheme://host[:port]) of first-party frontends
+	OriginAllowlist []string
+	// limit for requests from an allowlisted Origin or Referer, typically looser than the default limit
+	FirstPartyLimit *Limit
+	// limits for specific routes, each counted separately from the default limit. Route limits take precedence
+	// over host limits.
+	Routes []RouteLimit
+	// limits for traffic classes tagged with WithClass, each counted separately from the default limit. Class limits
+	// take precedence over route and host limits. To exempt a class entirely, leave it out of Metered.
+	ClassLimits map[string]Limit
+	// additional limits every visitor is held to alongside the rule its request falls under, such as 10 requests a
+	// second under a limit of 100 a minute. Each is counted separately, and a request over any of them is blocked.
+	// Requires a visitor log that honors CountVisits' since, such as DefaultVisitorLog.
+	ExtraLimits []Limit
+	// additional keys every request is counted under, each with its own limit. A request over any of them is
+	// blocked, even if it's within its own limit.
+	KeyLimits []KeyLimit
+	// receives block counts and overage observations
+	Metrics Metrics
+	// maximum random delay added to the Retry-After advertised to blocked clients, to spread out their retries
+	RetryAfterJitter time.Duration
+	// source of pseudo-random numbers in [0, 1) for jitter, defaults to math/rand. Set a seeded source such as
+	// rand.New(rand.NewSource(1)).Float64 to make randomized behavior deterministic in tests; it must be safe for
+	// concurrent use if the jail serves concurrent requests.
+	Random func() float64
+	// number of most-blocked visitors reported by Offenders, 0 disables tracking
+	TopOffenders int
+	// called with every decision the middleware makes
+	OnDecision func(decision Decision)
+	// called once when a visitor goes from allowed to blocked, not again for its further blocked requests until it's
+	// allowed a request, for alerting
+	OnFirstBlock func(key string)
+	// called when a visitor is sentenced, with the time it will be released, but not for its blocked requests while
+	// serving the sentence. For alerting or feeding other blocklists.
+	OnSentence func(key string, release time.Time)
+	// writes the response to blocked requests in place of the default 429 and message, such as a JSON error or a
+	// themed page. The Retry-After and X-RateLimit-* headers are already set. retryAfter is 0 for denylisted clients.
+	OnBlocked func(w http.ResponseWriter, req *http.Request, retryAfter time.Duration)
+	// throttle instead of counting: allow the first request, then block until Window passes without an allowed request
+	LeadingEdge bool
+	// a retry carrying an already seen Idempotency-Key within this duration doesn't consume budget, though further
+	// repeats of the key do (0 disables)
+	IdempotencyWindow time.Duration
+	// share one handler call between concurrent identical GET and HEAD requests (same visitor, method, URL and body),
+	// for expensive idempotent endpoints. Coalesced requests still count against the limit.
+	CoalesceRequests bool
+	// largest response body buffered to replay to coalesced requests, beyond it each request runs the handler itself
+	// (defaults to 1 MiB)
+	MaxCoalescedBody int
+	// leave the X-RateLimit-* headers off responses, which carry them by default
+	NoRateLimitHeaders bool
+	// attach each allowed request's decision to its context for downstream handlers, see InfoFromContext
+	ContextInfo bool
+	// send X-RateLimit-* values as trailers on allowed responses, for streaming handlers that flush headers early
+	UseTrailers bool
+
+	// log only allowed requests, so blocked requests don't count toward the window and a client over the limit
+	// recovers once its allowed requests age out, even if it never stops trying. By default every request is logged
+	// before the check, so a client must go quiet for a full window to recover. Visitor logs that can't take back a
+	// visit check and log in separate steps in this mode, so concurrent requests can occasionally be admitted past
+	// the limit.
+	LogAllowedOnly bool
+
+	// maximum number of sentences to hold, clients closest to release are let out early beyond it (0 is unlimited)
+	MaxSentences int
+	// double the cooloff each time a visitor is sentenced again
+	EscalateCooloff bool
+	// longest cooloff an escalated sentence can reach (0 is unlimited)
+	MaxCooloff time.Duration
+	// forget a visitor's past offenses once it has gone this long after its last sentence without being sentenced
+	// again, so escalation starts over (0 never forgets)
+	ForgiveAfter time.Duration
+	// release sentenced visitors early once they've sent no requests for this long, rewarding clients that back
+	// off (0 disables)
+	QuietRelease time.Duration
+	// keep logging visits while the jail is disabled, so counts are current when it's re-enabled
+	TrackWhileDisabled bool
+
+	// maximum concurrent Server-Sent Events streams per visitor. When set, event stream requests are limited by
+	// this instead of counting against the request budget, though sentenced visitors still can't open them.
+	MaxEventStreams int
+
+	// maximum 4xx and 5xx responses per visitor in the window before the visitor is blocked, to catch scanners
+	// (0 disables). Error responses are counted separately from the request budget.
+	ErrorBudget int
+	// handler latency above which a visitor's requests count as slow. Visitors whose requests are consistently
+	// slow get a tightened limit (0 disables adaptive limiting).
+	SlowRequest time.Duration
+	// fraction of the limit allowed to consistently slow visitors, defaults to half
+	SlowLimitFactor float64
+
+	// client IPs and ranges whose requests are counted but never blocked, for monitoring trusted clients. Build it
+	// with ParseNetworks like AllowedIPs.
+	CountOnlyIPs []*net.IPNet
+	// count every request and decide it as usual, but let it through, for trying out limits in production. Would-be
+	// blocks are flagged WouldBlock in OnDecision and fire OnFirstBlock, and would-be sentences fire OnSentence.
+	// DeniedIPs are let through too.
+	DryRun bool
+	// client IPs and ranges that bypass the jail entirely, such as health checkers and internal services. Their
+	// requests are passed straight through without being counted. See ParseNetworks.
+	AllowedIPs []*net.IPNet
+	// client IPs and ranges that are always blocked, regardless of rate and ahead of AllowedIPs. Their requests are
+	// rejected without being counted. See ParseNetworks.
+	DeniedIPs []*net.IPNet
+	// mark allowed responses to count-only clients, or any client in a dry run, that would otherwise have been
+	// blocked with X-RateLimit-Would-Block: true
+	WouldBlockHeader bool
+
+	// limits only the requests it returns true for, such as authenticated ones, passing the rest through uncounted
+	// (nil limits every request)
+	Metered func(req *http.Request) bool
+
+	// derives the account behind a request for account sharing detection, such as KeyByHeader("X-User-ID")
+	AccountFunc KeyFunc
+	// maximum distinct IPs an account may use in the window before its requests are blocked, to catch credential
+	// sharing (0 disables). Requires AccountFunc.
+	MaxAccountIPs int
+
+	// maximum callers blocked in Wait per visitor key, further callers get ErrQueueFull (0 is unlimited)
+	MaxWaiters int
+	// maximum callers blocked in Wait across all keys, further callers get ErrQueueFull (0 is unlimited)
+	MaxTotalWaiters int
+
+	// let requests through when a FallibleVisitorLog's store fails, instead of rejecting them
+	FailOpen bool
+	// responds to requests rejected because the store failed, defaults to a plain 503 Service Unavailable
+	OnStoreError http.Handler
+
+	// nonzero when limiting is switched off, accessed atomically
+	disabled int32
+	// guards the limits, routes and origin allowlist against LoadConfig
+	limitsMux sync.RWMutex
+	// guards Sentences, offenses, lastAttempts, lastReleases, wouldSentences and releases, separately from other
+	// bookkeeping so checking a sentence never waits on it
+	sentenceMux sync.RWMutex
+	// guards the jail's internal bookkeeping
+	mux         sync.Mutex
+	idempotency idempotencyCache
+	streams     map[string]int
+	latencies   map[string]visitorLatency
+	offenses    map[string]int
+	accounts    map[string]map[string]time.Time
+	// time of each sentenced visitor's latest blocked request, for QuietRelease
+	lastAttempts map[string]time.Time
+	// release time of each offender's latest sentence, for ForgiveAfter
+	lastReleases map[string]time.Time
+	// release time of each sentence a dry run would have given
+	wouldSentences map[string]time.Time
+	// sentences by release time, for MaxSentences eviction
+	releases releaseQueue
+	// stops the background cleanup started by StartCleanup
+	cleanupStop chan struct{}
+	// callers blocked in Wait, per key and in total
+	waiters      map[string]int
+	totalWaiters int
+	offenders    offenderTracker
+	// time of the latest block of each currently blocked visitor, for OnFirstBlock
+	blocked map[string]time.Time
+	// handler calls in flight for CoalesceRequests, by request fingerprint
+	coalescing map[string]*coalescedCall
 }
 
-// VisitorLog defines visitor request logging/log reading
+// VisitorLog defines visitor request logging/log reading by visitor key. The middleware derives each request's key
+// once (see KeyFunc) and passes only the key down, so visitor logs know nothing of HTTP and can be reused to count
+// any kind of event.
 type VisitorLog interface {
-	LogVisit(req *http.Request)
-	CountVisits(req *http.Request, since time.Time) int
+	// LogVisit records a visit by the key at the current time
+	LogVisit(key string)
+	// CountVisits counts the key's visits since the provided time
+	CountVisits(key string, since time.Time) int
+}
+
+// IncrementingVisitorLog is implemented by visitor logs that can log a visit and count the visitor's visits
+// atomically. The jail prefers it over LogVisit then CountVisits, which lets concurrent requests interleave between
+// the two steps.
+type IncrementingVisitorLog interface {
+	IncrementAndCount(key string, since time.Time) int
 }
 
-// IsProxied sets the jail to proxy mode, using the X-Forwarded-For header instead of the request IP
+// ResettingVisitorLog is implemented by visitor logs whose windows don't simply slide, such as aligned windows.
+// The jail uses ResetAt for a decision's reset time, and so X-RateLimit-Reset, in place of now + Window.
+type ResettingVisitorLog interface {
+	// ResetAt returns when the visitor's current window, as of now, resets
+	ResetAt(key string, now time.Time) time.Time
+}
+
+// IsProxied sets the jail to proxy mode, reading the client IP from X-Forwarded-For (or ProxyHeader)
 func (j *Jail) IsProxied() {
 	j.isProxied = true
 }
 
-// Middleware returns the jail's HTTP middleware
-func (j Jail) Middleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		// rewrite RemoteAddr if proxied
-		if j.isProxied {
-			req.RemoteAddr = req.Header.Get("X-Forwarded-For")
-		}
+// Disable switches off limiting, so the middleware passes every request through until Enable is called
+func (j *Jail) Disable() {
+	atomic.StoreInt32(&j.disabled, 1)
+}
 
-		j.visitors.LogVisit(req)
+// Enable switches limiting back on after Disable
+func (j *Jail) Enable() {
+	atomic.StoreInt32(&j.disabled, 0)
+}
+
+// Disabled reports whether limiting is switched off
+func (j *Jail) Disabled() bool {
+	return atomic.LoadInt32(&j.disabled) == 1
+}
 
-		if !j.isSentenced(req) {
-			since := time.Now().Add(-j.Window)
-			reqCount := j.visitors.CountVisits(req, since)
-			if reqCount <= j.AllowedRequests {
+// Middleware returns the jail's HTTP middleware
+func (j *Jail) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if decision, screened := j.screen(req); screened {
+			if decision.Allowed {
 				next.ServeHTTP(w, req)
-				return
+			} else {
+				j.deny(w, req)
 			}
+			return
 		}
 
-		j.sentence(req)
+		if j.MaxEventStreams > 0 && isEventStream(req) {
+			j.serveEventStream(w, req, next)
+			return
+		}
+
+		decision := j.judge(req)
+		if decision.Allowed {
+			j.serve(w, req, next, decision)
+			return
+		}
 
-		if !j.NoRespond {
-			fmt.Fprint(w, "You are doing that too much. Please slow down and try again later.")
+		if decision.Err != nil {
+			j.storeError(w, req)
 			return
 		}
+		j.block(w, req, decision)
 	})
 }
 
-// isSentenced checks if the address is subject to a cooloff period
-func (j Jail) isSentenced(req *http.Request) bool {
-	release, isJailed := j.Sentences[req.RemoteAddr]
-	return isJailed && release.After(time.Now())
+// serve passes an allowed request to the next handler
+func (j *Jail) serve(w http.ResponseWriter, req *http.Request, next http.Handler, decision Decision) {
+	// requests the jail let through without counting have no limit to report
+	counted := !decision.Reset.IsZero()
+	if j.UseTrailers && counted {
+		declareRateLimitTrailers(w.Header())
+		defer setRateLimitHeaders(w.Header(), decision)
+	} else if !j.NoRateLimitHeaders && counted {
+		setRateLimitHeaders(w.Header(), decision)
+	}
+
+	if j.WouldBlockHeader && decision.WouldBlock {
+		w.Header().Set(headerWouldBlock, "true")
+	}
+
+	if soft := decision.Limit.SoftLimit; soft > 0 && decision.Count > soft {
+		w.Header().Set(headerWarning, "soft limit exceeded, slow down to avoid being blocked")
+	}
+
+	if j.SlowRequest > 0 && decision.Key != "" {
+		start := j.now()
+		defer func() {
+			end := j.now()
+			j.observeLatency(decision.Key, end.Sub(start), end)
+		}()
+	}
+
+	if j.ErrorBudget > 0 && decision.Key != "" {
+		recorder := &statusRecorder{ResponseWriter: w}
+		defer func() {
+			if recorder.status >= 400 {
+				j.visitors.LogVisit(errorBucket(decision.Key))
+			}
+		}()
+		w = recorder
+	}
+
+	if j.ContextInfo {
+		req = withDecision(req, decision)
+	}
+
+	if j.CoalesceRequests && decision.Key != "" {
+		j.coalesce(w, req, next, decision.Key)
+		return
+	}
+	next.ServeHTTP(w, req)
+}
+
+// block responds to a request the jail rejected
+func (j *Jail) block(w http.ResponseWriter, req *http.Request, decision Decision) {
+	observeBlock(j.Metrics, decision)
+	j.recordOffender(decision.Key, decision.Time)
+
+	// blocks decided before counting, such as by the error budget, report nothing remaining until the block lifts
+	if decision.Reset.IsZero() {
+		decision.Remaining, decision.Reset = 0, decision.Time.Add(decision.RetryAfter)
+	}
+	if !j.NoRateLimitHeaders || j.UseTrailers {
+		setRateLimitHeaders(w.Header(), decision)
+	}
+	wait := j.jitter(decision.RetryAfter)
+	setRetryAfter(w.Header(), wait)
+	if j.OnBlocked != nil {
+		j.OnBlocked(w, req, wait)
+		return
+	}
+
+	status := decision.Limit.BlockStatus
+	if status == 0 {
+		status = http.StatusTooManyRequests
+	}
+	jsonBody := !j.NoRespond && acceptsJSON(req)
+	if jsonBody {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	// the status is sent even when NoRespond skips the body, so clients never mistake a block for success
+	w.WriteHeader(status)
+
+	switch {
+	case jsonBody:
+		json.NewEncoder(w).Encode(blockBody{
+			Error:      blockMessage,
+			Reset:      decision.Reset.UTC().Truncate(time.Second),
+			RetryAfter: retrySeconds(wait),
+		})
+	case !j.NoRespond:
+		fmt.Fprint(w, blockMessage)
+	}
+}
+
+const blockMessage = "You are doing that too much. Please slow down and try again later."
+
+// blockBody is the JSON body of a blocked response. Reset matches X-RateLimit-Reset as an RFC 3339 timestamp, for
+// clients that don't read headers.
+type blockBody struct {
+	Error      string    `json:"error"`
+	Reset      time.Time `json:"reset"`
+	RetryAfter int64     `json:"retry_after"`
+}
+
+// acceptsJSON reports whether the client asked for JSON responses
+func acceptsJSON(req *http.Request) bool {
+	for _, accept := range req.Header.Values("Accept") {
+		if strings.Contains(accept, "application/json") {
+			return true
+		}
+	}
+	return false
+}
+
+// now returns the current time according to the jail's clock
+func (j *Jail) now() time.Time {
+	return nowFrom(j.Clock)
+}
+
+// key derives the visitor key for a request, falling back to the client IP
+func (j *Jail) key(req *http.Request) string {
+	if j.KeyFunc != nil {
+		if key := j.KeyFunc(req); key != "" {
+			return key
+		}
+	}
+	return KeyByIP(req)
+}
+
+// isSentenced checks if the key is subject to a cooloff period at the time now
+func (j *Jail) isSentenced(key string, now time.Time) bool {
+	if j.NoSentencing {
+		return false
+	}
+	j.sentenceMux.RLock()
+	release, isJailed := j.Sentences[key]
+	j.sentenceMux.RUnlock()
+	return isJailed && release.After(now)
+}
+
+// releaseExpired drops the key's sentence if it has been served, reporting whether it did. Most requests come from
+// visitors without a sentence, so the check runs under the read lock and only a release takes the write lock.
+func (j *Jail) releaseExpired(key string, now time.Time) bool {
+	if j.NoSentencing {
+		return false
+	}
+	j.sentenceMux.RLock()
+	due := j.releaseDue(key, now)
+	j.sentenceMux.RUnlock()
+	if !due {
+		return false
+	}
+
+	j.sentenceMux.Lock()
+	defer j.sentenceMux.Unlock()
+	// another request may have released or re-sentenced the key in between
+	if !j.releaseDue(key, now) {
+		return false
+	}
+	j.unsentence(key)
+	return true
+}
+
+// releaseDue reports whether the key has a sentence that's been served. The caller must hold j.sentenceMux.
+func (j *Jail) releaseDue(key string, now time.Time) bool {
+	release, jailed := j.Sentences[key]
+	if !jailed {
+		return false
+	}
+	return !release.After(now) || j.servedQuietly(key, now)
+}
+
+// servedQuietly reports whether a sentenced key has sent no requests for QuietRelease, earning an early release.
+// The caller must hold j.sentenceMux.
+func (j *Jail) servedQuietly(key string, now time.Time) bool {
+	if j.QuietRelease <= 0 {
+		return false
+	}
+	last, ok := j.lastAttempts[key]
+	return ok && now.Sub(last) >= j.QuietRelease
+}
+
+// forgiven reports whether the key has gone ForgiveAfter since its last sentence was released, earning a clean
+// record. The caller must hold j.sentenceMux.
+func (j *Jail) forgiven(key string, now time.Time) bool {
+	if j.ForgiveAfter <= 0 {
+		return false
+	}
+	released, ok := j.lastReleases[key]
+	return ok && now.Sub(released) >= j.ForgiveAfter
+}
+
+// forgive clears the key's offense record. The caller must hold j.sentenceMux.
+func (j *Jail) forgive(key string) {
+	delete(j.offenses, key)
+	delete(j.lastReleases, key)
 }
 
-// sentence address to a cooloff
-func (j Jail) sentence(req *http.Request) {
-	sentence := time.Now().Add(j.Cooloff)
-	j.Sentences[req.RemoteAddr] = sentence
+// unsentence drops the key's sentence. The caller must hold j.sentenceMux.
+func (j *Jail) unsentence(key string) {
+	delete(j.Sentences, key)
+	delete(j.lastAttempts, key)
+}
+
+// sentence key to a cooloff starting at the time now. A visitor already serving a sentence keeps its release time,
+// so the Retry-After it's sent counts down instead of restarting with every blocked request.
+func (j *Jail) sentence(key string, cooloff time.Duration, now time.Time) {
+	if j.NoSentencing {
+		return
+	}
+	release, sentenced := j.imprison(key, cooloff, now)
+	// called without the lock held, so a slow callback only holds up the request that triggered it
+	if sentenced && j.OnSentence != nil {
+		j.OnSentence(key, release)
+	}
 }
 
-const cleanupEvery = 100
+// imprison records a sentence for the key unless it's already serving one, reporting whether it was newly sentenced
+func (j *Jail) imprison(key string, cooloff time.Duration, now time.Time) (time.Time, bool) {
+	j.sentenceMux.Lock()
+	defer j.sentenceMux.Unlock()
+
+	if j.QuietRelease > 0 {
+		if j.lastAttempts == nil {
+			j.lastAttempts = make(map[string]time.Time)
+		}
+		j.lastAttempts[key] = now
+	}
 
-// DefaultVisitorLog is the default implementation of VisitorLog
+	release, jailed := j.Sentences[key]
+	if jailed && release.After(now) {
+		return release, false
+	}
+	if !jailed {
+		j.makeRoomForSentence()
+	}
+
+	if j.EscalateCooloff {
+		if j.offenses == nil {
+			j.offenses = make(map[string]int)
+		}
+		if j.forgiven(key, now) {
+			j.forgive(key)
+		}
+		j.offenses[key]++
+		cooloff = escalate(cooloff, j.offenses[key])
+		if j.MaxCooloff > 0 && cooloff > j.MaxCooloff {
+			cooloff = j.MaxCooloff
+		}
+	}
+
+	release = now.Add(cooloff)
+	if j.EscalateCooloff && j.ForgiveAfter > 0 {
+		if j.lastReleases == nil {
+			j.lastReleases = make(map[string]time.Time)
+		}
+		j.lastReleases[key] = release
+	}
+	j.setSentence(key, release)
+	return release, true
+}
+
+// maxEscalations caps how many times a cooloff is doubled
+const maxEscalations = 20
+
+// escalate doubles the cooloff for each offense after the first
+func escalate(cooloff time.Duration, offenses int) time.Duration {
+	for i := 1; i < offenses && i <= maxEscalations; i++ {
+		cooloff *= 2
+	}
+	return cooloff
+}
+
+// DefaultVisitorLog is the default implementation of VisitorLog. Visitors are spread over shards, each with its own
+// lock, so requests from unrelated visitors don't contend.
 type DefaultVisitorLog struct {
+	shards [visitorShards]visitorShard
+	// visitors tracked across all shards, accessed atomically
+	tracked int64
+	// serializes evictions, which compare every shard
+	evictMux sync.Mutex
+	// source of the current time, defaults to the system clock
+	Clock Clock
+	// maximum number of visitors to track, the least recently active are evicted beyond it (0 is unlimited).
+	// Concurrent requests from new visitors can briefly overshoot the cap.
+	MaxVisitors int
+	// receives eviction counts
+	Metrics Metrics
+	// whether a visit exactly at the start of the window counts, defaults to ClosedInterval
+	Interval Interval
+	// age beyond which visits are dropped and never counted, however long the window (0 keeps visits for as long
+	// as the window asks). Caps how much history a very long window can accumulate.
+	MaxVisitAge time.Duration
+}
+
+// visitorShards is the number of independently locked shards in a DefaultVisitorLog
+const visitorShards = 32
+
+// visitorShard holds the visits of the visitors whose keys hash to it
+type visitorShard struct {
+	mux    sync.Mutex
 	visits map[string][]time.Time
+	// tracked keys, most recently logged first, so the least recently active is found without a scan
+	recency  *list.List
+	elements map[string]*list.Element
+}
+
+// touch marks the key as the shard's most recently active, the caller must hold the shard's lock
+func (s *visitorShard) touch(key string) {
+	if element, ok := s.elements[key]; ok {
+		s.recency.MoveToFront(element)
+		return
+	}
+	if s.recency == nil {
+		s.recency = list.New()
+		s.elements = make(map[string]*list.Element)
+	}
+	s.elements[key] = s.recency.PushFront(key)
+}
+
+// untouch drops the key from the shard's recency order, the caller must hold the shard's lock
+func (s *visitorShard) untouch(key string) {
+	if element, ok := s.elements[key]; ok {
+		s.recency.Remove(element)
+		delete(s.elements, key)
+	}
 }
 
-var logVisitMux = sync.Mutex{}
+// leastRecent returns the shard's least recently active key and its latest visit, the caller must hold the shard's
+// lock
+func (s *visitorShard) leastRecent() (string, time.Time, bool) {
+	if s.recency == nil || s.recency.Len() == 0 {
+		return "", time.Time{}, false
+	}
+	key := s.recency.Back().Value.(string)
+	var last time.Time
+	if visits := s.visits[key]; len(visits) > 0 {
+		last = visits[len(visits)-1]
+	}
+	return key, last, true
+}
+
+// Interval chooses how a visitor log treats a visit exactly at the start of the window, `since`
+type Interval int
+
+const (
+	// ClosedInterval counts visits in [since, now], including a visit exactly one window ago
+	ClosedInterval Interval = iota
+	// HalfOpenInterval counts visits in (since, now], the usual convention, so a visit drops out of the count
+	// exactly one window after it was made
+	HalfOpenInterval
+)
+
+// contains reports whether a visit at t falls in the interval starting at since
+func (i Interval) contains(since, t time.Time) bool {
+	if i == HalfOpenInterval {
+		return t.After(since)
+	}
+	return !t.Before(since)
+}
 
 // NewDefaultVisitorLog instantiates a DefaultVisitorLog
 func NewDefaultVisitorLog() *DefaultVisitorLog {
 	return &DefaultVisitorLog{
-		visits: make(map[string][]time.Time),
+		Clock: realClock{},
 	}
 }
 
-// LogVisit logs an IP address request
-func (l *DefaultVisitorLog) LogVisit(req *http.Request) {
-	logVisitMux.Lock()
-	l.visits[req.RemoteAddr] = append(l.visits[req.RemoteAddr], time.Now())
-	logVisitMux.Unlock()
+// shard returns the shard holding the key's visits, by its FNV-1a hash
+func (l *DefaultVisitorLog) shard(key string) *visitorShard {
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= 16777619
+	}
+	return &l.shards[hash%visitorShards]
 }
 
-// CountVisits counts the visitor's visit
-func (l *DefaultVisitorLog) CountVisits(req *http.Request, since time.Time) int {
-	var visits []time.Time
-	for _, visit := range l.visits[req.RemoteAddr] {
-		if visit.After(since) || visit.Equal(since) {
-			visits = append(visits, visit)
+// LogVisit logs a visitor request
+func (l *DefaultVisitorLog) LogVisit(key string) {
+	shard := l.shard(key)
+	l.makeRoom(shard, key)
+
+	shard.mux.Lock()
+	l.logVisit(shard, key)
+	shard.mux.Unlock()
+}
+
+// makeRoom evicts a visitor if the key is new and the log is at MaxVisitors. It must be called without holding any
+// shard's lock.
+func (l *DefaultVisitorLog) makeRoom(shard *visitorShard, key string) {
+	if l.MaxVisitors <= 0 || atomic.LoadInt64(&l.tracked) < int64(l.MaxVisitors) {
+		return
+	}
+
+	shard.mux.Lock()
+	_, tracked := shard.visits[key]
+	shard.mux.Unlock()
+	if !tracked {
+		l.evictOldest()
+	}
+}
+
+// logVisit implements LogVisit, the caller must hold the shard's lock
+func (l *DefaultVisitorLog) logVisit(shard *visitorShard, key string) {
+	if shard.visits == nil {
+		shard.visits = make(map[string][]time.Time)
+	}
+
+	now := nowFrom(l.Clock)
+	visits, tracked := shard.visits[key]
+	if !tracked {
+		atomic.AddInt64(&l.tracked, 1)
+	}
+	if l.MaxVisitAge > 0 {
+		// visits are in time order, so the expired ones are a prefix
+		expired := 0
+		for expired < len(visits) && visits[expired].Before(now.Add(-l.MaxVisitAge)) {
+			expired++
 		}
+		visits = visits[expired:]
 	}
+	shard.visits[key] = append(visits, now)
+	shard.touch(key)
+}
 
-	// remove old visits
-	l.visits[req.RemoteAddr] = visits
+// forget drops the key's visits, the caller must hold the shard's lock
+func (l *DefaultVisitorLog) forget(shard *visitorShard, key string) {
+	if _, tracked := shard.visits[key]; tracked {
+		delete(shard.visits, key)
+		shard.untouch(key)
+		atomic.AddInt64(&l.tracked, -1)
+	}
+}
+
+// evictOldest drops the visitor whose latest visit is the oldest. Evicted visitors start over with a clean
+// count, so the cap fails open rather than letting the log grow without bound. Each shard keeps its visitors in
+// recency order, so finding the oldest only compares the shards' least recently active visitors.
+func (l *DefaultVisitorLog) evictOldest() {
+	l.evictMux.Lock()
+	defer l.evictMux.Unlock()
+	if atomic.LoadInt64(&l.tracked) < int64(l.MaxVisitors) {
+		// another eviction already made room
+		return
+	}
+
+	var oldestShard *visitorShard
+	var oldest time.Time
+	for i := range l.shards {
+		shard := &l.shards[i]
+		shard.mux.Lock()
+		_, last, ok := shard.leastRecent()
+		shard.mux.Unlock()
+		if ok && (oldestShard == nil || last.Before(oldest)) {
+			oldestShard, oldest = shard, last
+		}
+	}
+
+	if oldestShard != nil {
+		oldestShard.mux.Lock()
+		// the shard may have changed since it was compared, but its least recently active visitor is still the one
+		// to go
+		if key, _, ok := oldestShard.leastRecent(); ok {
+			l.forget(oldestShard, key)
+		}
+		oldestShard.mux.Unlock()
+		incMetric(l.Metrics, MetricVisitorsEvicted)
+	}
+}
+
+// CountVisits counts the visitor's visit
+func (l *DefaultVisitorLog) CountVisits(key string, since time.Time) int {
+	shard := l.shard(key)
+	shard.mux.Lock()
+	defer shard.mux.Unlock()
+	return l.countVisits(shard, key, since)
+}
+
+// IncrementAndCount logs a visitor request and counts the visitor's visits in one critical section
+func (l *DefaultVisitorLog) IncrementAndCount(key string, since time.Time) int {
+	shard := l.shard(key)
+	l.makeRoom(shard, key)
+
+	shard.mux.Lock()
+	defer shard.mux.Unlock()
+	l.logVisit(shard, key)
+	return l.countVisits(shard, key, since)
+}
+
+// countVisits implements CountVisits, the caller must hold the shard's lock
+func (l *DefaultVisitorLog) countVisits(shard *visitorShard, key string, since time.Time) int {
+	visits, tracked := shard.visits[key]
+	if !tracked {
+		return 0
+	}
+	first := l.firstSince(visits, since)
+
+	// remove old visits, releasing the backing array once none are left
+	if first == len(visits) {
+		visits = nil
+	} else {
+		visits = visits[first:]
+	}
+	shard.visits[key] = visits
 	return len(visits)
 }
 
-// NewJail constructs a new Jail
-func NewJail(visitorLog VisitorLog, window, cooloff time.Duration, allowedRequests int) *Jail {
-	return &Jail{
-		AllowedRequests: allowedRequests,
-		Window:          window,
-		Cooloff:         cooloff,
-		visitors:        visitorLog,
+// peekVisits counts the visitor's visits like countVisits without removing old ones, the caller must hold the
+// shard's lock
+func (l *DefaultVisitorLog) peekVisits(shard *visitorShard, key string, since time.Time) int {
+	visits := shard.visits[key]
+	return len(visits) - l.firstSince(visits, since)
+}
+
+// firstSince returns the index of the first visit counted since the provided time
+func (l *DefaultVisitorLog) firstSince(visits []time.Time, since time.Time) int {
+	if l.MaxVisitAge > 0 {
+		if oldest := nowFrom(l.Clock).Add(-l.MaxVisitAge); since.Before(oldest) {
+			since = oldest
+		}
+	}
+
+	// visits are appended in time order, so the ones in the window are a suffix
+	return sort.Search(len(visits), func(i int) bool {
+		return l.Interval.contains(since, visits[i])
+	})
+}
+
+// UnlogVisit removes the visitor's most recent visit
+func (l *DefaultVisitorLog) UnlogVisit(key string) {
+	shard := l.shard(key)
+	shard.mux.Lock()
+	defer shard.mux.Unlock()
+	if visits := shard.visits[key]; len(visits) > 0 {
+		shard.visits[key] = visits[:len(visits)-1]
+	}
+}
+
+// Reset drops all of a visitor's visits
+func (l *DefaultVisitorLog) Reset(key string) {
+	shard := l.shard(key)
+	shard.mux.Lock()
+	l.forget(shard, key)
+	shard.mux.Unlock()
+}
+
+// visitors returns the number of visitors tracked
+func (l *DefaultVisitorLog) visitors() int {
+	return int(atomic.LoadInt64(&l.tracked))
+}
+
+// stored returns a copy of the key's stored visits and whether the key is tracked
+func (l *DefaultVisitorLog) stored(key string) ([]time.Time, bool) {
+	shard := l.shard(key)
+	shard.mux.Lock()
+	defer shard.mux.Unlock()
+	visits, tracked := shard.visits[key]
+	return append([]time.Time(nil), visits...), tracked
+}
+
+// default limit for jails built with New
+const (
+	defaultWindow          = time.Minute
+	defaultAllowedRequests = 60
+)
+
+// New constructs a Jail configured by options. Without any it allows 60 requests a minute per visitor with no
+// cooloff, counting visits in a DefaultVisitorLog.
+func New(opts ...Option) *Jail {
+	jail := &Jail{
+		AllowedRequests: defaultAllowedRequests,
+		Window:          defaultWindow,
+		visitors:        NewDefaultVisitorLog(),
 		Sentences:       make(map[string]time.Time),
 	}
+	jail.apply(opts)
+	return jail
+}
+
+// NewJail constructs a new Jail
+func NewJail(visitorLog VisitorLog, window, cooloff time.Duration, allowedRequests int, opts ...Option) *Jail {
+	return New(append([]Option{
+		WithVisitorLog(visitorLog),
+		WithWindow(window),
+		WithCooloff(cooloff),
+		WithAllowedRequests(allowedRequests),
+	}, opts...)...)
 }
 
 // NewBasicJail creates a new jail with a second-duration window and a default visitor log
-func NewBasicJail(windowSeconds int64, allowedRequests int, noRespond bool) *Jail {
+func NewBasicJail(windowSeconds int64, allowedRequests int, noRespond bool, opts ...Option) *Jail {
+	return New(append([]Option{
+		WithWindow(time.Duration(windowSeconds) * time.Second),
+		WithAllowedRequests(allowedRequests),
+		WithNoRespond(noRespond),
+	}, opts...)...)
+}
+
+// NewJailForTesting creates a jail whose visitor log and sentences are driven entirely by clock.
+// It never starts background goroutines, so tests using it are hermetic and leak-free.
+func NewJailForTesting(clock Clock, window, cooloff time.Duration, allowedRequests int, opts ...Option) *Jail {
 	log := NewDefaultVisitorLog()
-	window, _ := time.ParseDuration(fmt.Sprintf("%ds", windowSeconds))
-	return &Jail{
-		AllowedRequests: allowedRequests,
-		visitors:        log,
-		Window:          window,
-		NoRespond:       noRespond,
-		Sentences:       make(map[string]time.Time),
-	}
+	log.Clock = clock
+	jail := NewJail(log, window, cooloff, allowedRequests)
+	jail.Clock = clock
+	jail.apply(opts)
+	return jail
 }
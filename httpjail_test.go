@@ -5,12 +5,28 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"runtime"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 )
 
+// every visitor log satisfies the string-keyed interface
+var (
+	_ VisitorLog = (*DefaultVisitorLog)(nil)
+	_ VisitorLog = (*FixedWindowLog)(nil)
+	_ VisitorLog = (*GossipVisitorLog)(nil)
+	_ VisitorLog = (*SlidingWindowLog)(nil)
+	_ VisitorLog = (*TokenBucketLog)(nil)
+
+	_ ResettingVisitorLog = (*FixedWindowLog)(nil)
+	_ ResettingVisitorLog = (*TokenBucketLog)(nil)
+)
+
 const testPort = ":8081"
 const successRes = "SUCCESS"
 
@@ -27,8 +43,14 @@ func makeTestServer(jail *Jail) func() {
 		Addr:    testPort,
 	}
 
+	// listen before returning so requests can't race the server startup
+	listener, err := net.Listen("tcp", testPort)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	go func() {
-		if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		if err := srv.Serve(listener); err != http.ErrServerClosed {
 			log.Fatal(err)
 		}
 	}()
@@ -37,6 +59,8 @@ func makeTestServer(jail *Jail) func() {
 
 	return func() {
 		srv.Shutdown(ctx)
+		// drop pooled connections to the closed server so the next test dials fresh
+		http.DefaultClient.CloseIdleConnections()
 	}
 }
 
@@ -70,45 +94,123 @@ func makeRequest(fromAddr string, isProxied bool) *http.Request {
 }
 
 func TestDefaultVisitorLogCountVisits(t *testing.T) {
+	clock := NewFakeClock(time.Now())
 	visitorLog := NewDefaultVisitorLog()
+	visitorLog.Clock = clock
 
 	testAddr := "0.0.0.0"
 
-	since := time.Now()
+	since := clock.Now()
 	for i := 1; i <= 10; i++ {
-		req := makeRequest(testAddr, false)
-		visitorLog.LogVisit(req)
+		visitorLog.LogVisit(testAddr)
 
-		visitCount := visitorLog.CountVisits(req, since)
+		visitCount := visitorLog.CountVisits(testAddr, since)
 		if visitCount != i {
 			t.Logf("incorrect visit count: got %d, expected %d", visitCount, i)
 			t.Fail()
 		}
 	}
 
-	after := time.Now()
-	req := makeRequest(testAddr, false)
-	countAfter := visitorLog.CountVisits(req, after)
+	clock.Advance(time.Nanosecond)
+	after := clock.Now()
+	countAfter := visitorLog.CountVisits(testAddr, after)
 	if countAfter != 0 {
 		t.Logf("visitor log reported incorrect visitor count: got %d, expected %d", countAfter, 0)
 		t.Fail()
 	}
 }
 
+func TestDefaultVisitorLogMaxVisitors(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	metrics := newFakeMetrics()
+
+	visitorLog := NewDefaultVisitorLog()
+	visitorLog.Clock = clock
+	visitorLog.MaxVisitors = 100
+	visitorLog.Metrics = metrics
+
+	flood := 1000
+	for i := 0; i < flood; i++ {
+		visitorLog.LogVisit(fmt.Sprintf("10.0.%d.%d", i/256, i%256))
+		clock.Advance(time.Millisecond)
+	}
+
+	if visitorLog.visitors() > visitorLog.MaxVisitors {
+		t.Logf("visitor log grew past its cap: %d keys", visitorLog.visitors())
+		t.Fail()
+	}
+
+	evicted := metrics.counter(MetricVisitorsEvicted)
+	if evicted != flood-visitorLog.MaxVisitors {
+		t.Logf("incorrect eviction count: got %d, expected %d", evicted, flood-visitorLog.MaxVisitors)
+		t.Fail()
+	}
+
+	// the earliest visitors are the ones evicted
+	if _, tracked := visitorLog.stored("10.0.0.0"); tracked {
+		t.Log("oldest visitor survived eviction")
+		t.Fail()
+	}
+	if _, tracked := visitorLog.stored("10.0.3.231"); !tracked {
+		t.Log("newest visitor was evicted")
+		t.Fail()
+	}
+}
+
+func TestDefaultVisitorLogEvictsLeastRecentlyActive(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	visitorLog := NewDefaultVisitorLog()
+	visitorLog.Clock = clock
+	visitorLog.MaxVisitors = 50
+
+	for i := 0; i < visitorLog.MaxVisitors; i++ {
+		visitorLog.LogVisit(fmt.Sprintf("10.0.0.%d", i))
+		clock.Advance(time.Millisecond)
+	}
+	// the first visitor comes back, so it's now the most recently active
+	visitorLog.LogVisit("10.0.0.0")
+	clock.Advance(time.Millisecond)
+
+	for i := 0; i < 10; i++ {
+		visitorLog.LogVisit(fmt.Sprintf("10.1.0.%d", i))
+		clock.Advance(time.Millisecond)
+	}
+
+	if visitorLog.visitors() != visitorLog.MaxVisitors {
+		t.Logf("%d visitors tracked, expected the cap of %d", visitorLog.visitors(), visitorLog.MaxVisitors)
+		t.Fail()
+	}
+	if visits, tracked := visitorLog.stored("10.0.0.0"); !tracked || len(visits) != 2 {
+		t.Log("returning visitor was evicted")
+		t.Fail()
+	}
+	for i := 1; i <= 10; i++ {
+		if _, tracked := visitorLog.stored(fmt.Sprintf("10.0.0.%d", i)); tracked {
+			t.Logf("idle visitor 10.0.0.%d survived eviction", i)
+			t.Fail()
+		}
+	}
+	if _, tracked := visitorLog.stored("10.0.0.11"); !tracked {
+		t.Log("visitor evicted before the cap required it")
+		t.Fail()
+	}
+}
+
 func TestMiddleware(t *testing.T) {
-	// jail allows 1 request every 2 seconds
-	windowSeconds := int64(5)
+	// jail allows exactly 5 requests every 5 seconds
+	window := 5 * time.Second
 	allowedRequests := 5
-	jail := NewBasicJail(windowSeconds, allowedRequests, false)
+	clock := NewFakeClock(time.Now())
+	jail := NewJailForTesting(clock, window, 0, allowedRequests)
 
 	stopServer := makeTestServer(jail)
 	defer stopServer()
 
-	// up to Nth request should be allowed
-	for i := 0; i < allowedRequests; i++ {
+	// up to and including the Nth request should be allowed
+	for i := 1; i <= allowedRequests; i++ {
 		reached := requestAllowed(t)
 		if !reached {
-			t.Logf("server did not allow request %d", i)
+			t.Logf("server did not allow request %d of %d", i, allowedRequests)
 			t.Fail()
 		}
 	}
@@ -116,12 +218,13 @@ func TestMiddleware(t *testing.T) {
 	// N+1th request should be blocked
 	reached := requestAllowed(t)
 	if reached {
-		t.Log("server did not block violating request")
+		t.Logf("server did not block request %d", allowedRequests+1)
 		t.Fail()
 	}
 
-	// request should be allowed after waiting for end of window (no cooloff configured)
-	time.Sleep(time.Duration(windowSeconds) * time.Second)
+	// request should be allowed once the window has passed (no cooloff configured); a visit exactly one window old
+	// still counts
+	clock.Advance(window + time.Millisecond)
 
 	reached = requestAllowed(t)
 	if !reached {
@@ -157,29 +260,21 @@ func TestProxiedMiddleware(t *testing.T) {
 		t.Fail()
 	}
 
-	count := jail.visitors.CountVisits(req, now)
+	count := jail.visitors.CountVisits(testAddr, now)
 	if count != 1 {
 		t.Logf("%#v", jail.visitors)
 		t.Logf("%#v", req)
 		t.Logf("Incorrect visit count: expected %d, got %d", 1, count)
 		t.Fail()
 	}
-
-	log.Printf("%#v", jail.visitors)
 }
 
 func TestMiddlewareCooldown(t *testing.T) {
 	cooloff := time.Duration(5) * time.Second
 	requestWindow := time.Duration(5) * time.Second
 
-	jail := &Jail{
-		AllowedRequests: 1,
-		NoRespond:       false,
-		Cooloff:         cooloff,
-		Window:          requestWindow,
-		visitors:        NewDefaultVisitorLog(),
-		Sentences:       make(map[string]time.Time),
-	}
+	clock := NewFakeClock(time.Now())
+	jail := NewJailForTesting(clock, requestWindow, cooloff, 1)
 
 	stopServer := makeTestServer(jail)
 	defer stopServer()
@@ -199,7 +294,7 @@ func TestMiddlewareCooldown(t *testing.T) {
 	}
 
 	// request allowed after cooloff
-	time.Sleep(cooloff)
+	clock.Advance(cooloff)
 	reached = requestAllowed(t)
 	if !reached {
 		t.Log("cooloff did not expire")
@@ -207,3 +302,433 @@ func TestMiddlewareCooldown(t *testing.T) {
 	}
 
 }
+
+func TestJailForTestingIsHermetic(t *testing.T) {
+	clock := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	before := runtime.NumGoroutine()
+
+	jail := NewJailForTesting(clock, time.Minute, 0, 2)
+	handler := jail.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprint(w, successRes)
+	}))
+
+	serve := func() bool {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, makeRequest("1.2.3.4", false))
+		return rec.Body.String() == successRes
+	}
+
+	for i := 0; i < 2; i++ {
+		if !serve() {
+			t.Logf("request %d denied, should be allowed", i)
+			t.Fail()
+		}
+	}
+
+	if serve() {
+		t.Log("request allowed, should be blocked")
+		t.Fail()
+	}
+
+	// only the fake clock moves the window
+	clock.Advance(time.Minute + time.Second)
+	if !serve() {
+		t.Log("request denied after fake clock passed the window")
+		t.Fail()
+	}
+
+	after := runtime.NumGoroutine()
+	if after > before {
+		t.Logf("jail spawned goroutines: %d before, %d after", before, after)
+		t.Fail()
+	}
+}
+
+func TestDisableEnable(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	jail := NewJailForTesting(clock, time.Minute, 0, 2)
+	jail.TrackWhileDisabled = true
+
+	serve := func() bool {
+		return serveJail(jail, makeRequest("1.2.3.4", false))
+	}
+
+	jail.Disable()
+	for i := 0; i < 10; i++ {
+		if !serve() {
+			t.Logf("request %d denied while the jail was disabled", i)
+			t.Fail()
+		}
+	}
+
+	// visits made while disabled still count once the jail is enabled
+	jail.Enable()
+	if serve() {
+		t.Log("request allowed after enabling, tracked visits should exceed the limit")
+		t.Fail()
+	}
+
+	// toggling under concurrent traffic must be safe
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for n := 0; n < 50; n++ {
+				serveJail(jail, makeRequest(fmt.Sprintf("10.0.%d.%d", i, n), false))
+			}
+		}(i)
+	}
+	for i := 0; i < 50; i++ {
+		jail.Disable()
+		jail.Enable()
+	}
+	wg.Wait()
+
+	jail.Disable()
+	if !jail.Disabled() || !serve() {
+		t.Log("disabled jail did not pass requests through")
+		t.Fail()
+	}
+}
+
+func TestMaxSentences(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	jail := NewJailForTesting(clock, time.Minute, time.Hour, 0)
+	jail.MaxSentences = 10
+
+	// every request is over the zero limit, so each distinct client is sentenced
+	for i := 0; i < 100; i++ {
+		serveJail(jail, makeRequest(fmt.Sprintf("10.0.0.%d", i), false))
+		clock.Advance(time.Second)
+	}
+
+	if len(jail.Sentences) > jail.MaxSentences {
+		t.Logf("sentences grew past the cap: %d", len(jail.Sentences))
+		t.Fail()
+	}
+
+	// the sentences closest to release were evicted, the latest survive
+	if _, jailed := jail.Sentences["10.0.0.0"]; jailed {
+		t.Log("sentence closest to release survived eviction")
+		t.Fail()
+	}
+	if _, jailed := jail.Sentences["10.0.0.99"]; !jailed {
+		t.Log("latest sentence was evicted")
+		t.Fail()
+	}
+}
+
+func TestMaxSentencesEvictsSoonestRelease(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	jail := NewJailForTesting(clock, time.Minute, time.Hour, 0)
+	jail.MaxSentences = 3
+
+	jail.Ban("a", time.Hour)
+	jail.Ban("b", 10*time.Minute)
+	jail.Ban("c", 30*time.Minute)
+	// b's original release is no longer the soonest
+	jail.Ban("b", 2*time.Hour)
+	jail.Ban("d", time.Hour)
+
+	for key, kept := range map[string]bool{"a": true, "b": true, "c": false, "d": true} {
+		if _, jailed := jail.Sentences[key]; jailed != kept {
+			t.Logf("sentence %s kept: %t, expected %t", key, jailed, kept)
+			t.Fail()
+		}
+	}
+
+	// replaced sentences don't pile up in the release queue
+	for i := 0; i < 1000; i++ {
+		jail.Ban("b", time.Duration(i)*time.Second)
+	}
+	if len(jail.releases) > 2*jail.MaxSentences {
+		t.Logf("release queue grew to %d entries for %d sentences", len(jail.releases), len(jail.Sentences))
+		t.Fail()
+	}
+}
+
+func TestCleanSlateAfterCooloff(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	cooloff := 10 * time.Second
+	jail := NewJailForTesting(clock, time.Minute, cooloff, 2)
+
+	for i := 0; i < 2; i++ {
+		serveJail(jail, makeRequest("1.2.3.4", false))
+	}
+
+	// keep hammering through the cooloff
+	for i := 0; i < 5; i++ {
+		if serveJail(jail, makeRequest("1.2.3.4", false)) {
+			t.Logf("request %d during the cooloff allowed", i)
+			t.Fail()
+		}
+	}
+
+	// still inside the window, but the sentence has been served
+	clock.Advance(cooloff + time.Second)
+	for i := 0; i < 2; i++ {
+		if !serveJail(jail, makeRequest("1.2.3.4", false)) {
+			t.Logf("request %d after the cooloff denied, expected a clean slate", i)
+			t.Fail()
+		}
+	}
+	if serveJail(jail, makeRequest("1.2.3.4", false)) {
+		t.Log("request over the fresh limit allowed")
+		t.Fail()
+	}
+}
+
+func TestDefaultVisitorLogInterval(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	window := time.Minute
+
+	cases := []struct {
+		interval Interval
+		// count exactly one window after the visit
+		atBoundary int
+	}{
+		{ClosedInterval, 1},
+		{HalfOpenInterval, 0},
+	}
+
+	for _, c := range cases {
+		clock := NewFakeClock(start)
+		visitorLog := NewDefaultVisitorLog()
+		visitorLog.Clock = clock
+		visitorLog.Interval = c.interval
+
+		visitorLog.LogVisit("1.2.3.4")
+
+		// a visit made now is always counted
+		if count := visitorLog.CountVisits("1.2.3.4", start.Add(-window)); count != 1 {
+			t.Logf("interval %d: fresh visit counted %d times", c.interval, count)
+			t.Fail()
+		}
+
+		// one window later, the window starts exactly at the visit
+		clock.Advance(window)
+		if count := visitorLog.CountVisits("1.2.3.4", clock.Now().Add(-window)); count != c.atBoundary {
+			t.Logf("interval %d: visit at the window start counted %d times, expected %d", c.interval, count, c.atBoundary)
+			t.Fail()
+		}
+
+		clock.Advance(time.Nanosecond)
+		if count := visitorLog.CountVisits("1.2.3.4", clock.Now().Add(-window)); count != 0 {
+			t.Logf("interval %d: visit before the window counted %d times", c.interval, count)
+			t.Fail()
+		}
+	}
+}
+
+func TestQuietRelease(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	jail := NewJailForTesting(clock, time.Minute, time.Hour, 1)
+	jail.QuietRelease = 5 * time.Minute
+
+	serveJail(jail, makeRequest("1.2.3.4", false))
+	if serveJail(jail, makeRequest("1.2.3.4", false)) {
+		t.Log("request over the limit allowed")
+		t.Fail()
+	}
+
+	// a request during the quiet period restarts it
+	clock.Advance(4 * time.Minute)
+	if serveJail(jail, makeRequest("1.2.3.4", false)) {
+		t.Log("request during the sentence allowed")
+		t.Fail()
+	}
+	clock.Advance(4 * time.Minute)
+	if serveJail(jail, makeRequest("1.2.3.4", false)) {
+		t.Log("request released early without a full quiet period")
+		t.Fail()
+	}
+
+	// backing off for the full quiet period lifts the hour-long sentence early
+	clock.Advance(5 * time.Minute)
+	if !serveJail(jail, makeRequest("1.2.3.4", false)) {
+		t.Log("client still jailed after a quiet period")
+		t.Fail()
+	}
+	if _, jailed := jail.Sentences["1.2.3.4"]; jailed {
+		t.Log("sentence not lifted")
+		t.Fail()
+	}
+}
+
+func TestBlockedStatus(t *testing.T) {
+	for _, noRespond := range []bool{false, true} {
+		jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, 0, 1)
+		jail.NoRespond = noRespond
+		handler := jail.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			fmt.Fprint(w, successRes)
+		}))
+
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, makeRequest("1.2.3.4", false))
+		if rec.Code != http.StatusOK {
+			t.Logf("NoRespond %v: allowed request got status %d", noRespond, rec.Code)
+			t.Fail()
+		}
+
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, makeRequest("1.2.3.4", false))
+		if rec.Code != http.StatusTooManyRequests {
+			t.Logf("NoRespond %v: blocked request got status %d, expected %d", noRespond, rec.Code,
+				http.StatusTooManyRequests)
+			t.Fail()
+		}
+		if (rec.Body.Len() == 0) != noRespond {
+			t.Logf("NoRespond %v: blocked response body %q", noRespond, rec.Body.String())
+			t.Fail()
+		}
+	}
+}
+
+func TestConcurrentSentencing(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	jail := NewJailForTesting(clock, time.Minute, time.Second, 1)
+	jail.QuietRelease = time.Second
+
+	// distinct clients each get sentenced, checked and released concurrently; run with -race
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for n := 0; n < 200; n++ {
+				serveJail(jail, makeRequest(fmt.Sprintf("10.0.%d.%d", i, n%8), false))
+				if n%50 == 0 {
+					clock.Advance(time.Second)
+				}
+			}
+		}(i)
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for n := 0; n < 100; n++ {
+			jail.Status()
+			jail.Reset("10.0.0.0")
+		}
+	}()
+	wg.Wait()
+
+	if jail.Status().Sentences == 0 {
+		t.Log("no clients were sentenced")
+		t.Fail()
+	}
+}
+
+func TestLogAllowedOnly(t *testing.T) {
+	for _, allowedOnly := range []bool{false, true} {
+		clock := NewFakeClock(time.Now())
+		jail := NewJailForTesting(clock, 10*time.Second, 0, 2)
+		jail.LogAllowedOnly = allowedOnly
+
+		// a burst far over the limit, then a retry every second without ever stopping
+		for i := 0; i < 20; i++ {
+			serveJail(jail, makeRequest("1.2.3.4", false))
+		}
+		recovered := false
+		for i := 0; i < 30 && !recovered; i++ {
+			clock.Advance(time.Second)
+			recovered = serveJail(jail, makeRequest("1.2.3.4", false))
+		}
+
+		if recovered != allowedOnly {
+			t.Logf("LogAllowedOnly %v: client recovered while hammering: %v", allowedOnly, recovered)
+			t.Fail()
+		}
+	}
+
+	// the limit still holds when only allowed requests are logged
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, 0, 2)
+	jail.LogAllowedOnly = true
+	for i := 0; i < 2; i++ {
+		if !serveJail(jail, makeRequest("1.2.3.4", false)) {
+			t.Logf("request %d denied", i)
+			t.Fail()
+		}
+	}
+	if serveJail(jail, makeRequest("1.2.3.4", false)) {
+		t.Log("request over the limit allowed")
+		t.Fail()
+	}
+	if count := jail.visitors.CountVisits("1.2.3.4", time.Time{}); count != 2 {
+		t.Logf("%d visits logged, expected only the 2 allowed", count)
+		t.Fail()
+	}
+}
+
+func TestDefaultVisitorLogMaxVisitAge(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	visitorLog := NewDefaultVisitorLog()
+	visitorLog.Clock = clock
+	visitorLog.MaxVisitAge = time.Hour
+
+	for i := 0; i < 10; i++ {
+		visitorLog.LogVisit("1.2.3.4")
+		clock.Advance(10 * time.Minute)
+	}
+
+	// a week-long window still only sees the last hour
+	if count := visitorLog.CountVisits("1.2.3.4", clock.Now().Add(-7*24*time.Hour)); count != 6 {
+		t.Logf("counted %d visits in a week-long window, expected the 6 within the hour cap", count)
+		t.Fail()
+	}
+	if count := visitorLog.CountVisits("1.2.3.4", time.Time{}); count != 6 {
+		t.Logf("counted %d visits since the zero time, expected 6", count)
+		t.Fail()
+	}
+
+	// windows shorter than the cap are unaffected
+	if count := visitorLog.CountVisits("1.2.3.4", clock.Now().Add(-30*time.Minute)); count != 3 {
+		t.Logf("counted %d visits in a half hour window, expected 3", count)
+		t.Fail()
+	}
+
+	// logging alone drops expired visits, so the history can't grow past the cap between counts
+	clock.Advance(2 * time.Hour)
+	visitorLog.LogVisit("1.2.3.4")
+	if stored, _ := visitorLog.stored("1.2.3.4"); len(stored) != 1 {
+		t.Logf("%d visits stored, expected only the new one", len(stored))
+		t.Fail()
+	}
+}
+
+func TestDefaultVisitorLogShards(t *testing.T) {
+	visitorLog := NewDefaultVisitorLog()
+	other := NewDefaultVisitorLog()
+
+	// find a visitor in a different shard
+	unrelated := ""
+	for i := 0; unrelated == ""; i++ {
+		if key := fmt.Sprintf("10.0.0.%d", i); visitorLog.shard(key) != visitorLog.shard("1.2.3.4") {
+			unrelated = key
+		}
+	}
+
+	// while one visitor's shard is busy, unrelated visitors and other logs carry on
+	busy := visitorLog.shard("1.2.3.4")
+	busy.mux.Lock()
+	done := make(chan struct{})
+	go func() {
+		visitorLog.LogVisit(unrelated)
+		other.LogVisit("1.2.3.4")
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Log("visits blocked on an unrelated shard")
+		t.Fail()
+	}
+	busy.mux.Unlock()
+
+	if visitorLog.visitors() != 1 || other.visitors() != 1 {
+		t.Logf("tracked %d and %d visitors, expected 1 each", visitorLog.visitors(), other.visitors())
+		t.Fail()
+	}
+}
@@ -0,0 +1,65 @@
+package httpjail
+
+import (
+	"net/http"
+	"time"
+)
+
+// freeRetries is how many retries of one Idempotency-Key are let through without consuming budget per
+// IdempotencyWindow. Further repeats are counted like any other request, so reusing one key can't dodge the limit.
+const freeRetries = 1
+
+// idempotencyCache remembers recently seen idempotency keys per visitor
+type idempotencyCache struct {
+	seen      map[string]*idempotentRequest
+	nextSweep time.Time
+}
+
+// idempotentRequest is a visitor's first request with an Idempotency-Key and the free retries it has used
+type idempotentRequest struct {
+	first   time.Time
+	retries int
+}
+
+// isRetry reports whether the request is a free retry: it repeats an Idempotency-Key the visitor already sent within
+// the jail's IdempotencyWindow, and the key has free retries left. New keys are remembered.
+func (j *Jail) isRetry(req *http.Request, key string, now time.Time) bool {
+	if j.IdempotencyWindow <= 0 {
+		return false
+	}
+
+	idempotencyKey := req.Header.Get("Idempotency-Key")
+	if idempotencyKey == "" {
+		return false
+	}
+
+	j.mux.Lock()
+	defer j.mux.Unlock()
+
+	cache := &j.idempotency
+	if cache.seen == nil {
+		cache.seen = make(map[string]*idempotentRequest)
+	}
+
+	// drop expired keys at most once per window
+	if now.After(cache.nextSweep) {
+		for k, seen := range cache.seen {
+			if now.Sub(seen.first) >= j.IdempotencyWindow {
+				delete(cache.seen, k)
+			}
+		}
+		cache.nextSweep = now.Add(j.IdempotencyWindow)
+	}
+
+	seenKey := key + "|" + idempotencyKey
+	if seen, ok := cache.seen[seenKey]; ok && now.Sub(seen.first) < j.IdempotencyWindow {
+		if seen.retries >= freeRetries {
+			return false
+		}
+		seen.retries++
+		return true
+	}
+
+	cache.seen[seenKey] = &idempotentRequest{first: now}
+	return false
+}
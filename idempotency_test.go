@@ -0,0 +1,68 @@
+package httpjail
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestIdempotentRetriesDontConsumeBudget(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	jail := NewJailForTesting(clock, time.Minute, 0, 2)
+	jail.IdempotencyWindow = 10 * time.Second
+
+	request := func(idempotencyKey string) *http.Request {
+		req := makeRequest("1.2.3.4", false)
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+		return req
+	}
+
+	for i := 0; i < 2; i++ {
+		if !serveJail(jail, request("payment-1")) {
+			t.Logf("attempt %d of idempotent request denied", i)
+			t.Fail()
+		}
+	}
+
+	count := jail.visitors.CountVisits("1.2.3.4", clock.Now().Add(-time.Minute))
+	if count != 1 {
+		t.Logf("retry consumed budget: got %d visits, expected %d", count, 1)
+		t.Fail()
+	}
+
+	// a new idempotency key is a new request
+	if !serveJail(jail, request("payment-2")) {
+		t.Log("second distinct request denied")
+		t.Fail()
+	}
+	if serveJail(jail, request("payment-3")) {
+		t.Log("third distinct request allowed, should be blocked")
+		t.Fail()
+	}
+
+	// once the dedupe window passes a repeated key counts again
+	clock.Advance(10 * time.Second)
+	if serveJail(jail, request("payment-1")) {
+		t.Log("expired idempotency key was deduplicated")
+		t.Fail()
+	}
+}
+
+func TestReusedIdempotencyKeyIsLimited(t *testing.T) {
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, 0, 2)
+	jail.IdempotencyWindow = time.Minute
+
+	allowed := 0
+	for i := 0; i < 100; i++ {
+		req := makeRequest("1.2.3.4", false)
+		req.Header.Set("Idempotency-Key", "same-every-time")
+		if serveJail(jail, req) {
+			allowed++
+		}
+	}
+	// the first request, its one free retry and one more counted repeat
+	if allowed != 3 {
+		t.Logf("%d of 100 requests reusing one Idempotency-Key allowed, expected 3", allowed)
+		t.Fail()
+	}
+}
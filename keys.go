@@ -0,0 +1,197 @@
+package httpjail
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// KeyFunc derives a visitor key from a request. Returning an empty key makes the jail fall back to the client IP.
+type KeyFunc func(req *http.Request) string
+
+// KeyFuncChain combines KeyFuncs, using the first non-empty key they return. A chain where every KeyFunc
+// comes up empty returns an empty key, so the jail falls back to the client IP.
+func KeyFuncChain(funcs ...KeyFunc) KeyFunc {
+	return func(req *http.Request) string {
+		for _, keyFunc := range funcs {
+			if keyFunc == nil {
+				continue
+			}
+			if key := keyFunc(req); key != "" {
+				return key
+			}
+		}
+		return ""
+	}
+}
+
+// KeyByIP keys visitors by the client IP, without the port, so one client's connections from different source ports
+// share a budget. In proxy mode the jail resolves the client IP before keying.
+func KeyByIP(req *http.Request) string {
+	return stripPort(req.RemoteAddr)
+}
+
+// stripPort returns the host of an address such as "1.2.3.4:5678" or "[2001:db8::1]:443". Addresses without a port
+// are returned as they are, less the brackets of a bare IPv6 literal.
+func stripPort(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	if strings.HasPrefix(addr, "[") && strings.HasSuffix(addr, "]") {
+		return addr[1 : len(addr)-1]
+	}
+	return addr
+}
+
+// KeyByIPPrefix keys visitors by the network their client IP falls in: its first ipv4Bits bits for IPv4 and ipv6Bits
+// for IPv6. A client on IPv6 is usually handed a whole /64 and can send each request from a fresh address in it, so
+// KeyByIPPrefix(32, 64) limits each /64 as one visitor while leaving IPv4 clients keyed by address. IPv4-mapped IPv6
+// addresses are keyed as IPv4.
+func KeyByIPPrefix(ipv4Bits, ipv6Bits int) KeyFunc {
+	return func(req *http.Request) string {
+		ip, err := netip.ParseAddr(stripPort(req.RemoteAddr))
+		if err != nil {
+			return ""
+		}
+
+		ip = ip.Unmap().WithZone("")
+		bits := ipv6Bits
+		if ip.Is4() {
+			bits = ipv4Bits
+		}
+		if bits >= ip.BitLen() {
+			return ip.String()
+		}
+		prefix, err := ip.Prefix(bits)
+		if err != nil {
+			return ""
+		}
+		return prefix.String()
+	}
+}
+
+// KeyByHeader keys visitors by the value of a request header, such as an API key
+func KeyByHeader(name string) KeyFunc {
+	return func(req *http.Request) string {
+		return req.Header.Get(name)
+	}
+}
+
+// KeyByOAuthClientID keys visitors by their OAuth client_id, read from the query or a form-encoded body, then from
+// HTTP basic auth credentials, then by introspecting a bearer token with the optional introspect func. Parsing a
+// form-encoded body consumes it, but the values stay available to handlers via req.Form.
+func KeyByOAuthClientID(introspect func(token string) string) KeyFunc {
+	return func(req *http.Request) string {
+		if err := req.ParseForm(); err == nil {
+			if clientID := req.Form.Get("client_id"); clientID != "" {
+				return clientID
+			}
+		}
+
+		if clientID, _, ok := req.BasicAuth(); ok && clientID != "" {
+			return clientID
+		}
+
+		if introspect != nil {
+			auth := req.Header.Get("Authorization")
+			if len(auth) > len("Bearer ") && strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+				return introspect(auth[len("Bearer "):])
+			}
+		}
+		return ""
+	}
+}
+
+// KeyByCookie keys visitors by the value of the named cookie, such as a session ID. Requests without the cookie
+// get an empty key and fall back to the IP.
+func KeyByCookie(name string) KeyFunc {
+	return func(req *http.Request) string {
+		cookie, err := req.Cookie(name)
+		if err != nil || cookie.Value == "" {
+			return ""
+		}
+		return "cookie:" + cookie.Value
+	}
+}
+
+// KeyByFingerprint keys visitors by a fingerprint combining several weak signals, for bot defense where no single
+// strong key exists. Each component contributes one signal; with no components the fingerprint combines the client
+// IP, User-Agent and Accept-Language. Requests where every component is empty fall back to the IP.
+//
+// Fingerprints are hashed so raw signals aren't held in the visitor log, but a fingerprint is still a stable
+// identifier derived from personal data: treat stored keys as such, and don't export them where the raw signals
+// wouldn't be allowed. Components a client controls (like User-Agent) can be rotated to dodge the limit, so combine
+// them with something harder to change, such as the IP.
+func KeyByFingerprint(components ...KeyFunc) KeyFunc {
+	if len(components) == 0 {
+		components = []KeyFunc{KeyByIP, KeyByHeader("User-Agent"), KeyByHeader("Accept-Language")}
+	}
+
+	return func(req *http.Request) string {
+		hash := sha256.New()
+		empty := true
+		for _, component := range components {
+			signal := component(req)
+			if signal != "" {
+				empty = false
+			}
+			// length-prefix each signal so shifting text between components changes the fingerprint
+			fmt.Fprintf(hash, "%d:%s;", len(signal), signal)
+		}
+		if empty {
+			return ""
+		}
+		return "fingerprint:" + hex.EncodeToString(hash.Sum(nil)[:16])
+	}
+}
+
+// UserKeyMode chooses how KeyByUser budgets a user's requests across IPs
+type UserKeyMode int
+
+const (
+	// PerUserPerIP gives a user a separate budget on each IP, limiting an account shared from one address
+	PerUserPerIP UserKeyMode = iota
+	// PerUser gives a user one budget shared across every IP
+	PerUser
+)
+
+// KeyByUser keys visitors by the user ID returned by user, combined with the client IP according to mode.
+// Anonymous requests (an empty user ID) fall back to the IP.
+func KeyByUser(user KeyFunc, mode UserKeyMode) KeyFunc {
+	return func(req *http.Request) string {
+		userID := user(req)
+		if userID == "" {
+			return ""
+		}
+		if mode == PerUser {
+			return "user:" + userID
+		}
+		return "user:" + userID + "@" + KeyByIP(req)
+	}
+}
+
+// KeyByHost keys visitors by the requested host, so each virtual host behind a shared proxy gets its own budget.
+// The X-Forwarded-Host header is preferred over Host, so only use it behind a proxy that sets (or strips) that header.
+func KeyByHost(req *http.Request) string {
+	return requestHost(req, true)
+}
+
+// requestHost returns the lowercased host a request was sent to, without a port
+func requestHost(req *http.Request, forwarded bool) string {
+	host := req.Host
+	if forwarded {
+		if fwd := req.Header.Get("X-Forwarded-Host"); fwd != "" {
+			// proxies may append to the header, the first entry is the host the client requested
+			host = strings.TrimSpace(strings.Split(fwd, ",")[0])
+		}
+	}
+
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
@@ -0,0 +1,422 @@
+package httpjail
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveJail sends req through the jail's middleware and reports whether it reached the handler
+func serveJail(jail *Jail, req *http.Request) bool {
+	reached := false
+	handler := jail.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		reached = true
+	}))
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	return reached
+}
+
+func TestKeyByHost(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	jail := NewJailForTesting(clock, time.Minute, 0, 1)
+	jail.KeyFunc = KeyByHost
+
+	tenantA := makeRequest("1.1.1.1", false)
+	tenantA.Host = "a.example.com"
+	tenantB := makeRequest("2.2.2.2", false)
+	tenantB.Header.Set("X-Forwarded-Host", "B.example.com:8080, proxy.internal")
+
+	if !serveJail(jail, tenantA) {
+		t.Log("first request for host a denied")
+		t.Fail()
+	}
+	if !serveJail(jail, tenantB) {
+		t.Log("first request for host b denied, hosts should have separate budgets")
+		t.Fail()
+	}
+	if serveJail(jail, tenantA) {
+		t.Log("second request for host a allowed, should be blocked")
+		t.Fail()
+	}
+
+	if key := KeyByHost(tenantB); key != "b.example.com" {
+		t.Logf("incorrect forwarded host key: got %q", key)
+		t.Fail()
+	}
+}
+
+func TestHostLimits(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	jail := NewJailForTesting(clock, time.Minute, 0, 3)
+	jail.HostLimits = map[string]Limit{
+		"strict.example.com": {AllowedRequests: 1, Window: time.Minute},
+	}
+
+	strict := func() *http.Request {
+		req := makeRequest("1.1.1.1", false)
+		req.Host = "strict.example.com"
+		return req
+	}
+	loose := func() *http.Request {
+		req := makeRequest("1.1.1.1", false)
+		req.Host = "loose.example.com"
+		return req
+	}
+
+	if !serveJail(jail, strict()) {
+		t.Log("first strict host request denied")
+		t.Fail()
+	}
+	if serveJail(jail, strict()) {
+		t.Log("second strict host request allowed, should be blocked")
+		t.Fail()
+	}
+
+	// the same visitor still has the full default budget on other hosts
+	for i := 0; i < 3; i++ {
+		if !serveJail(jail, loose()) {
+			t.Logf("default host request %d denied", i)
+			t.Fail()
+		}
+	}
+}
+
+func TestKeyFunc(t *testing.T) {
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, time.Hour, 2)
+	jail.KeyFunc = func(req *http.Request) string {
+		return req.Header.Get("Authorization")
+	}
+
+	fromIP := func(ip string) *http.Request {
+		req := makeRequest(ip, false)
+		req.Header.Set("Authorization", "Bearer shared")
+		return req
+	}
+
+	// one credential used from two IPs is one visitor
+	if !serveJail(jail, fromIP("1.1.1.1")) || !serveJail(jail, fromIP("2.2.2.2")) {
+		t.Log("requests within the shared key's limit denied")
+		t.Fail()
+	}
+	if serveJail(jail, fromIP("3.3.3.3")) {
+		t.Log("third request with the shared key allowed from a new IP")
+		t.Fail()
+	}
+
+	if count := jail.visitors.CountVisits("Bearer shared", time.Time{}); count != 3 {
+		t.Logf("shared key counted %d visits, expected 3", count)
+		t.Fail()
+	}
+	if _, jailed := jail.Sentences["Bearer shared"]; !jailed || len(jail.Sentences) != 1 {
+		t.Logf("sentences not keyed by the KeyFunc: %v", jail.Sentences)
+		t.Fail()
+	}
+
+	// without a KeyFunc visitors are keyed by IP
+	jail = NewJailForTesting(NewFakeClock(time.Now()), time.Minute, 0, 1)
+	if !serveJail(jail, fromIP("1.1.1.1")) || !serveJail(jail, fromIP("2.2.2.2")) {
+		t.Log("IP-keyed jail counted different IPs together")
+		t.Fail()
+	}
+}
+
+func TestKeyByIPStripsPort(t *testing.T) {
+	cases := map[string]string{
+		"203.0.113.7:5000":    "203.0.113.7",
+		"203.0.113.7":         "203.0.113.7",
+		"[2001:db8::1]:443":   "2001:db8::1",
+		"[2001:db8::1]":       "2001:db8::1",
+		"2001:db8::1":         "2001:db8::1",
+		"[fe80::1%eth0]:8080": "fe80::1%eth0",
+		"":                    "",
+	}
+	for addr, expected := range cases {
+		if key := KeyByIP(makeRequest(addr, false)); key != expected {
+			t.Logf("%q keyed as %q, expected %q", addr, key, expected)
+			t.Fail()
+		}
+	}
+
+	// one client's connections from different source ports share a budget
+	for _, addrs := range [][]string{
+		{"203.0.113.7:50001", "203.0.113.7:50002", "203.0.113.7:50003"},
+		{"[2001:db8::1]:50001", "[2001:db8::1]:50002", "2001:db8::1"},
+	} {
+		jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, 0, 2)
+		for i, addr := range addrs {
+			if allowed := serveJail(jail, makeRequest(addr, false)); allowed != (i < 2) {
+				t.Logf("request %d from %s: allowed %v", i+1, addr, allowed)
+				t.Fail()
+			}
+		}
+	}
+}
+
+func TestKeyByIPPrefix(t *testing.T) {
+	keyFunc := KeyByIPPrefix(32, 64)
+	cases := map[string]string{
+		"[2001:db8:1:2::1]:443":         "2001:db8:1:2::/64",
+		"2001:db8:1:2:ffff:ffff:ffff:1": "2001:db8:1:2::/64",
+		"[fe80::1%eth0]:80":             "fe80::/64",
+		"203.0.113.7:5000":              "203.0.113.7",
+		"[::ffff:203.0.113.7]:5000":     "203.0.113.7",
+		"not an ip":                     "",
+	}
+	for addr, expected := range cases {
+		if key := keyFunc(makeRequest(addr, false)); key != expected {
+			t.Logf("%s keyed as %q, expected %q", addr, key, expected)
+			t.Fail()
+		}
+	}
+	if key := KeyByIPPrefix(24, 48)(makeRequest("203.0.113.7", false)); key != "203.0.113.0/24" {
+		t.Logf("IPv4 /24 keyed as %q", key)
+		t.Fail()
+	}
+
+	// addresses in one /64 share a budget, the next /64 has its own
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, 0, 3)
+	jail.KeyFunc = keyFunc
+	for i, addr := range []string{"[2001:db8:1:2::1]:1", "[2001:db8:1:2::2]:1", "[2001:db8:1:2:abcd::3]:1"} {
+		if !serveJail(jail, makeRequest(addr, false)) {
+			t.Logf("request %d from the /64 denied", i+1)
+			t.Fail()
+		}
+	}
+	if serveJail(jail, makeRequest("[2001:db8:1:2::ffff]:1", false)) {
+		t.Log("fresh address in an exhausted /64 allowed")
+		t.Fail()
+	}
+	if !serveJail(jail, makeRequest("[2001:db8:1:3::1]:1", false)) {
+		t.Log("neighbouring /64 shared the exhausted budget")
+		t.Fail()
+	}
+	if count := jail.visitors.CountVisits("2001:db8:1:2::/64", time.Time{}); count != 4 {
+		t.Logf("/64 counted %d visits, expected 4", count)
+		t.Fail()
+	}
+}
+
+func TestKeyFuncChain(t *testing.T) {
+	keyFunc := KeyFuncChain(KeyByHeader("X-API-Key"), nil, KeyByIP)
+
+	withKey := makeRequest("1.2.3.4", false)
+	withKey.Header.Set("X-API-Key", "secret")
+	if key := keyFunc(withKey); key != "secret" {
+		t.Logf("incorrect key with api key present: got %q", key)
+		t.Fail()
+	}
+
+	withoutKey := makeRequest("1.2.3.4", false)
+	if key := keyFunc(withoutKey); key != "1.2.3.4" {
+		t.Logf("chain did not fall back to ip: got %q", key)
+		t.Fail()
+	}
+
+	if key := KeyFuncChain(KeyByHeader("X-API-Key"))(withoutKey); key != "" {
+		t.Logf("exhausted chain returned a key: got %q", key)
+		t.Fail()
+	}
+
+	// keyless requests share the IP budget, keyed requests get their own
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, 0, 1)
+	jail.KeyFunc = KeyFuncChain(KeyByHeader("X-API-Key"))
+
+	if !serveJail(jail, makeRequest("1.2.3.4", false)) {
+		t.Log("first anonymous request denied")
+		t.Fail()
+	}
+	if serveJail(jail, makeRequest("1.2.3.4", false)) {
+		t.Log("second anonymous request allowed, should be blocked")
+		t.Fail()
+	}
+	if !serveJail(jail, withKey) {
+		t.Log("api key request denied, should have its own budget")
+		t.Fail()
+	}
+}
+
+func TestKeyByOAuthClientID(t *testing.T) {
+	keyFunc := KeyByOAuthClientID(func(token string) string {
+		if token == "token-for-c" {
+			return "client-c"
+		}
+		return ""
+	})
+
+	form := func(clientID string) *http.Request {
+		req := httptest.NewRequest("POST", "/oauth/token", strings.NewReader("grant_type=client_credentials&client_id="+clientID))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RemoteAddr = "1.2.3.4"
+		return req
+	}
+
+	if key := keyFunc(form("client-a")); key != "client-a" {
+		t.Logf("incorrect form client_id key: got %q", key)
+		t.Fail()
+	}
+
+	basic := httptest.NewRequest("POST", "/oauth/token", nil)
+	basic.SetBasicAuth("client-b", "secret")
+	if key := keyFunc(basic); key != "client-b" {
+		t.Logf("incorrect basic auth client_id key: got %q", key)
+		t.Fail()
+	}
+
+	bearer := httptest.NewRequest("GET", "/api", nil)
+	bearer.Header.Set("Authorization", "Bearer token-for-c")
+	if key := keyFunc(bearer); key != "client-c" {
+		t.Logf("incorrect introspected client_id key: got %q", key)
+		t.Fail()
+	}
+
+	if key := keyFunc(httptest.NewRequest("GET", "/api", nil)); key != "" {
+		t.Logf("anonymous request returned a key: got %q", key)
+		t.Fail()
+	}
+
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, 0, 1)
+	jail.KeyFunc = keyFunc
+
+	if !serveJail(jail, form("client-a")) {
+		t.Log("first client-a request denied")
+		t.Fail()
+	}
+	if !serveJail(jail, form("client-b")) {
+		t.Log("client-b request from the same IP denied, should have its own budget")
+		t.Fail()
+	}
+	if serveJail(jail, form("client-a")) {
+		t.Log("second client-a request allowed, should be blocked")
+		t.Fail()
+	}
+}
+
+func TestKeyByUser(t *testing.T) {
+	request := func(ip string) *http.Request {
+		req := makeRequest(ip, false)
+		req.Header.Set("X-User-ID", "alice")
+		return req
+	}
+
+	modes := map[UserKeyMode]bool{
+		// one IP's budget is spent, the other IP has its own
+		PerUserPerIP: true,
+		// the budget is shared, so the second IP is blocked too
+		PerUser: false,
+	}
+
+	for mode, secondIPAllowed := range modes {
+		jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, 0, 2)
+		jail.KeyFunc = KeyByUser(KeyByHeader("X-User-ID"), mode)
+
+		for i := 0; i < 2; i++ {
+			if !serveJail(jail, request("1.1.1.1")) {
+				t.Logf("mode %d: request %d from the first IP denied", mode, i)
+				t.Fail()
+			}
+		}
+		if serveJail(jail, request("1.1.1.1")) {
+			t.Logf("mode %d: request over the limit from the first IP allowed", mode)
+			t.Fail()
+		}
+
+		if serveJail(jail, request("2.2.2.2")) != secondIPAllowed {
+			t.Logf("mode %d: expected second IP allowed to be %t", mode, secondIPAllowed)
+			t.Fail()
+		}
+	}
+
+	if key := KeyByUser(KeyByHeader("X-User-ID"), PerUser)(makeRequest("1.1.1.1", false)); key != "" {
+		t.Logf("anonymous request returned a user key: got %q", key)
+		t.Fail()
+	}
+}
+
+func TestKeyByCookie(t *testing.T) {
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, 0, 1)
+	jail.KeyFunc = KeyByCookie("session")
+
+	withSession := func(session string) *http.Request {
+		req := makeRequest("1.2.3.4", false)
+		req.AddCookie(&http.Cookie{Name: "session", Value: session})
+		return req
+	}
+
+	if !serveJail(jail, withSession("abc")) {
+		t.Log("first request for session abc denied")
+		t.Fail()
+	}
+	if !serveJail(jail, withSession("def")) {
+		t.Log("first request for session def denied, sessions should have separate budgets")
+		t.Fail()
+	}
+	if serveJail(jail, withSession("abc")) {
+		t.Log("second request for session abc allowed, should be blocked")
+		t.Fail()
+	}
+
+	// cookieless requests are budgeted by IP instead
+	if !serveJail(jail, makeRequest("1.2.3.4", false)) {
+		t.Log("first cookieless request denied")
+		t.Fail()
+	}
+	if serveJail(jail, makeRequest("1.2.3.4", false)) {
+		t.Log("second cookieless request allowed, should share the IP budget")
+		t.Fail()
+	}
+}
+
+func TestKeyByFingerprint(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	jail := NewJailForTesting(clock, time.Minute, 0, 1)
+	jail.KeyFunc = KeyByFingerprint()
+
+	request := func(addr, userAgent, language string) *http.Request {
+		req := makeRequest(addr, false)
+		req.Header.Set("User-Agent", userAgent)
+		req.Header.Set("Accept-Language", language)
+		return req
+	}
+
+	if !serveJail(jail, request("1.1.1.1", "bot/1.0", "en-US")) {
+		t.Log("first request denied")
+		t.Fail()
+	}
+	if serveJail(jail, request("1.1.1.1", "bot/1.0", "en-US")) {
+		t.Log("request with an identical fingerprint allowed, should share a bucket")
+		t.Fail()
+	}
+
+	differing := []*http.Request{
+		request("2.2.2.2", "bot/1.0", "en-US"),
+		request("1.1.1.1", "bot/2.0", "en-US"),
+		request("1.1.1.1", "bot/1.0", "de-DE"),
+	}
+	for _, req := range differing {
+		if !serveJail(jail, req) {
+			t.Logf("request with a differing fingerprint denied: %s %q %q", req.RemoteAddr,
+				req.Header.Get("User-Agent"), req.Header.Get("Accept-Language"))
+			t.Fail()
+		}
+	}
+
+	// raw signals never end up in the key
+	key := KeyByFingerprint()(request("1.1.1.1", "bot/1.0", "en-US"))
+	if !strings.HasPrefix(key, "fingerprint:") || strings.Contains(key, "bot/1.0") {
+		t.Logf("unexpected fingerprint key %q", key)
+		t.Fail()
+	}
+
+	// configurable components, and no signals at all falls back to the IP
+	byAgent := KeyByFingerprint(KeyByHeader("User-Agent"))
+	if byAgent(request("1.1.1.1", "bot/1.0", "en-US")) != byAgent(request("2.2.2.2", "bot/1.0", "de-DE")) {
+		t.Log("fingerprint used signals outside its components")
+		t.Fail()
+	}
+	if key := byAgent(makeRequest("1.1.1.1", false)); key != "" {
+		t.Logf("fingerprint without signals got key %q, expected the IP fallback", key)
+		t.Fail()
+	}
+}
@@ -0,0 +1,90 @@
+package httpjail
+
+import (
+	"net/http"
+	"time"
+)
+
+// Limit is a request budget applied to each visitor
+type Limit struct {
+	// exact number of requests allowed in the window, the hard limit
+	AllowedRequests int
+	// number of requests after which allowed responses carry a warning header, 0 disables the warning
+	SoftLimit int
+	// duration to consider request count
+	Window time.Duration
+	// duration to prevent requests after limit is reached
+	Cooloff time.Duration
+	// status code for blocked responses, defaults to 429 Too Many Requests
+	BlockStatus int
+}
+
+// LimitProvider is implemented by visitor logs that store a limit per visitor, such as a plan held alongside the
+// visitor's counts. The jail uses the provided limit in place of its default whenever ok is true.
+type LimitProvider interface {
+	Limit(key string) (allowed int, window time.Duration, ok bool)
+}
+
+// rule is the limit applied to a request along with the namespace its visits are counted in
+type rule struct {
+	Limit
+	scope string
+}
+
+// bucket namespaces a visitor key so visits under different rules don't share a count
+func (r rule) bucket(key string) string {
+	if r.scope == "" {
+		return key
+	}
+	return r.scope + "|" + key
+}
+
+// ruleFor selects the limit applying to the visitor's request, falling back to the jail's default limit
+func (j *Jail) ruleFor(req *http.Request, visitor string) rule {
+	j.limitsMux.RLock()
+	defer j.limitsMux.RUnlock()
+
+	if len(j.ClassLimits) > 0 {
+		if class, ok := ClassFromContext(req.Context()); ok {
+			if limit, ok := j.ClassLimits[class]; ok {
+				return rule{Limit: limit, scope: "class:" + class}
+			}
+		}
+	}
+
+	if route, ok := j.matchRoute(req); ok {
+		return rule{Limit: route.Limit, scope: "route:" + route.Method + " " + route.pattern()}
+	}
+
+	if len(j.HostLimits) > 0 {
+		host := requestHost(req, j.isProxied)
+		if limit, ok := j.HostLimits[host]; ok {
+			return rule{Limit: limit, scope: "host:" + host}
+		}
+	}
+
+	if j.FirstPartyLimit != nil && j.isFirstParty(req) {
+		return rule{Limit: *j.FirstPartyLimit, scope: "first-party"}
+	}
+
+	if provider, ok := j.visitors.(LimitProvider); ok {
+		if allowed, window, ok := provider.Limit(visitor); ok {
+			return rule{
+				Limit: Limit{
+					AllowedRequests: allowed,
+					Window:          window,
+					Cooloff:         j.Cooloff,
+				},
+			}
+		}
+	}
+
+	return rule{
+		Limit: Limit{
+			AllowedRequests: j.AllowedRequests,
+			SoftLimit:       j.SoftLimit,
+			Window:          j.Window,
+			Cooloff:         j.Cooloff,
+		},
+	}
+}
@@ -0,0 +1,60 @@
+package httpjail
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// planVisitorLog is a visitor log storing a limit for some visitors
+type planVisitorLog struct {
+	*DefaultVisitorLog
+	plans map[string]Limit
+}
+
+func (l planVisitorLog) Limit(key string) (int, time.Duration, bool) {
+	plan, ok := l.plans[key]
+	return plan.AllowedRequests, plan.Window, ok
+}
+
+func TestLimitProvider(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	visitorLog := planVisitorLog{
+		DefaultVisitorLog: NewDefaultVisitorLog(),
+		plans: map[string]Limit{
+			"premium": {AllowedRequests: 3, Window: time.Minute},
+		},
+	}
+	visitorLog.Clock = clock
+
+	jail := NewJail(visitorLog, time.Minute, 0, 1)
+	jail.Clock = clock
+	jail.KeyFunc = KeyByHeader("X-API-Key")
+
+	request := func(apiKey string) *http.Request {
+		req := makeRequest("1.2.3.4", false)
+		req.Header.Set("X-API-Key", apiKey)
+		return req
+	}
+
+	for i := 0; i < 3; i++ {
+		if !serveJail(jail, request("premium")) {
+			t.Logf("premium request %d denied", i)
+			t.Fail()
+		}
+	}
+	if serveJail(jail, request("premium")) {
+		t.Log("premium request over its plan allowed")
+		t.Fail()
+	}
+
+	// visitors without a plan get the jail's default limit
+	if !serveJail(jail, request("free")) {
+		t.Log("first free request denied")
+		t.Fail()
+	}
+	if serveJail(jail, request("free")) {
+		t.Log("second free request allowed, should use the default limit")
+		t.Fail()
+	}
+}
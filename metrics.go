@@ -0,0 +1,40 @@
+package httpjail
+
+// Metrics receives counters from the jail and its visitor logs
+type Metrics interface {
+	// Inc increments the named counter
+	Inc(name string)
+	// Observe records a value in the named histogram or summary
+	Observe(name string, value float64)
+}
+
+const (
+	// MetricVisitorsEvicted counts visitors dropped to keep a visitor log under its cap
+	MetricVisitorsEvicted = "httpjail_visitors_evicted_total"
+	// MetricBlockedRequests counts requests the jail blocked
+	MetricBlockedRequests = "httpjail_blocked_requests_total"
+	// MetricBlockedOverage observes count/AllowedRequests when a request is blocked, showing whether blocks come
+	// from minor overages or floods
+	MetricBlockedOverage = "httpjail_blocked_overage_ratio"
+	// MetricStoreErrors counts requests the jail couldn't decide because the visitor log's store failed
+	MetricStoreErrors = "httpjail_store_errors_total"
+)
+
+// incMetric increments a counter if metrics are configured
+func incMetric(metrics Metrics, name string) {
+	if metrics != nil {
+		metrics.Inc(name)
+	}
+}
+
+// observeBlock records a blocked decision
+func observeBlock(metrics Metrics, decision Decision) {
+	if metrics == nil {
+		return
+	}
+
+	metrics.Inc(MetricBlockedRequests)
+	if decision.Limit.AllowedRequests > 0 {
+		metrics.Observe(MetricBlockedOverage, float64(decision.Count)/float64(decision.Limit.AllowedRequests))
+	}
+}
@@ -0,0 +1,73 @@
+package httpjail
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeMetrics records metrics in memory
+type fakeMetrics struct {
+	mux          sync.Mutex
+	counters     map[string]int
+	observations map[string][]float64
+}
+
+func newFakeMetrics() *fakeMetrics {
+	return &fakeMetrics{
+		counters:     make(map[string]int),
+		observations: make(map[string][]float64),
+	}
+}
+
+func (m *fakeMetrics) Inc(name string) {
+	m.mux.Lock()
+	m.counters[name]++
+	m.mux.Unlock()
+}
+
+func (m *fakeMetrics) Observe(name string, value float64) {
+	m.mux.Lock()
+	m.observations[name] = append(m.observations[name], value)
+	m.mux.Unlock()
+}
+
+func (m *fakeMetrics) observed(name string) []float64 {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return m.observations[name]
+}
+
+func (m *fakeMetrics) counter(name string) int {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return m.counters[name]
+}
+
+func TestBlockedOverageMetrics(t *testing.T) {
+	metrics := newFakeMetrics()
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, 0, 4)
+	jail.Metrics = metrics
+
+	for i := 0; i < 8; i++ {
+		serveJail(jail, makeRequest("1.2.3.4", false))
+	}
+
+	if blocked := metrics.counter(MetricBlockedRequests); blocked != 4 {
+		t.Logf("incorrect blocked count: got %d, expected %d", blocked, 4)
+		t.Fail()
+	}
+
+	expected := []float64{1.25, 1.5, 1.75, 2}
+	observed := metrics.observed(MetricBlockedOverage)
+	if len(observed) != len(expected) {
+		t.Logf("incorrect overage observations: got %v, expected %v", observed, expected)
+		t.FailNow()
+	}
+	for i := range expected {
+		if observed[i] != expected[i] {
+			t.Logf("incorrect overage observations: got %v, expected %v", observed, expected)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package httpjail
+
+import (
+	"net/http"
+	"time"
+)
+
+// KeyLimit counts every request under an additional key with its own limit, so load spread across one key (such as
+// IPs) is still caught by another (such as a session cookie)
+type KeyLimit struct {
+	// names the key, keeping its buckets apart from other keys
+	Name string
+	// derives the additional key, requests where it's empty aren't counted under this limit
+	Key KeyFunc
+	Limit
+}
+
+// bucket namespaces a value of the additional key
+func (k KeyLimit) bucket(value string) string {
+	return "key:" + k.Name + "|" + value
+}
+
+// overKeyLimit counts the request under each of the jail's KeyLimits, returning the first limit the request
+// exceeds and the bucket it exceeded it in, or the store's error
+func (j *Jail) overKeyLimit(req *http.Request, now time.Time) (KeyLimit, string, bool, error) {
+	for _, keyLimit := range j.KeyLimits {
+		value := keyLimit.Key(req)
+		if value == "" {
+			continue
+		}
+
+		bucket := keyLimit.bucket(value)
+		j.releaseExpired(bucket, now)
+		count, err := j.logAndCount(bucket, now.Add(-keyLimit.Window))
+		if err != nil {
+			return KeyLimit{}, "", false, err
+		}
+		if j.isSentenced(bucket, now) || count > keyLimit.AllowedRequests {
+			return keyLimit, bucket, true, nil
+		}
+	}
+	return KeyLimit{}, "", false, nil
+}
@@ -0,0 +1,134 @@
+package httpjail
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyLimits(t *testing.T) {
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, 0, 5)
+	jail.KeyLimits = []KeyLimit{
+		{Name: "session", Key: KeyByCookie("session"), Limit: Limit{AllowedRequests: 3, Window: time.Minute}},
+	}
+
+	request := func(ip, session string) bool {
+		req := makeRequest(ip, false)
+		if session != "" {
+			req.AddCookie(&http.Cookie{Name: "session", Value: session})
+		}
+		return serveJail(jail, req)
+	}
+
+	// one session spread across IPs, each IP well within its own limit
+	for i := 0; i < 3; i++ {
+		if !request(fmt.Sprintf("10.0.0.%d", i), "abc") {
+			t.Logf("request %d within the session limit denied", i)
+			t.Fail()
+		}
+	}
+	if request("10.0.0.3", "abc") {
+		t.Log("request over the session limit allowed from a fresh IP")
+		t.Fail()
+	}
+
+	// other sessions and cookieless requests only answer to the IP limit
+	if !request("10.0.0.3", "xyz") {
+		t.Log("request with another session denied")
+		t.Fail()
+	}
+	for i := 0; i < 5; i++ {
+		if !request("10.0.1.1", "") {
+			t.Logf("cookieless request %d denied", i)
+			t.Fail()
+		}
+	}
+	if request("10.0.1.1", "") {
+		t.Log("cookieless request over the IP limit allowed")
+		t.Fail()
+	}
+}
+
+func TestKeyLimitCooloffRetryAfter(t *testing.T) {
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, 0, 5)
+	jail.KeyLimits = []KeyLimit{{
+		Name:  "session",
+		Key:   KeyByCookie("session"),
+		Limit: Limit{AllowedRequests: 1, Window: time.Minute, Cooloff: time.Hour},
+	}}
+
+	request := func(ip string) (bool, time.Duration) {
+		req := makeRequest(ip, false)
+		req.AddCookie(&http.Cookie{Name: "session", Value: "abc"})
+		return jail.Allow(req)
+	}
+
+	request("10.0.0.1")
+	for _, ip := range []string{"10.0.0.2", "10.0.0.3"} {
+		allowed, retryAfter := request(ip)
+		if allowed || retryAfter != time.Hour {
+			t.Logf("request over the session limit from %s got allowed=%t, Retry-After %s, expected the 1h cooloff", ip,
+				allowed, retryAfter)
+			t.Fail()
+		}
+	}
+}
+
+// keyFailingVisitorLog is a FallibleVisitorLog whose store is down for KeyLimits buckets only
+type keyFailingVisitorLog struct {
+	*DefaultVisitorLog
+}
+
+func (l keyFailingVisitorLog) TryIncrementAndCount(key string, since time.Time) (int, error) {
+	if strings.HasPrefix(key, "key:") {
+		return 0, errors.New("store unavailable")
+	}
+	return l.IncrementAndCount(key, since), nil
+}
+
+func (l keyFailingVisitorLog) TryCountVisits(key string, since time.Time) (int, error) {
+	if strings.HasPrefix(key, "key:") {
+		return 0, errors.New("store unavailable")
+	}
+	return l.CountVisits(key, since), nil
+}
+
+func TestKeyLimitStoreError(t *testing.T) {
+	for _, failOpen := range []bool{false, true} {
+		jail := NewJail(keyFailingVisitorLog{NewDefaultVisitorLog()}, time.Minute, 0, 10)
+		jail.KeyLimits = []KeyLimit{
+			{Name: "session", Key: KeyByCookie("session"), Limit: Limit{AllowedRequests: 3, Window: time.Minute}},
+		}
+		jail.FailOpen = failOpen
+
+		var decision Decision
+		jail.OnDecision = func(d Decision) {
+			decision = d
+		}
+		served := 0
+		for i := 0; i < 5; i++ {
+			req := makeRequest("1.2.3.4", false)
+			req.AddCookie(&http.Cookie{Name: "session", Value: "abc"})
+			if serveJail(jail, req) {
+				served++
+			}
+			if decision.Err == nil {
+				t.Logf("FailOpen %t: request %d decided without the KeyLimit store error", failOpen, i)
+				t.Fail()
+			}
+		}
+
+		expected := 0
+		if failOpen {
+			expected = 5
+		}
+		if served != expected {
+			t.Logf("FailOpen %t: %d of 5 requests served with the KeyLimit store down, expected %d", failOpen, served,
+				expected)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package httpjail
+
+import (
+	"strconv"
+	"time"
+)
+
+// extraRule is the rule for one of the jail's ExtraLimits, counted in its own bucket
+func extraRule(limit Limit) rule {
+	return rule{Limit: limit, scope: "limit:" + strconv.Itoa(limit.AllowedRequests) + "/" + limit.Window.String()}
+}
+
+// overExtraLimits counts the visitor's request under each of the jail's ExtraLimits. Of the limits the request
+// exceeds, it returns the most restrictive, the one with the longest window, along with the count under it.
+// Retries are checked without being counted, and with LogAllowedOnly requests are counted as if logged, left for
+// logExtraLimits to log once allowed.
+func (j *Jail) overExtraLimits(key string, now time.Time, retry bool) (rule, int, bool, error) {
+	var violated rule
+	var violatedCount int
+	over := false
+	for _, limit := range j.ExtraLimits {
+		extra := extraRule(limit)
+		bucket := extra.bucket(key)
+		since := now.Add(-limit.Window)
+
+		var count int
+		var err error
+		switch {
+		case retry:
+			count, err = j.countVisits(bucket, since)
+		case j.LogAllowedOnly:
+			count, err = j.countVisits(bucket, since)
+			count++
+		default:
+			count, err = j.logAndCount(bucket, since)
+		}
+		if err != nil {
+			return rule{}, 0, false, err
+		}
+
+		if count > limit.AllowedRequests && (!over || limit.Window > violated.Window) {
+			violated, violatedCount, over = extra, count, true
+		}
+	}
+	return violated, violatedCount, over, nil
+}
+
+// logExtraLimits logs an allowed request under each of the jail's ExtraLimits
+func (j *Jail) logExtraLimits(key string) {
+	for _, limit := range j.ExtraLimits {
+		j.visitors.LogVisit(extraRule(limit).bucket(key))
+	}
+}
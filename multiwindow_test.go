@@ -0,0 +1,140 @@
+package httpjail
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExtraLimits(t *testing.T) {
+	newJail := func() (*Jail, *FakeClock, http.Handler) {
+		clock := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+		// 100 requests a minute, and no more than 10 in any second
+		jail := NewJailForTesting(clock, time.Minute, 0, 100)
+		jail.ExtraLimits = []Limit{{AllowedRequests: 10, Window: time.Second}}
+		handler := jail.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+		return jail, clock, handler
+	}
+	serve := func(handler http.Handler) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, makeRequest("1.2.3.4", false))
+		return w
+	}
+
+	// a burst well within the minute limit trips the per-second limit
+	_, clock, handler := newJail()
+	for i := 1; i <= 10; i++ {
+		if w := serve(handler); w.Code != http.StatusOK {
+			t.Logf("burst request %d got %d", i, w.Code)
+			t.Fail()
+		}
+	}
+	w := serve(handler)
+	if w.Code != http.StatusTooManyRequests {
+		t.Logf("11th request in a second got %d", w.Code)
+		t.FailNow()
+	}
+	if w.Header().Get("Retry-After") != "1" || w.Header().Get(headerLimit) != "10" {
+		t.Logf("per-second block reported limit %s, Retry-After %s", w.Header().Get(headerLimit),
+			w.Header().Get("Retry-After"))
+		t.Fail()
+	}
+	clock.Advance(2 * time.Second)
+	if w := serve(handler); w.Code != http.StatusOK {
+		t.Logf("request after the second passed got %d", w.Code)
+		t.Fail()
+	}
+
+	// a steady rate within the per-second limit trips the minute limit
+	_, clock, handler = newJail()
+	var blocked *httptest.ResponseRecorder
+	allowed := 0
+	for second := 0; second < 15 && blocked == nil; second++ {
+		for i := 0; i < 9 && blocked == nil; i++ {
+			if w := serve(handler); w.Code == http.StatusOK {
+				allowed++
+			} else {
+				blocked = w
+			}
+		}
+		// just past the second, as visits at the window's start still count
+		clock.Advance(time.Second + time.Millisecond)
+	}
+	if allowed != 100 || blocked == nil {
+		t.Logf("%d requests allowed at 9 a second, expected the minute limit of 100", allowed)
+		t.FailNow()
+	}
+	if blocked.Header().Get("Retry-After") != "60" || blocked.Header().Get(headerLimit) != "100" {
+		t.Logf("per-minute block reported limit %s, Retry-After %s", blocked.Header().Get(headerLimit),
+			blocked.Header().Get("Retry-After"))
+		t.Fail()
+	}
+
+	// over both, the longer window is the one to wait out
+	jail, _, handler := newJail()
+	jail.AllowedRequests = 5
+	for i := 0; i < 10; i++ {
+		serve(handler)
+	}
+	if w := serve(handler); w.Header().Get("Retry-After") != "60" {
+		t.Logf("request over both limits told to retry after %s, expected the minute", w.Header().Get("Retry-After"))
+		t.Fail()
+	}
+}
+
+func TestExtraLimitsLogAllowedOnly(t *testing.T) {
+	clock := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	jail := NewJailForTesting(clock, time.Minute, 0, 3)
+	jail.ExtraLimits = []Limit{{AllowedRequests: 100, Window: time.Hour}}
+	jail.LogAllowedOnly = true
+
+	for i := 0; i < 10; i++ {
+		jail.Allow(makeRequest("1.2.3.4", false))
+	}
+	bucket := extraRule(jail.ExtraLimits[0]).bucket("1.2.3.4")
+	if count := jail.visitors.CountVisits(bucket, clock.Now().Add(-time.Hour)); count != 3 {
+		t.Logf("hourly limit logged %d visits for 3 allowed requests", count)
+		t.Fail()
+	}
+}
+
+// extraFailingVisitorLog is a FallibleVisitorLog whose store is down for ExtraLimits buckets only
+type extraFailingVisitorLog struct {
+	*DefaultVisitorLog
+}
+
+func (l extraFailingVisitorLog) TryIncrementAndCount(key string, since time.Time) (int, error) {
+	if strings.HasPrefix(key, "limit:") {
+		return 0, errors.New("store unavailable")
+	}
+	return l.IncrementAndCount(key, since), nil
+}
+
+func (l extraFailingVisitorLog) TryCountVisits(key string, since time.Time) (int, error) {
+	if strings.HasPrefix(key, "limit:") {
+		return 0, errors.New("store unavailable")
+	}
+	return l.CountVisits(key, since), nil
+}
+
+func TestExtraLimitsStoreError(t *testing.T) {
+	for _, failOpen := range []bool{false, true} {
+		jail := NewJail(extraFailingVisitorLog{NewDefaultVisitorLog()}, time.Minute, 0, 10)
+		jail.ExtraLimits = []Limit{{AllowedRequests: 1, Window: time.Second}}
+		jail.FailOpen = failOpen
+
+		var decision Decision
+		jail.OnDecision = func(d Decision) {
+			decision = d
+		}
+		allowed := serveJail(jail, makeRequest("1.2.3.4", false))
+		if allowed != failOpen || decision.Err == nil {
+			t.Logf("FailOpen %t: request allowed %t with error %v when the extra limit's store failed", failOpen,
+				allowed, decision.Err)
+			t.Fail()
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package httpjail
+
+import (
+	"sort"
+	"time"
+)
+
+// Offender is a visitor and how many of its requests were blocked in the current window
+type Offender struct {
+	Key    string `json:"key"`
+	Blocks int    `json:"blocks"`
+}
+
+// offenderTracker counts blocks per visitor within one window
+type offenderTracker struct {
+	start  time.Time
+	blocks map[string]int
+}
+
+// recordOffender counts a block against the visitor, starting a new tally when the window rolls over.
+// Tracking only happens when TopOffenders is set.
+func (j *Jail) recordOffender(key string, now time.Time) {
+	if j.TopOffenders <= 0 || key == "" {
+		return
+	}
+
+	window := j.window()
+
+	j.mux.Lock()
+	defer j.mux.Unlock()
+
+	tracker := &j.offenders
+	if tracker.blocks == nil || !now.Before(tracker.start.Add(window)) {
+		tracker.start = now
+		tracker.blocks = make(map[string]int)
+	}
+	tracker.blocks[key]++
+}
+
+// Offenders returns the TopOffenders visitors with the most blocked requests in the current window, most blocked
+// first. Exporting these instead of per-visitor metrics keeps label cardinality bounded.
+func (j *Jail) Offenders() []Offender {
+	now := j.now()
+	window := j.window()
+
+	j.mux.Lock()
+	var offenders []Offender
+	if j.offenders.blocks != nil && now.Before(j.offenders.start.Add(window)) {
+		offenders = make([]Offender, 0, len(j.offenders.blocks))
+		for key, blocks := range j.offenders.blocks {
+			offenders = append(offenders, Offender{Key: key, Blocks: blocks})
+		}
+	}
+	j.mux.Unlock()
+
+	sort.Slice(offenders, func(a, b int) bool {
+		if offenders[a].Blocks != offenders[b].Blocks {
+			return offenders[a].Blocks > offenders[b].Blocks
+		}
+		return offenders[a].Key < offenders[b].Key
+	})
+
+	if len(offenders) > j.TopOffenders {
+		offenders = offenders[:j.TopOffenders]
+	}
+	return offenders
+}
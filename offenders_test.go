@@ -0,0 +1,40 @@
+package httpjail
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTopOffenders(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	jail := NewJailForTesting(clock, time.Minute, 0, 1)
+	jail.TopOffenders = 2
+
+	// visitor i sends i+1 requests, so is blocked i times
+	for i := 0; i < 5; i++ {
+		for n := 0; n <= i; n++ {
+			serveJail(jail, makeRequest(fmt.Sprintf("10.0.0.%d", i), false))
+		}
+	}
+
+	offenders := jail.Offenders()
+	expected := []Offender{{Key: "10.0.0.4", Blocks: 4}, {Key: "10.0.0.3", Blocks: 3}}
+	if len(offenders) != len(expected) {
+		t.Logf("incorrect offenders: got %v, expected %v", offenders, expected)
+		t.FailNow()
+	}
+	for i := range expected {
+		if offenders[i] != expected[i] {
+			t.Logf("incorrect offenders: got %v, expected %v", offenders, expected)
+			t.Fail()
+		}
+	}
+
+	// the tally resets with the window
+	clock.Advance(time.Minute)
+	if offenders := jail.Offenders(); len(offenders) != 0 {
+		t.Logf("offenders from the previous window reported: %v", offenders)
+		t.Fail()
+	}
+}
@@ -0,0 +1,73 @@
+package httpjail
+
+import "time"
+
+// Option configures a Jail at construction
+type Option func(j *Jail)
+
+// apply runs each option against the jail
+func (j *Jail) apply(opts []Option) {
+	for _, opt := range opts {
+		opt(j)
+	}
+}
+
+// WithoutSentencing limits purely by window: visitors are blocked only while over the limit, with no cooloff, and
+// no sentence map is kept at all
+func WithoutSentencing() Option {
+	return func(j *Jail) {
+		j.NoSentencing = true
+		j.Cooloff = 0
+		j.Sentences = nil
+	}
+}
+
+// WithVisitorLog counts visits in the provided log instead of a DefaultVisitorLog
+func WithVisitorLog(visitorLog VisitorLog) Option {
+	return func(j *Jail) {
+		j.visitors = visitorLog
+	}
+}
+
+// WithWindow sets the window requests are counted over
+func WithWindow(window time.Duration) Option {
+	return func(j *Jail) {
+		j.Window = window
+	}
+}
+
+// WithAllowedRequests sets the number of requests allowed per visitor in the window
+func WithAllowedRequests(allowedRequests int) Option {
+	return func(j *Jail) {
+		j.AllowedRequests = allowedRequests
+	}
+}
+
+// WithCooloff sets how long visitors over the limit stay blocked
+func WithCooloff(cooloff time.Duration) Option {
+	return func(j *Jail) {
+		j.Cooloff = cooloff
+	}
+}
+
+// WithProxy reads the client IP from X-Forwarded-For, for servers behind a proxy or load balancer
+func WithProxy() Option {
+	return func(j *Jail) {
+		j.IsProxied()
+	}
+}
+
+// WithProxyHeader reads the client IP from the named header set by a proxy, such as X-Real-IP. It implies WithProxy.
+func WithProxyHeader(header string) Option {
+	return func(j *Jail) {
+		j.IsProxied()
+		j.ProxyHeader = header
+	}
+}
+
+// WithNoRespond sets whether blocked requests get only a status, with no body
+func WithNoRespond(noRespond bool) Option {
+	return func(j *Jail) {
+		j.NoRespond = noRespond
+	}
+}
@@ -0,0 +1,91 @@
+package httpjail
+
+import (
+	"testing"
+	"time"
+)
+
+func TestWithoutSentencing(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	jail := NewJailForTesting(clock, 10*time.Second, time.Hour, 2, WithoutSentencing())
+	jail.EscalateCooloff = true
+
+	for i := 0; i < 2; i++ {
+		if !serveJail(jail, makeRequest("1.2.3.4", false)) {
+			t.Logf("request %d denied", i)
+			t.Fail()
+		}
+	}
+	if serveJail(jail, makeRequest("1.2.3.4", false)) {
+		t.Log("request over the limit allowed")
+		t.Fail()
+	}
+
+	if jail.Sentences != nil {
+		t.Logf("sentence map touched: %v", jail.Sentences)
+		t.Fail()
+	}
+
+	// the visitor is let back in as soon as the window clears
+	clock.Advance(11 * time.Second)
+	if !serveJail(jail, makeRequest("1.2.3.4", false)) {
+		t.Log("request after the window denied")
+		t.Fail()
+	}
+	if jail.Sentences != nil {
+		t.Logf("sentence map touched: %v", jail.Sentences)
+		t.Fail()
+	}
+}
+
+func TestNew(t *testing.T) {
+	jail := New()
+	if jail.AllowedRequests != 60 || jail.Window != time.Minute || jail.Cooloff != 0 {
+		t.Logf("incorrect defaults: %d requests per %v, %v cooloff", jail.AllowedRequests, jail.Window, jail.Cooloff)
+		t.Fail()
+	}
+	if _, ok := jail.visitors.(*DefaultVisitorLog); !ok {
+		t.Logf("default visitor log is %T", jail.visitors)
+		t.Fail()
+	}
+
+	clock := NewFakeClock(time.Now())
+	visitors := NewFixedWindowLog(time.Minute)
+	visitors.Clock = clock
+	jail = New(
+		WithVisitorLog(visitors),
+		WithWindow(time.Minute),
+		WithCooloff(time.Hour),
+		WithAllowedRequests(1),
+		WithProxyHeader("X-Real-IP"),
+		WithNoRespond(true),
+	)
+	jail.Clock = clock
+
+	if !jail.isProxied || jail.ProxyHeader != "X-Real-IP" || !jail.NoRespond {
+		t.Log("proxy and response options not applied")
+		t.Fail()
+	}
+
+	req := makeRequest("10.0.0.1:1234", false)
+	req.Header.Set("X-Real-IP", "203.0.113.9")
+	if !serveJail(jail, req) || serveJail(jail, req) {
+		t.Log("limit of 1 not enforced")
+		t.Fail()
+	}
+	if release, jailed := jail.Sentences["203.0.113.9"]; !jailed || !release.Equal(clock.Now().Add(time.Hour)) {
+		t.Logf("X-Real-IP client not sentenced for the cooloff: %v", jail.Sentences)
+		t.Fail()
+	}
+	if count := visitors.CountVisits("203.0.113.9", time.Time{}); count != 2 {
+		t.Logf("provided visitor log counted %d visits, expected 2", count)
+		t.Fail()
+	}
+
+	// the positional constructors are wrappers that still take options
+	jail = NewBasicJail(30, 5, true, WithoutSentencing())
+	if jail.Window != 30*time.Second || jail.AllowedRequests != 5 || !jail.NoRespond || jail.Sentences != nil {
+		t.Logf("NewBasicJail misconfigured: %+v", jail)
+		t.Fail()
+	}
+}
@@ -0,0 +1,35 @@
+package httpjail
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// IsFirstParty reports whether the request's Origin, or its Referer when no Origin is sent, is in the jail's
+// OriginAllowlist. Both headers are set by the client, so this separates your own frontend from casual scrapers but
+// isn't proof of identity.
+func (j *Jail) IsFirstParty(req *http.Request) bool {
+	j.limitsMux.RLock()
+	defer j.limitsMux.RUnlock()
+	return j.isFirstParty(req)
+}
+
+// isFirstParty implements IsFirstParty, the caller must hold limitsMux
+func (j *Jail) isFirstParty(req *http.Request) bool {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		referer, err := url.Parse(req.Header.Get("Referer"))
+		if err != nil || referer.Host == "" {
+			return false
+		}
+		origin = referer.Scheme + "://" + referer.Host
+	}
+
+	for _, allowed := range j.OriginAllowlist {
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,53 @@
+package httpjail
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestFirstPartyLimit(t *testing.T) {
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, 0, 1)
+	jail.OriginAllowlist = []string{"https://app.example.com"}
+	jail.FirstPartyLimit = &Limit{AllowedRequests: 3, Window: time.Minute}
+
+	firstParty := func() *http.Request {
+		req := makeRequest("1.2.3.4", false)
+		req.Header.Set("Origin", "https://app.example.com")
+		return req
+	}
+	referred := func() *http.Request {
+		req := makeRequest("5.6.7.8", false)
+		req.Header.Set("Referer", "https://APP.example.com/dashboard?tab=1")
+		return req
+	}
+	thirdParty := func() *http.Request {
+		req := makeRequest("9.9.9.9", false)
+		req.Header.Set("Origin", "https://scraper.example.net")
+		return req
+	}
+
+	for i := 0; i < 3; i++ {
+		if !serveJail(jail, firstParty()) {
+			t.Logf("first-party request %d denied", i)
+			t.Fail()
+		}
+		if !serveJail(jail, referred()) {
+			t.Logf("referred first-party request %d denied", i)
+			t.Fail()
+		}
+	}
+	if serveJail(jail, firstParty()) {
+		t.Log("first-party request over its budget allowed")
+		t.Fail()
+	}
+
+	if !serveJail(jail, thirdParty()) {
+		t.Log("first third-party request denied")
+		t.Fail()
+	}
+	if serveJail(jail, thirdParty()) {
+		t.Log("second third-party request allowed, should get the stricter default limit")
+		t.Fail()
+	}
+}
@@ -0,0 +1,148 @@
+// Package redislog stores httpjail visits in Redis, so every instance behind a load balancer shares the same counts.
+// It's a module of its own so httpjail doesn't pull in a Redis client for users that don't need one.
+package redislog
+
+import (
+	"context"
+	"math/rand"
+	"strconv"
+	"time"
+
+	"github.com/nate-anderson/httpjail"
+	"github.com/redis/go-redis/v9"
+)
+
+// defaultTTL is how long an idle visitor's sorted set lives in Redis unless VisitorLog.TTL is set
+const defaultTTL = 24 * time.Hour
+
+// VisitorLog is an httpjail.VisitorLog storing visits in Redis, one sorted set per visitor scored by visit time.
+//
+// When Redis is unreachable the jail's FailOpen decides: by default requests are rejected with OnStoreError or a
+// 503 (fail closed), with FailOpen they're let through uncounted. Calls outside the jail's decision, such as
+// CountVisits from the admin endpoints, report 0 on an error.
+type VisitorLog struct {
+	client *redis.Client
+	prefix string
+	// source of the current time, defaults to the system clock
+	Clock httpjail.Clock
+	// how long an idle visitor's visits are kept, which should be at least the jail's widest window. Defaults to
+	// 24 hours.
+	TTL time.Duration
+}
+
+// New instantiates a VisitorLog storing visits under keys starting with prefix, such as "httpjail:"
+func New(client *redis.Client, prefix string) *VisitorLog {
+	return &VisitorLog{
+		client: client,
+		prefix: prefix,
+	}
+}
+
+// score scores a visit by its time in microseconds, which a float64 holds exactly
+func score(t time.Time) string {
+	return strconv.FormatInt(t.UnixMicro(), 10)
+}
+
+// now reads the log's clock, falling back to the system time
+func (l *VisitorLog) now() time.Time {
+	if l.Clock == nil {
+		return time.Now()
+	}
+	return l.Clock.Now()
+}
+
+// ttl returns how long visitor sets live
+func (l *VisitorLog) ttl() time.Duration {
+	if l.TTL > 0 {
+		return l.TTL
+	}
+	return defaultTTL
+}
+
+// logVisit queues a visit by the key, with a random suffix so simultaneous visits from several instances are
+// distinct members
+func (l *VisitorLog) logVisit(ctx context.Context, pipe redis.Pipeliner, key string, now time.Time) {
+	member := score(now) + ":" + strconv.FormatInt(rand.Int63(), 36)
+	pipe.ZAdd(ctx, l.prefix+key, redis.Z{Score: float64(now.UnixMicro()), Member: member})
+	pipe.Expire(ctx, l.prefix+key, l.ttl())
+}
+
+// countVisits queues dropping the key's visits from before since and counting the rest
+func (l *VisitorLog) countVisits(ctx context.Context, pipe redis.Pipeliner, key string, since time.Time) *redis.IntCmd {
+	pipe.ZRemRangeByScore(ctx, l.prefix+key, "-inf", "("+score(since))
+	return pipe.ZCount(ctx, l.prefix+key, score(since), "+inf")
+}
+
+// LogVisit logs a visitor request, dropping it if Redis is unreachable
+func (l *VisitorLog) LogVisit(key string) {
+	ctx := context.Background()
+	l.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		l.logVisit(ctx, pipe, key, l.now())
+		return nil
+	})
+}
+
+// CountVisits counts the visitor's visits since the provided time, or 0 if Redis is unreachable
+func (l *VisitorLog) CountVisits(key string, since time.Time) int {
+	count, _ := l.TryCountVisits(key, since)
+	return count
+}
+
+// TryCountVisits counts the visitor's visits since the provided time, reporting Redis errors to the jail
+func (l *VisitorLog) TryCountVisits(key string, since time.Time) (int, error) {
+	ctx := context.Background()
+	var count *redis.IntCmd
+	_, err := l.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		count = l.countVisits(ctx, pipe, key, since)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int(count.Val()), nil
+}
+
+// TryIncrementAndCount logs a visitor request and counts the visitor's visits in one transaction, reporting
+// Redis errors to the jail
+func (l *VisitorLog) TryIncrementAndCount(key string, since time.Time) (int, error) {
+	ctx := context.Background()
+	var count *redis.IntCmd
+	_, err := l.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		l.logVisit(ctx, pipe, key, l.now())
+		count = l.countVisits(ctx, pipe, key, since)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int(count.Val()), nil
+}
+
+// CountVisitsBatch counts the visits of each key in one pipelined round trip. Keys are reported as 0 if Redis is
+// unreachable.
+func (l *VisitorLog) CountVisitsBatch(keys []string, since time.Time) map[string]int {
+	ctx := context.Background()
+	counts := make(map[string]int, len(keys))
+	cmds := make([]*redis.IntCmd, len(keys))
+	_, err := l.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		// counted without dropping older visits, which may still be in another window
+		for i, key := range keys {
+			cmds[i] = pipe.ZCount(ctx, l.prefix+key, score(since), "+inf")
+		}
+		return nil
+	})
+
+	for i, key := range keys {
+		if err == nil {
+			counts[key] = int(cmds[i].Val())
+		} else {
+			counts[key] = 0
+		}
+	}
+	return counts
+}
+
+// Reset drops all of a visitor's visits
+func (l *VisitorLog) Reset(key string) {
+	l.client.Del(context.Background(), l.prefix+key)
+}
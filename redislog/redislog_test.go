@@ -0,0 +1,157 @@
+package redislog
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/nate-anderson/httpjail"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	_ httpjail.VisitorLog         = (*VisitorLog)(nil)
+	_ httpjail.FallibleVisitorLog = (*VisitorLog)(nil)
+	_ httpjail.BatchVisitorLog    = (*VisitorLog)(nil)
+)
+
+// newTestRedisLog returns a VisitorLog backed by an in-process fake Redis
+func newTestRedisLog(t *testing.T, clock httpjail.Clock) (*VisitorLog, *miniredis.Miniredis) {
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() {
+		client.Close()
+	})
+
+	visitorLog := New(client, "httpjail:")
+	visitorLog.Clock = clock
+	return visitorLog, server
+}
+
+// makeRequest returns a request from the provided address
+func makeRequest(fromAddr string) *http.Request {
+	req := httptest.NewRequest("GET", "/", nil)
+	req.RemoteAddr = fromAddr
+	return req
+}
+
+func TestRedisVisitorLog(t *testing.T) {
+	clock := httpjail.NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	visitorLog, server := newTestRedisLog(t, clock)
+
+	for i := 1; i <= 5; i++ {
+		visitorLog.LogVisit("1.2.3.4")
+		clock.Advance(time.Second)
+	}
+
+	if count := visitorLog.CountVisits("1.2.3.4", clock.Now().Add(-time.Minute)); count != 5 {
+		t.Logf("counted %d visits, expected 5", count)
+		t.Fail()
+	}
+	// visits exactly at since count, as in httpjail.DefaultVisitorLog
+	if count := visitorLog.CountVisits("1.2.3.4", clock.Now().Add(-2*time.Second)); count != 2 {
+		t.Logf("counted %d visits in the last 2 seconds, expected 2", count)
+		t.Fail()
+	}
+	// counting trims visits from before since
+	if stored, _ := server.ZMembers("httpjail:1.2.3.4"); len(stored) != 2 {
+		t.Logf("%d visits stored after counting, expected the 2 in the window", len(stored))
+		t.Fail()
+	}
+	if ttl := server.TTL("httpjail:1.2.3.4"); ttl != defaultTTL {
+		t.Logf("visitor set expires in %v, expected %v", ttl, defaultTTL)
+		t.Fail()
+	}
+
+	count, err := visitorLog.TryIncrementAndCount("1.2.3.4", clock.Now().Add(-2*time.Second))
+	if err != nil || count != 3 {
+		t.Logf("increment counted %d (%v), expected 3", count, err)
+		t.Fail()
+	}
+
+	visitorLog.LogVisit("5.6.7.8")
+	counts := visitorLog.CountVisitsBatch([]string{"1.2.3.4", "5.6.7.8", "9.9.9.9"}, clock.Now().Add(-time.Minute))
+	if counts["1.2.3.4"] != 3 || counts["5.6.7.8"] != 1 || counts["9.9.9.9"] != 0 {
+		t.Logf("incorrect batch counts: %v", counts)
+		t.Fail()
+	}
+
+	visitorLog.Reset("1.2.3.4")
+	if count := visitorLog.CountVisits("1.2.3.4", time.Time{}); count != 0 {
+		t.Logf("%d visits after reset", count)
+		t.Fail()
+	}
+}
+
+func TestRedisVisitorLogSharedAcrossInstances(t *testing.T) {
+	clock := httpjail.NewFakeClock(time.Now())
+	visitorLog, server := newTestRedisLog(t, clock)
+
+	// two instances behind a load balancer, each with its own client
+	jails := make([]*httpjail.Jail, 2)
+	for i := range jails {
+		client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+		defer client.Close()
+		instanceLog := New(client, "httpjail:")
+		instanceLog.Clock = clock
+		jails[i] = httpjail.NewJail(instanceLog, time.Minute, 0, 4)
+		jails[i].Clock = clock
+	}
+
+	allowed := 0
+	for i := 0; i < 8; i++ {
+		if ok, _ := jails[i%2].Allow(makeRequest("1.2.3.4")); ok {
+			allowed++
+		}
+	}
+	if allowed != 4 {
+		t.Logf("%d requests allowed across 2 instances, expected the shared limit of 4", allowed)
+		t.Fail()
+	}
+	if count := visitorLog.CountVisits("1.2.3.4", clock.Now().Add(-time.Minute)); count != 8 {
+		t.Logf("shared log counted %d visits, expected 8", count)
+		t.Fail()
+	}
+}
+
+func TestRedisVisitorLogUnreachable(t *testing.T) {
+	clock := httpjail.NewFakeClock(time.Now())
+	visitorLog, server := newTestRedisLog(t, clock)
+	server.Close()
+
+	for _, failOpen := range []bool{false, true} {
+		jail := httpjail.NewJail(visitorLog, time.Minute, 0, 1)
+		jail.Clock = clock
+		jail.FailOpen = failOpen
+
+		handler := jail.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, makeRequest("1.2.3.4"))
+
+		expected := http.StatusServiceUnavailable
+		if failOpen {
+			expected = http.StatusOK
+		}
+		if rec.Code != expected {
+			t.Logf("FailOpen %v: Redis down answered %d, expected %d", failOpen, rec.Code, expected)
+			t.Fail()
+		}
+	}
+
+	if count := visitorLog.CountVisits("1.2.3.4", time.Time{}); count != 0 {
+		t.Logf("unreachable Redis counted %d visits", count)
+		t.Fail()
+	}
+	if _, err := visitorLog.TryCountVisits("1.2.3.4", time.Time{}); err == nil {
+		t.Log("unreachable Redis counted without an error")
+		t.Fail()
+	}
+	counts := visitorLog.CountVisitsBatch([]string{"1.2.3.4"}, time.Time{})
+	if fmt.Sprint(counts) != "map[1.2.3.4:0]" {
+		t.Logf("unreachable Redis batch counted %v", counts)
+		t.Fail()
+	}
+}
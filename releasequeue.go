@@ -0,0 +1,86 @@
+package httpjail
+
+import (
+	"container/heap"
+	"time"
+)
+
+// releaseEntry is a sentence queued for MaxSentences eviction
+type releaseEntry struct {
+	key     string
+	release time.Time
+}
+
+// releaseQueue is a min-heap of sentences by release time, so the sentence closest to release can be evicted without
+// scanning them all. Entries aren't removed when a sentence ends or is replaced, they're skipped once they surface.
+type releaseQueue []releaseEntry
+
+func (q releaseQueue) Len() int           { return len(q) }
+func (q releaseQueue) Less(i, k int) bool { return q[i].release.Before(q[k].release) }
+func (q releaseQueue) Swap(i, k int)      { q[i], q[k] = q[k], q[i] }
+
+func (q *releaseQueue) Push(x interface{}) {
+	*q = append(*q, x.(releaseEntry))
+}
+
+func (q *releaseQueue) Pop() interface{} {
+	old := *q
+	entry := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return entry
+}
+
+// setSentence records the key's release time, queueing it for eviction when MaxSentences is set. The caller must hold
+// j.sentenceMux.
+func (j *Jail) setSentence(key string, release time.Time) {
+	j.Sentences[key] = release
+	if j.MaxSentences <= 0 {
+		return
+	}
+
+	// stale entries outnumbering live ones are dropped by rebuilding, which is amortized over the pushes that
+	// left them
+	if len(j.releases) >= 2*j.MaxSentences {
+		j.queueReleases()
+		return
+	}
+	heap.Push(&j.releases, releaseEntry{key: key, release: release})
+}
+
+// queueReleases rebuilds the release queue from the jail's sentences. The caller must hold j.sentenceMux.
+func (j *Jail) queueReleases() {
+	j.releases = j.releases[:0]
+	for key, release := range j.Sentences {
+		j.releases = append(j.releases, releaseEntry{key: key, release: release})
+	}
+	heap.Init(&j.releases)
+}
+
+// makeRoomForSentence evicts a sentence if the jail holds MaxSentences, before a new one is added. The caller must
+// hold j.sentenceMux.
+func (j *Jail) makeRoomForSentence() {
+	if j.MaxSentences > 0 && len(j.Sentences) >= j.MaxSentences {
+		j.evictSentence()
+	}
+}
+
+// evictSentence makes room for a new sentence by dropping the one closest to release, which is an expired one if
+// there are any. Evicted clients are released early, so the cap fails open under a flood of distinct keys.
+// The caller must hold j.sentenceMux.
+func (j *Jail) evictSentence() {
+	// sentences set directly on the map, or before MaxSentences was, aren't queued yet
+	if len(j.releases) == 0 {
+		j.queueReleases()
+	}
+
+	for len(j.releases) > 0 {
+		entry := heap.Pop(&j.releases).(releaseEntry)
+		if release, jailed := j.Sentences[entry.key]; jailed && release.Equal(entry.release) {
+			j.unsentence(entry.key)
+			return
+		}
+		if len(j.releases) == 0 && len(j.Sentences) >= j.MaxSentences {
+			j.queueReleases()
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package httpjail
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// RouteLimit applies a limit to requests whose path starts with Path, or matches Pattern if one is set
+type RouteLimit struct {
+	// HTTP method to match, empty matches every method
+	Method string
+	// path prefix to match
+	Path string
+	// precompiled path pattern to match instead of Path, see PathPattern
+	Pattern *regexp.Regexp
+	Limit
+}
+
+// PathPattern compiles a path pattern for a RouteLimit, where each * matches a single path segment, so
+// "/users/*/posts" matches "/users/42/posts" but not "/users/42/drafts/posts"
+func PathPattern(pattern string) *regexp.Regexp {
+	segments := strings.Split(pattern, "*")
+	for i, segment := range segments {
+		segments[i] = regexp.QuoteMeta(segment)
+	}
+	return regexp.MustCompile("^" + strings.Join(segments, "[^/]+") + "$")
+}
+
+// matches reports whether the route applies to the request
+func (r RouteLimit) matches(req *http.Request) bool {
+	if r.Method != "" && !strings.EqualFold(r.Method, requestMethod(req)) {
+		return false
+	}
+	if r.Pattern != nil {
+		return r.Pattern.MatchString(requestPath(req))
+	}
+	return strings.HasPrefix(requestPath(req), r.Path)
+}
+
+// pattern identifies the paths the route matches
+func (r RouteLimit) pattern() string {
+	if r.Pattern != nil {
+		return r.Pattern.String()
+	}
+	return r.Path
+}
+
+// beats reports whether the route is more specific than other: pattern routes beat prefix routes, longer prefixes
+// beat shorter ones, and a method-specific route wins a tie. Otherwise the first declared route wins.
+func (r RouteLimit) beats(other RouteLimit) bool {
+	if (r.Pattern != nil) != (other.Pattern != nil) {
+		return r.Pattern != nil
+	}
+	if r.Pattern == nil && len(r.Path) != len(other.Path) {
+		return len(r.Path) > len(other.Path)
+	}
+	return other.Method == "" && r.Method != ""
+}
+
+// requestMethod returns the request's method, treating an empty method as GET like net/http does
+func requestMethod(req *http.Request) string {
+	if req.Method == "" {
+		return http.MethodGet
+	}
+	return req.Method
+}
+
+// requestPath returns the request's path, or an empty path for malformed requests without a URL
+func requestPath(req *http.Request) string {
+	if req.URL == nil {
+		return ""
+	}
+	return req.URL.Path
+}
+
+// matchRoute finds the most specific route limit matching the request
+func (j *Jail) matchRoute(req *http.Request) (RouteLimit, bool) {
+	var best RouteLimit
+	found := false
+	for _, route := range j.Routes {
+		if !route.matches(req) {
+			continue
+		}
+		if !found || route.beats(best) {
+			best, found = route, true
+		}
+	}
+	return best, found
+}
+
+// KeyByEndpoint keys visitors by method, path and client IP, giving each IP a separate budget on every endpoint.
+// Every distinct path is a separate key, so paths carrying IDs multiply the keys held in the visitor log.
+func KeyByEndpoint(req *http.Request) string {
+	return requestMethod(req) + " " + requestPath(req) + "@" + KeyByIP(req)
+}
+
+// NewEndpointJail constructs a jail allowing allowedRequests to each endpoint (method and path) per IP per window.
+// Routes override the limit for matching endpoints, the longest matching path winning; each endpoint a route
+// matches still gets its own budget.
+func NewEndpointJail(window time.Duration, allowedRequests int, routes []RouteLimit, opts ...Option) *Jail {
+	jail := NewJail(NewDefaultVisitorLog(), window, 0, allowedRequests)
+	jail.KeyFunc = KeyByEndpoint
+	jail.Routes = routes
+	jail.apply(opts)
+	return jail
+}
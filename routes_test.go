@@ -0,0 +1,201 @@
+package httpjail
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestRouteBlockStatus(t *testing.T) {
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, 0, 10)
+	jail.Routes = []RouteLimit{
+		{Path: "/api", Limit: Limit{AllowedRequests: 1, Window: time.Minute, BlockStatus: http.StatusTooManyRequests}},
+		{Path: "/legacy", Limit: Limit{AllowedRequests: 1, Window: time.Minute, BlockStatus: http.StatusServiceUnavailable}},
+	}
+
+	handler := jail.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(successRes))
+	}))
+
+	routes := map[string]int{
+		"/api/users":    http.StatusTooManyRequests,
+		"/legacy/index": http.StatusServiceUnavailable,
+	}
+
+	for path, blockStatus := range routes {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != http.StatusOK {
+			t.Logf("first request to %s: got status %d", path, rec.Code)
+			t.Fail()
+		}
+
+		rec = httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		if rec.Code != blockStatus {
+			t.Logf("blocked request to %s: got status %d, expected %d", path, rec.Code, blockStatus)
+			t.Fail()
+		}
+	}
+}
+
+func TestMatchRoute(t *testing.T) {
+	jail := &Jail{
+		Routes: []RouteLimit{
+			{Path: "/"},
+			{Path: "/users"},
+			{Path: "/users/", Method: "POST"},
+			{Path: "/users/"},
+		},
+	}
+
+	cases := []struct {
+		method, path, expectedMethod, expectedPath string
+	}{
+		{"GET", "/", "", "/"},
+		{"GET", "/users", "", "/users"},
+		{"GET", "/users/1", "", "/users/"},
+		{"POST", "/users/1", "POST", "/users/"},
+	}
+
+	for _, c := range cases {
+		route, ok := jail.matchRoute(httptest.NewRequest(c.method, c.path, nil))
+		if !ok || route.Method != c.expectedMethod || route.Path != c.expectedPath {
+			t.Logf("%s %s matched %q %q, expected %q %q", c.method, c.path, route.Method, route.Path, c.expectedMethod, c.expectedPath)
+			t.Fail()
+		}
+	}
+}
+
+func TestPatternRoutes(t *testing.T) {
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, 0, 10)
+	jail.Routes = []RouteLimit{
+		{Path: "/users", Limit: Limit{AllowedRequests: 5, Window: time.Minute}},
+		{Pattern: PathPattern("/users/*/posts"), Limit: Limit{AllowedRequests: 1, Window: time.Minute}},
+		{Pattern: regexp.MustCompile(`^/orders/[0-9]+$`), Limit: Limit{AllowedRequests: 2, Window: time.Minute}},
+	}
+
+	cases := []struct {
+		path    string
+		allowed int
+	}{
+		{"/users/42/posts", 1},
+		{"/users/alice/posts", 1},
+		{"/users/42/drafts/posts", 5},
+		{"/users/42", 5},
+		{"/orders/7", 2},
+		{"/orders/abc", 10},
+	}
+
+	for _, c := range cases {
+		route, ok := jail.matchRoute(httptest.NewRequest("GET", c.path, nil))
+		allowed := jail.AllowedRequests
+		if ok {
+			allowed = route.AllowedRequests
+		}
+		if allowed != c.allowed {
+			t.Logf("%s matched a limit of %d, expected %d", c.path, allowed, c.allowed)
+			t.Fail()
+		}
+	}
+
+	// each parameterized route keeps its own bucket per visitor
+	for i := 0; i < 2; i++ {
+		req := httptest.NewRequest("GET", "/users/42/posts", nil)
+		if allowed := serveJail(jail, req); allowed != (i == 0) {
+			t.Logf("request %d to a pattern route: allowed %v", i, allowed)
+			t.Fail()
+		}
+	}
+	if !serveJail(jail, httptest.NewRequest("GET", "/users/42", nil)) {
+		t.Log("prefix route shared the pattern route's bucket")
+		t.Fail()
+	}
+}
+
+func TestEndpointJail(t *testing.T) {
+	jail := NewEndpointJail(time.Minute, 2, []RouteLimit{
+		{Method: "POST", Path: "/login", Limit: Limit{AllowedRequests: 1, Window: time.Minute}},
+	})
+	jail.Clock = NewFakeClock(time.Now())
+
+	request := func(method, path, ip string) bool {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = ip
+		return serveJail(jail, req)
+	}
+
+	// each endpoint has its own default budget per IP
+	for _, path := range []string{"/users", "/orders"} {
+		for _, ip := range []string{"1.1.1.1", "2.2.2.2"} {
+			for i := 0; i < 2; i++ {
+				if !request("GET", path, ip) {
+					t.Logf("GET %s from %s: request %d denied", path, ip, i)
+					t.Fail()
+				}
+			}
+			if request("GET", path, ip) {
+				t.Logf("GET %s from %s: request over the limit allowed", path, ip)
+				t.Fail()
+			}
+		}
+	}
+
+	// the route limit applies to its endpoint, per IP
+	for _, ip := range []string{"1.1.1.1", "2.2.2.2"} {
+		if !request("POST", "/login", ip) {
+			t.Logf("first login from %s denied", ip)
+			t.Fail()
+		}
+		if request("POST", "/login", ip) {
+			t.Logf("second login from %s allowed", ip)
+			t.Fail()
+		}
+	}
+
+	// other methods on the same path are a separate endpoint
+	if !request("GET", "/login", "1.1.1.1") {
+		t.Log("GET /login shared the POST /login budget")
+		t.Fail()
+	}
+}
+
+func TestStrictRoutes(t *testing.T) {
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, 0, 5)
+	strict := Limit{AllowedRequests: 2, Window: time.Minute}
+	jail.Routes = []RouteLimit{{Path: "/login", Limit: strict}, {Path: "/password-reset", Limit: strict}}
+
+	request := func(path string) bool {
+		return serveJail(jail, httptest.NewRequest("GET", path, nil))
+	}
+	allowedOf := func(path string, n int) int {
+		allowed := 0
+		for i := 0; i < n; i++ {
+			if request(path) {
+				allowed++
+			}
+		}
+		return allowed
+	}
+
+	// the strict paths are held to their own limit, each in its own bucket
+	for _, path := range []string{"/login", "/password-reset"} {
+		if allowed := allowedOf(path, 4); allowed != 2 {
+			t.Logf("%s allowed %d requests, expected 2", path, allowed)
+			t.Fail()
+		}
+	}
+
+	// exhausting the strict paths leaves the default limit untouched, and the reverse
+	if allowed := allowedOf("/", 7); allowed != 5 {
+		t.Logf("default path allowed %d requests after the strict paths were exhausted, expected 5", allowed)
+		t.Fail()
+	}
+	jail.Routes = append(jail.Routes, RouteLimit{Path: "/signup", Limit: strict})
+	if allowed := allowedOf("/signup", 3); allowed != 2 {
+		t.Logf("strict path allowed %d requests after the default was exhausted, expected 2", allowed)
+		t.Fail()
+	}
+}
@@ -0,0 +1,149 @@
+package httpjail
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// sentenceSnapshot is the serialized form of a jail's sentences
+type sentenceSnapshot struct {
+	Sentences map[string]time.Time `json:"sentences"`
+	Offenses  map[string]int       `json:"offenses,omitempty"`
+	// release time of each offender's latest sentence, for ForgiveAfter
+	Released map[string]time.Time `json:"released,omitempty"`
+}
+
+// SaveSentences writes the jail's sentences and repeat offense counts to w as JSON
+func (j *Jail) SaveSentences(w io.Writer) error {
+	snapshot := sentenceSnapshot{
+		Sentences: make(map[string]time.Time),
+		Offenses:  make(map[string]int),
+		Released:  make(map[string]time.Time),
+	}
+
+	j.sentenceMux.RLock()
+	for key, release := range j.Sentences {
+		snapshot.Sentences[key] = release
+	}
+	for key, offenses := range j.offenses {
+		snapshot.Offenses[key] = offenses
+	}
+	for key, released := range j.lastReleases {
+		snapshot.Released[key] = released
+	}
+	j.sentenceMux.RUnlock()
+
+	return json.NewEncoder(w).Encode(snapshot)
+}
+
+// LoadSentences reads sentences and offense counts written by SaveSentences, adding them to the jail's current state.
+// Sentences that expired while the snapshot was stored are dropped.
+func (j *Jail) LoadSentences(r io.Reader) error {
+	var snapshot sentenceSnapshot
+	if err := json.NewDecoder(r).Decode(&snapshot); err != nil {
+		return err
+	}
+
+	if j.NoSentencing {
+		return nil
+	}
+
+	j.sentenceMux.Lock()
+	defer j.sentenceMux.Unlock()
+	if j.Sentences == nil {
+		j.Sentences = make(map[string]time.Time)
+	}
+	now := j.now()
+	for key, release := range snapshot.Sentences {
+		if !release.After(now) {
+			continue
+		}
+		// loaded sentences count towards MaxSentences like any other
+		if _, jailed := j.Sentences[key]; !jailed {
+			j.makeRoomForSentence()
+		}
+		j.setSentence(key, release)
+	}
+
+	if len(snapshot.Offenses) > 0 && j.offenses == nil {
+		j.offenses = make(map[string]int)
+	}
+	for key, offenses := range snapshot.Offenses {
+		j.offenses[key] = offenses
+	}
+
+	if len(snapshot.Released) > 0 && j.lastReleases == nil {
+		j.lastReleases = make(map[string]time.Time)
+	}
+	for key, released := range snapshot.Released {
+		j.lastReleases[key] = released
+	}
+	return nil
+}
+
+// ListSentences returns the visitors currently serving sentences and when each will be released
+func (j *Jail) ListSentences() map[string]time.Time {
+	now := j.now()
+	sentences := make(map[string]time.Time)
+
+	j.sentenceMux.RLock()
+	defer j.sentenceMux.RUnlock()
+	for key, release := range j.Sentences {
+		if release.After(now) {
+			sentences[key] = release
+		}
+	}
+	return sentences
+}
+
+// Ban sentences the visitor key for d regardless of its rate, replacing any sentence it's serving. The key is the
+// visitor key as the jail counts it, which for requests under a route, host or class limit includes the rule's scope.
+// Bans have no effect with NoSentencing. OnSentence fires only if the visitor wasn't already serving a sentence.
+func (j *Jail) Ban(key string, d time.Duration) {
+	if j.NoSentencing {
+		return
+	}
+	now := j.now()
+	release := now.Add(d)
+
+	j.sentenceMux.Lock()
+	if j.Sentences == nil {
+		j.Sentences = make(map[string]time.Time)
+	}
+	current, jailed := j.Sentences[key]
+	if !jailed {
+		j.makeRoomForSentence()
+	}
+	j.setSentence(key, release)
+	j.sentenceMux.Unlock()
+
+	if j.OnSentence != nil && !(jailed && current.After(now)) {
+		j.OnSentence(key, release)
+	}
+}
+
+// Pardon releases the visitor key from its sentence and forgets its past offenses. Unlike Reset its visits are kept,
+// so a visitor still over the limit is blocked again.
+func (j *Jail) Pardon(key string) {
+	j.sentenceMux.Lock()
+	defer j.sentenceMux.Unlock()
+	j.unsentence(key)
+	j.forgive(key)
+}
+
+// visitorResetter is implemented by visitor logs that can forget a visitor
+type visitorResetter interface {
+	Reset(key string)
+}
+
+// Reset releases the visitor key from any sentence and clears its visit history, if the visitor log supports it
+func (j *Jail) Reset(key string) {
+	j.sentenceMux.Lock()
+	j.unsentence(key)
+	j.sentenceMux.Unlock()
+
+	if resetter, ok := j.visitors.(visitorResetter); ok {
+		resetter.Reset(key)
+	}
+}
@@ -0,0 +1,318 @@
+package httpjail
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestEscalatingCooloff(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	cooloff := 10 * time.Second
+	jail := NewJailForTesting(clock, time.Second, cooloff, 1)
+	jail.EscalateCooloff = true
+
+	// offend, wait out the sentence, offend again
+	offend := func() time.Duration {
+		serveJail(jail, makeRequest("1.2.3.4", false))
+		serveJail(jail, makeRequest("1.2.3.4", false))
+		sentence := jail.Sentences["1.2.3.4"].Sub(clock.Now())
+		clock.Advance(sentence + time.Second)
+		return sentence
+	}
+
+	for i, expected := range []time.Duration{cooloff, 2 * cooloff, 4 * cooloff} {
+		if sentence := offend(); sentence != expected {
+			t.Logf("offense %d: sentenced for %s, expected %s", i+1, sentence, expected)
+			t.Fail()
+		}
+	}
+}
+
+func TestSentencesSurviveRestart(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	before := NewJailForTesting(clock, time.Minute, time.Hour, 1)
+	serveJail(before, makeRequest("1.2.3.4", false))
+	serveJail(before, makeRequest("1.2.3.4", false))
+	before.Sentences["5.6.7.8"] = clock.Now().Add(time.Minute)
+
+	var saved bytes.Buffer
+	if err := before.SaveSentences(&saved); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// restart once the short sentence has expired
+	clock.Advance(10 * time.Minute)
+	after := NewJailForTesting(clock, time.Minute, time.Hour, 1)
+	if err := after.LoadSentences(&saved); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	if _, loaded := after.Sentences["5.6.7.8"]; loaded {
+		t.Log("expired sentence loaded")
+		t.Fail()
+	}
+	// the fresh jail has no visits for the client, only its sentence
+	if serveJail(after, makeRequest("1.2.3.4", false)) {
+		t.Log("sentenced client allowed after restart")
+		t.Fail()
+	}
+	if release := after.Sentences["1.2.3.4"]; !release.Equal(clock.Now().Add(50 * time.Minute)) {
+		t.Logf("sentence released at %v after restart, expected 50 minutes from now", release)
+		t.Fail()
+	}
+}
+
+func TestOffensesSurviveRestart(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	cooloff := 10 * time.Second
+
+	before := NewJailForTesting(clock, time.Second, cooloff, 1)
+	before.EscalateCooloff = true
+	serveJail(before, makeRequest("1.2.3.4", false))
+	serveJail(before, makeRequest("1.2.3.4", false))
+
+	var saved bytes.Buffer
+	if err := before.SaveSentences(&saved); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	// restart after the first sentence has expired
+	clock.Advance(time.Minute)
+	after := NewJailForTesting(clock, time.Second, cooloff, 1)
+	after.EscalateCooloff = true
+	if err := after.LoadSentences(&saved); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	serveJail(after, makeRequest("1.2.3.4", false))
+	serveJail(after, makeRequest("1.2.3.4", false))
+
+	sentence := after.Sentences["1.2.3.4"].Sub(clock.Now())
+	if sentence != 2*cooloff {
+		t.Logf("repeat offender sentenced for %s after restart, expected the escalated %s", sentence, 2*cooloff)
+		t.Fail()
+	}
+}
+
+func TestLoadSentencesMaxSentences(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	before := NewJailForTesting(clock, time.Minute, time.Hour, 1)
+	for i := 0; i < 5; i++ {
+		before.Ban(fmt.Sprintf("10.0.0.%d", i), time.Duration(i+1)*time.Minute)
+	}
+	var saved bytes.Buffer
+	if err := before.SaveSentences(&saved); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+
+	after := NewJailForTesting(clock, time.Minute, time.Hour, 1)
+	after.MaxSentences = 2
+	after.Ban("1.2.3.4", 10*time.Minute)
+	if err := after.LoadSentences(&saved); err != nil {
+		t.Log(err)
+		t.FailNow()
+	}
+	if len(after.Sentences) != 2 {
+		t.Logf("%d sentences held after loading, expected MaxSentences of 2", len(after.Sentences))
+		t.Fail()
+	}
+	if _, jailed := after.Sentences["1.2.3.4"]; !jailed {
+		t.Log("longest sentence evicted by loaded ones")
+		t.Fail()
+	}
+}
+
+func TestOnSentence(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	jail := NewJailForTesting(clock, time.Minute, time.Hour, 5)
+	var releases []time.Time
+	jail.OnSentence = func(key string, release time.Time) {
+		// the callback may use the jail, as no lock is held
+		if jail.Status().Sentences != 1 {
+			t.Log("sentence not recorded before OnSentence")
+			t.Fail()
+		}
+		if key != "1.2.3.4" {
+			t.Logf("sentenced unexpected key %q", key)
+			t.Fail()
+		}
+		releases = append(releases, release)
+	}
+
+	// a sustained burst well over the limit is sentenced once, on its 6th request
+	for i := 0; i < 50; i++ {
+		serveJail(jail, makeRequest("1.2.3.4", false))
+		clock.Advance(time.Second)
+	}
+	if len(releases) != 1 {
+		t.Logf("OnSentence fired %d times during one burst", len(releases))
+		t.FailNow()
+	}
+	if expected := clock.Now().Add(-45 * time.Second).Add(time.Hour); !releases[0].Equal(expected) {
+		t.Logf("released at %v, expected %v", releases[0], expected)
+		t.Fail()
+	}
+
+	// serving the sentence and offending again is a new sentence
+	clock.Advance(2 * time.Hour)
+	for i := 0; i < 10; i++ {
+		serveJail(jail, makeRequest("1.2.3.4", false))
+	}
+	if len(releases) != 2 {
+		t.Logf("OnSentence fired %d times after a second burst, expected 2", len(releases))
+		t.Fail()
+	}
+}
+
+func TestBanPardon(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	jail := NewJailForTesting(clock, time.Minute, time.Hour, 100)
+	sentenced := 0
+	jail.OnSentence = func(string, time.Time) { sentenced++ }
+
+	jail.Ban("1.2.3.4", 10*time.Minute)
+	if serveJail(jail, makeRequest("1.2.3.4", false)) {
+		t.Log("banned client allowed")
+		t.Fail()
+	}
+	if !serveJail(jail, makeRequest("5.6.7.8", false)) {
+		t.Log("client without a ban blocked")
+		t.Fail()
+	}
+	sentences := jail.ListSentences()
+	if len(sentences) != 1 || !sentences["1.2.3.4"].Equal(clock.Now().Add(10*time.Minute)) || sentenced != 1 {
+		t.Logf("listed sentences %v after %d OnSentence calls", sentences, sentenced)
+		t.Fail()
+	}
+
+	// replacing a ban isn't a new sentence
+	jail.Ban("1.2.3.4", 20*time.Minute)
+	if sentenced != 1 || !jail.ListSentences()["1.2.3.4"].Equal(clock.Now().Add(20*time.Minute)) {
+		t.Logf("replacing a ban fired %d OnSentence calls and released at %v", sentenced,
+			jail.ListSentences()["1.2.3.4"])
+		t.Fail()
+	}
+
+	jail.Pardon("1.2.3.4")
+	if !serveJail(jail, makeRequest("1.2.3.4", false)) {
+		t.Log("pardoned client still blocked")
+		t.Fail()
+	}
+	if sentences := jail.ListSentences(); len(sentences) != 0 {
+		t.Logf("sentences listed after a pardon: %v", sentences)
+		t.Fail()
+	}
+
+	// served bans aren't listed, and banning again once served is a new sentence
+	jail.Ban("1.2.3.4", time.Minute)
+	clock.Advance(2 * time.Minute)
+	if sentences := jail.ListSentences(); len(sentences) != 0 {
+		t.Logf("expired ban listed: %v", sentences)
+		t.Fail()
+	}
+	jail.Ban("1.2.3.4", time.Minute)
+	if sentenced != 3 {
+		t.Logf("%d OnSentence calls after banning a served visitor again, expected 3", sentenced)
+		t.Fail()
+	}
+}
+
+func TestBanLimits(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	jail := NewJailForTesting(clock, time.Minute, time.Hour, 100)
+	jail.NoSentencing = true
+	sentenced := 0
+	jail.OnSentence = func(string, time.Time) { sentenced++ }
+
+	jail.Ban("1.2.3.4", 10*time.Minute)
+	if !serveJail(jail, makeRequest("1.2.3.4", false)) || len(jail.Sentences) != 0 || sentenced != 0 {
+		t.Logf("ban took effect with NoSentencing: %d sentences, %d OnSentence calls", len(jail.Sentences), sentenced)
+		t.Fail()
+	}
+
+	// bans count towards MaxSentences like any other sentence
+	jail.NoSentencing = false
+	jail.MaxSentences = 2
+	for i := 0; i < 5; i++ {
+		jail.Ban(fmt.Sprintf("10.0.0.%d", i), time.Duration(10-i)*time.Minute)
+	}
+	if len(jail.Sentences) != 2 {
+		t.Logf("%d bans held, expected MaxSentences of 2", len(jail.Sentences))
+		t.Fail()
+	}
+	if _, jailed := jail.Sentences["10.0.0.4"]; !jailed {
+		t.Log("latest ban evicted")
+		t.Fail()
+	}
+}
+
+func TestMaxCooloff(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	cooloff := 10 * time.Second
+	jail := NewJailForTesting(clock, time.Second, cooloff, 1)
+	jail.EscalateCooloff = true
+	jail.MaxCooloff = 30 * time.Second
+
+	var sentences []time.Duration
+	for i := 0; i < 4; i++ {
+		serveJail(jail, makeRequest("1.2.3.4", false))
+		serveJail(jail, makeRequest("1.2.3.4", false))
+		sentence := jail.Sentences["1.2.3.4"].Sub(clock.Now())
+		sentences = append(sentences, sentence)
+		clock.Advance(sentence + time.Second)
+	}
+
+	if sentences[1] <= sentences[0] {
+		t.Logf("second sentence of %s no longer than the first of %s", sentences[1], sentences[0])
+		t.Fail()
+	}
+	for i, expected := range []time.Duration{cooloff, 2 * cooloff, jail.MaxCooloff, jail.MaxCooloff} {
+		if sentences[i] != expected {
+			t.Logf("offense %d: sentenced for %s, expected %s", i+1, sentences[i], expected)
+			t.Fail()
+		}
+	}
+}
+
+func TestForgiveAfter(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	cooloff := 10 * time.Second
+	jail := NewJailForTesting(clock, time.Second, cooloff, 1)
+	jail.EscalateCooloff = true
+	jail.ForgiveAfter = time.Hour
+
+	offend := func() time.Duration {
+		serveJail(jail, makeRequest("1.2.3.4", false))
+		serveJail(jail, makeRequest("1.2.3.4", false))
+		return jail.Sentences["1.2.3.4"].Sub(clock.Now())
+	}
+
+	offend()
+	clock.Advance(30 * time.Minute)
+	if sentence := offend(); sentence != 2*cooloff {
+		t.Logf("offending again within ForgiveAfter sentenced for %s, expected %s", sentence, 2*cooloff)
+		t.Fail()
+	}
+
+	// behaving for ForgiveAfter after release starts escalation over
+	clock.Advance(2*cooloff + time.Hour)
+	if sentence := offend(); sentence != cooloff {
+		t.Logf("offending after ForgiveAfter sentenced for %s, expected %s", sentence, cooloff)
+		t.Fail()
+	}
+
+	// cleanup forgets offenders who have behaved
+	clock.Advance(cooloff + time.Hour)
+	jail.Cleanup()
+	if explanation := jail.Explain("1.2.3.4"); explanation.Offenses != 0 {
+		t.Logf("cleanup kept %d offenses for a forgiven visitor", explanation.Offenses)
+		t.Fail()
+	}
+}
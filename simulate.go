@@ -0,0 +1,143 @@
+package httpjail
+
+import (
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// SimRequest is a request in a synthetic traffic log replayed by Simulate
+type SimRequest struct {
+	// time the request arrives, requests must be in time order
+	Time time.Time
+	// the request, which Simulate doesn't modify
+	Request *http.Request
+}
+
+// Simulate replays a traffic log through the jail's configured limits and returns the decision on each request,
+// for modelling a limit change offline before applying it. Requests are screened by Disabled, AllowedIPs and
+// DeniedIPs as they would be live. The replay runs against a fresh, empty visitor log of the same kind as the
+// jail's, with no sentences, isolated from the live jail's state. Remote stores such as redislog are modelled with a
+// DefaultVisitorLog, and a GossipVisitorLog by its local log alone. Handlers never run, so limits driven by responses
+// (ErrorBudget, SlowRequest) have nothing to act on.
+func (j *Jail) Simulate(requests []SimRequest) []Decision {
+	if len(requests) == 0 {
+		return nil
+	}
+
+	clock := NewFakeClock(requests[0].Time)
+	sim := j.simulation(simulationLog(j.visitors, clock), clock)
+
+	decisions := make([]Decision, len(requests))
+	for i, request := range requests {
+		clock.Set(request.Time)
+		req := request.Request.Clone(request.Request.Context())
+		if decision, screened := sim.screen(req); screened {
+			decision.Time = request.Time
+			decisions[i] = decision
+			continue
+		}
+		decisions[i] = sim.judge(req)
+	}
+	return decisions
+}
+
+// simulatedLog is implemented by in-memory visitor logs that can start an empty log counting the same way
+type simulatedLog interface {
+	emptyCopy(clock Clock) VisitorLog
+}
+
+// simulationLog returns an empty visitor log like live for a simulation, or a DefaultVisitorLog if live can't make
+// one
+func simulationLog(live VisitorLog, clock Clock) VisitorLog {
+	if simulated, ok := live.(simulatedLog); ok {
+		return simulated.emptyCopy(clock)
+	}
+	visitors := NewDefaultVisitorLog()
+	visitors.Clock = clock
+	return visitors
+}
+
+// emptyCopy returns an empty DefaultVisitorLog with the same settings
+func (l *DefaultVisitorLog) emptyCopy(clock Clock) VisitorLog {
+	visitors := NewDefaultVisitorLog()
+	visitors.Clock = clock
+	visitors.MaxVisitors = l.MaxVisitors
+	visitors.Interval = l.Interval
+	visitors.MaxVisitAge = l.MaxVisitAge
+	return visitors
+}
+
+// emptyCopy returns an empty FixedWindowLog with the same window
+func (l *FixedWindowLog) emptyCopy(clock Clock) VisitorLog {
+	visitors := NewFixedWindowLog(l.window)
+	visitors.Clock = clock
+	return visitors
+}
+
+// emptyCopy returns an empty SlidingWindowLog with the same window
+func (l *SlidingWindowLog) emptyCopy(clock Clock) VisitorLog {
+	visitors := NewSlidingWindowLog(l.window)
+	visitors.Clock = clock
+	return visitors
+}
+
+// emptyCopy returns a TokenBucketLog with the same buckets, all full
+func (l *TokenBucketLog) emptyCopy(clock Clock) VisitorLog {
+	visitors := NewTokenBucketLog(l.capacity, l.refill, l.interval)
+	visitors.Clock = clock
+	return visitors
+}
+
+// emptyCopy returns an empty copy of the local log, other nodes' gossip isn't replayed
+func (l *GossipVisitorLog) emptyCopy(clock Clock) VisitorLog {
+	return simulationLog(l.local, clock)
+}
+
+// simulation copies the jail's limiting configuration into a new jail counting in the provided visitor log
+func (j *Jail) simulation(visitors VisitorLog, clock Clock) *Jail {
+	j.limitsMux.RLock()
+	defer j.limitsMux.RUnlock()
+
+	return &Jail{
+		disabled:           atomic.LoadInt32(&j.disabled),
+		TrackWhileDisabled: j.TrackWhileDisabled,
+		AllowedIPs:         j.AllowedIPs,
+		DeniedIPs:          j.DeniedIPs,
+		isProxied:          j.isProxied,
+		AllowedRequests:    j.AllowedRequests,
+		SoftLimit:          j.SoftLimit,
+		Window:             j.Window,
+		visitors:           visitors,
+		Cooloff:            j.Cooloff,
+		Sentences:          make(map[string]time.Time),
+		NoSentencing:       j.NoSentencing,
+		Clock:              clock,
+		ProxyHeader:        j.ProxyHeader,
+		TrustedProxyCount:  j.TrustedProxyCount,
+		MaxForwardedHops:   j.MaxForwardedHops,
+		MaxForwardedLength: j.MaxForwardedLength,
+		KeyFunc:            j.KeyFunc,
+		UnknownVisitorKey:  j.UnknownVisitorKey,
+		HostLimits:         j.HostLimits,
+		ClassLimits:        j.ClassLimits,
+		OriginAllowlist:    j.OriginAllowlist,
+		FirstPartyLimit:    j.FirstPartyLimit,
+		Routes:             j.Routes,
+		ExtraLimits:        j.ExtraLimits,
+		KeyLimits:          j.KeyLimits,
+		LeadingEdge:        j.LeadingEdge,
+		IdempotencyWindow:  j.IdempotencyWindow,
+		CountOnlyIPs:       j.CountOnlyIPs,
+		DryRun:             j.DryRun,
+		Metered:            j.Metered,
+		AccountFunc:        j.AccountFunc,
+		MaxAccountIPs:      j.MaxAccountIPs,
+		MaxSentences:       j.MaxSentences,
+		EscalateCooloff:    j.EscalateCooloff,
+		MaxCooloff:         j.MaxCooloff,
+		ForgiveAfter:       j.ForgiveAfter,
+		QuietRelease:       j.QuietRelease,
+		LogAllowedOnly:     j.LogAllowedOnly,
+	}
+}
@@ -0,0 +1,111 @@
+package httpjail
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSimulate(t *testing.T) {
+	jail := NewJailForTesting(NewFakeClock(time.Now()), 10*time.Second, 20*time.Second, 2)
+	jail.Routes = []RouteLimit{{Path: "/search", Limit: Limit{AllowedRequests: 1, Window: 10 * time.Second}}}
+
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	request := func(offset time.Duration, ip, path string) SimRequest {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = ip
+		return SimRequest{Time: start.Add(offset), Request: req}
+	}
+
+	script := []struct {
+		request SimRequest
+		allowed bool
+	}{
+		{request(0, "1.1.1.1", "/"), true},
+		{request(time.Second, "1.1.1.1", "/"), true},
+		// over the default limit, sentenced for 20s
+		{request(2*time.Second, "1.1.1.1", "/"), false},
+		// other visitors and routes are counted separately
+		{request(3*time.Second, "2.2.2.2", "/"), true},
+		{request(4*time.Second, "2.2.2.2", "/search"), true},
+		{request(5*time.Second, "2.2.2.2", "/search"), false},
+		// the window has passed but the sentence hasn't
+		{request(15*time.Second, "1.1.1.1", "/"), false},
+		{request(16*time.Second, "2.2.2.2", "/search"), true},
+		{request(23*time.Second, "1.1.1.1", "/"), true},
+	}
+
+	var requests []SimRequest
+	for _, step := range script {
+		requests = append(requests, step.request)
+	}
+	decisions := jail.Simulate(requests)
+
+	if len(decisions) != len(script) {
+		t.Logf("got %d decisions for %d requests", len(decisions), len(script))
+		t.FailNow()
+	}
+	for i, step := range script {
+		if decisions[i].Allowed != step.allowed {
+			t.Logf("request %d (%s %s at %s): allowed %v, expected %v", i, step.request.Request.RemoteAddr,
+				step.request.Request.URL.Path, step.request.Time.Sub(start), decisions[i].Allowed, step.allowed)
+			t.Fail()
+		}
+		if !decisions[i].Time.Equal(step.request.Time) {
+			t.Logf("request %d decided at %s, expected the simulated time", i, decisions[i].Time)
+			t.Fail()
+		}
+	}
+
+	// the live jail is untouched
+	if len(jail.Sentences) != 0 || jail.visitors.CountVisits("1.1.1.1", time.Time{}) != 0 {
+		t.Log("simulation leaked into the live jail")
+		t.Fail()
+	}
+}
+
+func TestSimulateScreensAndMatchesLog(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	burst := func(ip string) []SimRequest {
+		var requests []SimRequest
+		for i := 0; i < 3; i++ {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.RemoteAddr = ip
+			requests = append(requests, SimRequest{Time: start.Add(time.Duration(i) * time.Second), Request: req})
+		}
+		return requests
+	}
+	allowed := func(decisions []Decision) int {
+		count := 0
+		for _, decision := range decisions {
+			if decision.Allowed {
+				count++
+			}
+		}
+		return count
+	}
+
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Minute, 0, 1)
+	jail.AllowedIPs, _ = ParseNetworks("10.0.0.0/8")
+	jail.DeniedIPs, _ = ParseNetworks("192.0.2.1")
+	if n := allowed(jail.Simulate(burst("10.1.2.3"))); n != 3 {
+		t.Logf("%d of 3 requests from AllowedIPs allowed", n)
+		t.Fail()
+	}
+	if n := allowed(jail.Simulate(burst("192.0.2.1"))); n != 0 {
+		t.Logf("%d of 3 requests from DeniedIPs allowed", n)
+		t.Fail()
+	}
+	jail.Disable()
+	if n := allowed(jail.Simulate(burst("1.2.3.4"))); n != 3 {
+		t.Logf("%d of 3 requests allowed by a disabled jail", n)
+		t.Fail()
+	}
+
+	// a token bucket refilling every second lets the whole burst through where a window log wouldn't
+	bucketJail := NewTokenBucketJail(1, 1, time.Second)
+	if n := allowed(bucketJail.Simulate(burst("1.2.3.4"))); n != 3 {
+		t.Logf("%d of 3 requests allowed by a simulated token bucket refilling every request", n)
+		t.Fail()
+	}
+}
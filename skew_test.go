@@ -0,0 +1,155 @@
+package httpjail
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// steppingClock moves forward by step every time it's read, like a real clock during slow processing
+type steppingClock struct {
+	mux  sync.Mutex
+	now  time.Time
+	step time.Duration
+}
+
+func (c *steppingClock) Now() time.Time {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.now = c.now.Add(c.step)
+	return c.now
+}
+
+// skewedClock reads another clock offset by skew, like a store host whose clock drifted
+type skewedClock struct {
+	base Clock
+	skew time.Duration
+}
+
+func (c skewedClock) Now() time.Time {
+	return c.base.Now().Add(c.skew)
+}
+
+// slowVisitorLog advances a fake clock by latency on every call, like a remote store
+type slowVisitorLog struct {
+	VisitorLog
+	clock   *FakeClock
+	latency time.Duration
+}
+
+func (l slowVisitorLog) LogVisit(key string) {
+	l.clock.Advance(l.latency)
+	l.VisitorLog.LogVisit(key)
+}
+
+func (l slowVisitorLog) CountVisits(key string, since time.Time) int {
+	l.clock.Advance(l.latency)
+	return l.VisitorLog.CountVisits(key, since)
+}
+
+// newSlowJail creates a jail backed by a visitor log taking latency per call
+func newSlowJail(clock *FakeClock, latency, window, cooloff time.Duration, allowedRequests int) *Jail {
+	local := NewDefaultVisitorLog()
+	local.Clock = clock
+	jail := NewJail(slowVisitorLog{VisitorLog: local, clock: clock, latency: latency}, window, cooloff, allowedRequests)
+	jail.Clock = clock
+	return jail
+}
+
+func TestCountingUnderStoreLatency(t *testing.T) {
+	clock := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	jail := newSlowJail(clock, 2*time.Second, time.Minute, 0, 5)
+
+	for i := 0; i < 5; i++ {
+		if !serveJail(jail, makeRequest("1.2.3.4", false)) {
+			t.Logf("request %d denied under store latency", i)
+			t.Fail()
+		}
+	}
+	if serveJail(jail, makeRequest("1.2.3.4", false)) {
+		t.Log("request over the limit allowed under store latency")
+		t.Fail()
+	}
+}
+
+func TestSentencingUnderStoreLatency(t *testing.T) {
+	clock := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	cooloff := 10 * time.Second
+	jail := newSlowJail(clock, 3*time.Second, time.Minute, cooloff, 1)
+
+	var blockedAt time.Time
+	jail.OnDecision = func(d Decision) {
+		if !d.Allowed {
+			blockedAt = d.Time
+		}
+	}
+
+	serveJail(jail, makeRequest("1.2.3.4", false))
+	serveJail(jail, makeRequest("1.2.3.4", false))
+
+	// the cooloff runs from when the request arrived, not from when the slow store answered
+	release := jail.Sentences["1.2.3.4"]
+	if !release.Equal(blockedAt.Add(cooloff)) {
+		t.Logf("sentence released at %s, expected %s", release, blockedAt.Add(cooloff))
+		t.Fail()
+	}
+}
+
+func TestSkewedVisitorLogClock(t *testing.T) {
+	window := 10 * time.Second
+	skews := []time.Duration{-2 * time.Second, 2 * time.Second}
+
+	for _, skew := range skews {
+		clock := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+		local := NewDefaultVisitorLog()
+		local.Clock = skewedClock{base: clock, skew: skew}
+		jail := NewJail(local, window, 0, 1)
+		jail.Clock = clock
+
+		if !serveJail(jail, makeRequest("1.2.3.4", false)) {
+			t.Logf("skew %s: first request denied", skew)
+			t.Fail()
+		}
+
+		// skew shifts the edge of the window by at most the skew
+		clock.Advance(window - 3*time.Second)
+		if serveJail(jail, makeRequest("1.2.3.4", false)) {
+			t.Logf("skew %s: request well inside the window allowed", skew)
+			t.Fail()
+		}
+
+		clock.Advance(window + 3*time.Second)
+		if !serveJail(jail, makeRequest("1.2.3.4", false)) {
+			t.Logf("skew %s: request well past the window denied", skew)
+			t.Fail()
+		}
+	}
+}
+
+func TestSteppingClock(t *testing.T) {
+	clock := &steppingClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), step: time.Millisecond}
+	local := NewDefaultVisitorLog()
+	local.Clock = clock
+	jail := NewJail(local, time.Second, 0, 3)
+	jail.Clock = clock
+
+	var decisions []Decision
+	jail.OnDecision = func(d Decision) {
+		decisions = append(decisions, d)
+	}
+
+	for i := 0; i < 4; i++ {
+		serveJail(jail, makeRequest("1.2.3.4", false))
+	}
+
+	for i, d := range decisions {
+		if d.Allowed != (i < 3) {
+			t.Logf("request %d: allowed %t with count %d", i, d.Allowed, d.Count)
+			t.Fail()
+		}
+		if !d.Reset.Equal(d.Time.Add(time.Second)) {
+			t.Logf("request %d: reset %s not one window after the decision at %s", i, d.Reset, d.Time)
+			t.Fail()
+		}
+	}
+}
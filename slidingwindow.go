@@ -0,0 +1,121 @@
+package httpjail
+
+import (
+	"sync"
+	"time"
+)
+
+// SlidingWindowLog approximates a sliding window with two fixed-window counters per visitor, the standard sliding
+// window counter algorithm. The count is the current window's visits plus the previous window's, weighted by how
+// much of the previous window the sliding window still overlaps. It keeps as little as FixedWindowLog without the
+// doubled burst at boundaries, at the cost of assuming visits were spread evenly over the previous window.
+//
+// Under a steady request rate the estimate is within 1 visit of DefaultVisitorLog's exact count. Bursty traffic can
+// be over or under estimated by up to the previous window's count.
+type SlidingWindowLog struct {
+	mux      sync.Mutex
+	window   time.Duration
+	counters map[string]slidingCounter
+	// source of the current time, defaults to the system clock
+	Clock Clock
+}
+
+// slidingCounter is a visitor's counts in the fixed window starting at start and the one before it
+type slidingCounter struct {
+	start    time.Time
+	current  int
+	previous int
+}
+
+// NewSlidingWindowLog instantiates a SlidingWindowLog with the provided window length
+func NewSlidingWindowLog(window time.Duration) *SlidingWindowLog {
+	return &SlidingWindowLog{
+		window:   window,
+		counters: make(map[string]slidingCounter),
+		Clock:    realClock{},
+	}
+}
+
+// advance rolls the counter forward to the fixed window containing now
+func (l *SlidingWindowLog) advance(counter slidingCounter, now time.Time) slidingCounter {
+	start := now.Truncate(l.window)
+	switch {
+	case counter.start.Equal(start):
+		return counter
+	case counter.start.Equal(start.Add(-l.window)):
+		return slidingCounter{start: start, previous: counter.current}
+	default:
+		return slidingCounter{start: start}
+	}
+}
+
+// estimate weights the previous window's count by the share of it still inside the sliding window ending now
+func (l *SlidingWindowLog) estimate(counter slidingCounter, now time.Time) int {
+	overlap := 1 - float64(now.Sub(counter.start))/float64(l.window)
+	return counter.current + int(float64(counter.previous)*overlap)
+}
+
+// LogVisit logs a visitor request in the current window
+func (l *SlidingWindowLog) LogVisit(key string) {
+	l.IncrementAndCount(key, time.Time{})
+}
+
+// IncrementAndCount logs a visitor request and returns the visitor's estimated count. since is ignored, as in
+// CountVisits.
+func (l *SlidingWindowLog) IncrementAndCount(key string, since time.Time) int {
+	now := nowFrom(l.Clock)
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	counter := l.advance(l.counters[key], now)
+	counter.current++
+	l.counters[key] = counter
+	return l.estimate(counter, now)
+}
+
+// CountVisits estimates the visitor's visits in the sliding window ending now. since is ignored, the window length
+// is fixed when the log is created.
+func (l *SlidingWindowLog) CountVisits(key string, since time.Time) int {
+	now := nowFrom(l.Clock)
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	counter, ok := l.counters[key]
+	if !ok {
+		return 0
+	}
+	return l.estimate(l.advance(counter, now), now)
+}
+
+// UnlogVisit takes a visit back out of the visitor's current window
+func (l *SlidingWindowLog) UnlogVisit(key string) {
+	now := nowFrom(l.Clock)
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	counter, ok := l.counters[key]
+	if ok && counter.start.Equal(now.Truncate(l.window)) && counter.current > 0 {
+		counter.current--
+		l.counters[key] = counter
+	}
+}
+
+// Reset drops a visitor's counts
+func (l *SlidingWindowLog) Reset(key string) {
+	l.mux.Lock()
+	delete(l.counters, key)
+	l.mux.Unlock()
+}
+
+// Prune drops every visitor with nothing left in the sliding window. since is ignored, as in CountVisits.
+func (l *SlidingWindowLog) Prune(since time.Time) {
+	now := nowFrom(l.Clock)
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	for key, counter := range l.counters {
+		if counter = l.advance(counter, now); counter.current == 0 && counter.previous == 0 {
+			delete(l.counters, key)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package httpjail
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSlidingWindowLogSteadyRate(t *testing.T) {
+	window := time.Minute
+	// offset so no visit lands exactly on a window boundary
+	clock := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, int(500*time.Millisecond), time.UTC))
+
+	exact := NewDefaultVisitorLog()
+	exact.Clock = clock
+	estimated := NewSlidingWindowLog(window)
+	estimated.Clock = clock
+
+	// one request a second for ten windows
+	for i := 0; i < 600; i++ {
+		exact.LogVisit("1.2.3.4")
+		estimated.LogVisit("1.2.3.4")
+		clock.Advance(time.Second)
+
+		want := exact.CountVisits("1.2.3.4", clock.Now().Add(-window))
+		got := estimated.CountVisits("1.2.3.4", time.Time{})
+		if diff := got - want; diff < -1 || diff > 1 {
+			t.Logf("after %d requests estimated %d visits, exact count %d: off by more than 1", i+1, got, want)
+			t.FailNow()
+		}
+	}
+}
+
+func TestSlidingWindowLogBoundary(t *testing.T) {
+	window := time.Minute
+	allowedRequests := 5
+	// one second before a window boundary, as in TestFixedWindowBoundaryDoubling
+	clock := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 59, 0, time.UTC))
+	visitorLog := NewSlidingWindowLog(window)
+	visitorLog.Clock = clock
+	jail := NewJail(visitorLog, window, 0, allowedRequests)
+	jail.Clock = clock
+
+	// unlike the fixed window, the previous window's burst still weighs on the next
+	if allowed := countBoundaryBurst(jail, clock, allowedRequests); allowed != allowedRequests {
+		t.Logf("sliding window counter allowed %d requests across the boundary, expected %d", allowed,
+			allowedRequests)
+		t.Fail()
+	}
+
+	// a window later the previous burst has aged out entirely
+	clock.Advance(2 * window)
+	if count := visitorLog.CountVisits("1.2.3.4", time.Time{}); count != 0 {
+		t.Logf("estimated %d visits two windows later", count)
+		t.Fail()
+	}
+	visitorLog.Prune(time.Time{})
+	if len(visitorLog.counters) != 0 {
+		t.Logf("%d idle visitors left after pruning", len(visitorLog.counters))
+		t.Fail()
+	}
+}
@@ -0,0 +1,35 @@
+package httpjail
+
+import (
+	"net/http"
+	"time"
+)
+
+// FallibleVisitorLog is implemented by visitor logs backed by a store that can fail, such as a remote database.
+// The jail prefers it over the other VisitorLog methods so store errors are reported instead of being hidden in a
+// zero count, and handles them according to FailOpen and OnStoreError.
+type FallibleVisitorLog interface {
+	TryIncrementAndCount(key string, since time.Time) (int, error)
+	// counts without logging, for decisions that log only some requests or none
+	TryCountVisits(key string, since time.Time) (int, error)
+}
+
+// countVisits counts the visitor's visits without logging one, reporting the store's error if the visitor log can
+// fail
+func (j *Jail) countVisits(key string, since time.Time) (int, error) {
+	if fallible, ok := j.visitors.(FallibleVisitorLog); ok {
+		return fallible.TryCountVisits(key, since)
+	}
+	return j.visitors.CountVisits(key, since), nil
+}
+
+// storeError responds to a request that couldn't be decided because the visitor log's store failed
+func (j *Jail) storeError(w http.ResponseWriter, req *http.Request) {
+	incMetric(j.Metrics, MetricStoreErrors)
+
+	if j.OnStoreError != nil {
+		j.OnStoreError.ServeHTTP(w, req)
+		return
+	}
+	http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+}
@@ -0,0 +1,159 @@
+package httpjail
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// failingVisitorLog is a FallibleVisitorLog whose store is down
+type failingVisitorLog struct {
+	*DefaultVisitorLog
+}
+
+func (failingVisitorLog) TryIncrementAndCount(key string, since time.Time) (int, error) {
+	return 0, errors.New("store unavailable")
+}
+
+func (failingVisitorLog) TryCountVisits(key string, since time.Time) (int, error) {
+	return 0, errors.New("store unavailable")
+}
+
+func TestOnStoreError(t *testing.T) {
+	metrics := newFakeMetrics()
+	jail := NewJail(failingVisitorLog{NewDefaultVisitorLog()}, time.Minute, 0, 10)
+	jail.Metrics = metrics
+	jail.OnStoreError = http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+	})
+
+	var decision Decision
+	jail.OnDecision = func(d Decision) {
+		decision = d
+	}
+
+	reached := false
+	handler := jail.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		reached = true
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, makeRequest("1.2.3.4", false))
+	if reached {
+		t.Log("request reached the handler while failing closed")
+		t.Fail()
+	}
+	if rec.Code != http.StatusServiceUnavailable || rec.Body.String() != "down for maintenance\n" {
+		t.Logf("custom store error handler didn't run: %d %q", rec.Code, rec.Body.String())
+		t.Fail()
+	}
+	if rec.Header().Get(headerRetry) != "" {
+		t.Log("store error response advertised a Retry-After as if rate limited")
+		t.Fail()
+	}
+	if decision.Err == nil || decision.Allowed {
+		t.Logf("decision didn't carry the store error: %+v", decision)
+		t.Fail()
+	}
+	if metrics.counter(MetricStoreErrors) != 1 || metrics.counter(MetricBlockedRequests) != 0 {
+		t.Log("store error counted as a block")
+		t.Fail()
+	}
+
+	// without a handler the response is a plain 503
+	jail.OnStoreError = nil
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, makeRequest("1.2.3.4", false))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Logf("default store error status %d", rec.Code)
+		t.Fail()
+	}
+
+	// failing open passes requests through
+	jail.FailOpen = true
+	if !serveJail(jail, makeRequest("1.2.3.4", false)) {
+		t.Log("request denied while failing open")
+		t.Fail()
+	}
+}
+
+func TestFailOpen(t *testing.T) {
+	for _, failOpen := range []bool{false, true} {
+		jail := NewJail(failingVisitorLog{NewDefaultVisitorLog()}, time.Minute, time.Hour, 1)
+		jail.FailOpen = failOpen
+
+		expected := http.StatusServiceUnavailable
+		if failOpen {
+			expected = http.StatusOK
+		}
+		handler := jail.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+		for i := 0; i < 3; i++ {
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, makeRequest("1.2.3.4", false))
+			if rec.Code != expected {
+				t.Logf("FailOpen %v: request %d with the store down got %d, expected %d", failOpen, i, rec.Code, expected)
+				t.Fail()
+			}
+		}
+
+		if allowed, retryAfter := jail.Allow(makeRequest("1.2.3.4", false)); allowed != failOpen || retryAfter != 0 {
+			t.Logf("FailOpen %v: Allow with the store down returned %v, %s", failOpen, allowed, retryAfter)
+			t.Fail()
+		}
+		// store errors never sentence, whichever way the jail fails
+		if len(jail.Sentences) != 0 {
+			t.Logf("FailOpen %v: store errors sentenced the client", failOpen)
+			t.Fail()
+		}
+	}
+}
+
+func TestFailOpenEveryMode(t *testing.T) {
+	modes := map[string]func(jail *Jail){
+		"LogAllowedOnly": func(jail *Jail) { jail.LogAllowedOnly = true },
+		"LeadingEdge":    func(jail *Jail) { jail.LeadingEdge = true },
+		"ErrorBudget":    func(jail *Jail) { jail.ErrorBudget = 3 },
+		"ExtraLimits": func(jail *Jail) {
+			jail.LogAllowedOnly = true
+			jail.ExtraLimits = []Limit{{AllowedRequests: 10, Window: time.Second}}
+		},
+		"IdempotencyWindow": func(jail *Jail) { jail.IdempotencyWindow = time.Minute },
+	}
+
+	for name, configure := range modes {
+		for _, failOpen := range []bool{false, true} {
+			jail := NewJail(failingVisitorLog{NewDefaultVisitorLog()}, time.Minute, 0, 10)
+			jail.FailOpen = failOpen
+			configure(jail)
+
+			var decision Decision
+			jail.OnDecision = func(d Decision) {
+				decision = d
+			}
+			served := 0
+			for i := 0; i < 5; i++ {
+				req := makeRequest("1.2.3.4", false)
+				req.Header.Set("Idempotency-Key", "same")
+				if serveJail(jail, req) {
+					served++
+				}
+				if decision.Err == nil {
+					t.Logf("%s, FailOpen %v: request %d decided without the store error", name, failOpen, i)
+					t.Fail()
+				}
+			}
+
+			expected := 0
+			if failOpen {
+				expected = 5
+			}
+			if served != expected {
+				t.Logf("%s, FailOpen %v: %d of 5 requests served with the store down, expected %d", name, failOpen,
+					served, expected)
+				t.Fail()
+			}
+		}
+	}
+}
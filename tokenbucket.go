@@ -0,0 +1,144 @@
+package httpjail
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// TokenBucketLog limits visitors with a token bucket instead of a window: each visitor's bucket holds up to
+// capacity tokens, refills at a steady rate, and every request takes a token. Visitors can burst up to the
+// capacity, then are held to the refill rate. Requests that find the bucket empty take nothing, so a blocked client
+// gets in as soon as the next token arrives.
+//
+// The jail sees tokens taken as visits: with AllowedRequests equal to the capacity, a request is allowed exactly
+// when a token was available. NewTokenBucketJail sets that up.
+type TokenBucketLog struct {
+	mux      sync.Mutex
+	capacity int
+	refill   int
+	interval time.Duration
+	buckets  map[string]tokenBucket
+	// source of the current time, defaults to the system clock
+	Clock Clock
+}
+
+// tokenBucket is a visitor's tokens as of updated
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// NewTokenBucketLog instantiates a TokenBucketLog whose buckets hold capacity tokens and gain refill tokens every
+// interval
+func NewTokenBucketLog(capacity, refill int, interval time.Duration) *TokenBucketLog {
+	return &TokenBucketLog{
+		capacity: capacity,
+		refill:   refill,
+		interval: interval,
+		buckets:  make(map[string]tokenBucket),
+		Clock:    realClock{},
+	}
+}
+
+// NewTokenBucketJail creates a jail limiting each visitor to bursts of capacity requests, refilled at refill
+// requests every interval. Blocked requests get the usual 429 with Retry-After set to when the next token arrives.
+func NewTokenBucketJail(capacity, refill int, interval time.Duration, opts ...Option) *Jail {
+	return NewJail(NewTokenBucketLog(capacity, refill, interval), interval, 0, capacity, opts...)
+}
+
+// fill returns the visitor's bucket with the tokens it has gained since it was last updated
+func (l *TokenBucketLog) fill(key string, now time.Time) tokenBucket {
+	bucket, ok := l.buckets[key]
+	if !ok {
+		return tokenBucket{tokens: float64(l.capacity), updated: now}
+	}
+
+	if elapsed := now.Sub(bucket.updated); elapsed > 0 {
+		bucket.tokens += float64(l.refill) * float64(elapsed) / float64(l.interval)
+		bucket.tokens = math.Min(bucket.tokens, float64(l.capacity))
+		bucket.updated = now
+	}
+	return bucket
+}
+
+// taken returns the count the jail sees for a bucket: the tokens taken from it
+func (l *TokenBucketLog) taken(bucket tokenBucket) int {
+	return l.capacity - int(bucket.tokens)
+}
+
+// LogVisit takes a token from the visitor's bucket, if one is available
+func (l *TokenBucketLog) LogVisit(key string) {
+	l.IncrementAndCount(key, time.Time{})
+}
+
+// IncrementAndCount takes a token from the visitor's bucket and returns the tokens taken, or capacity + 1 if the
+// bucket was empty. since is ignored, buckets have no window.
+func (l *TokenBucketLog) IncrementAndCount(key string, since time.Time) int {
+	now := nowFrom(l.Clock)
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	bucket := l.fill(key, now)
+	if bucket.tokens < 1 {
+		l.buckets[key] = bucket
+		return l.capacity + 1
+	}
+	bucket.tokens--
+	l.buckets[key] = bucket
+	return l.taken(bucket)
+}
+
+// CountVisits returns the tokens taken from the visitor's bucket and not yet refilled. since is ignored, buckets
+// have no window.
+func (l *TokenBucketLog) CountVisits(key string, since time.Time) int {
+	now := nowFrom(l.Clock)
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	return l.taken(l.fill(key, now))
+}
+
+// ResetAt returns when the visitor's bucket will be full again
+func (l *TokenBucketLog) ResetAt(key string, now time.Time) time.Time {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	missing := float64(l.capacity) - l.fill(key, now).tokens
+	return now.Add(l.refillTime(missing))
+}
+
+// nextAllowed returns when the visitor's bucket will next hold a whole token
+func (l *TokenBucketLog) nextAllowed(key string, now time.Time) time.Time {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	missing := 1 - l.fill(key, now).tokens
+	if missing <= 0 {
+		return now
+	}
+	return now.Add(l.refillTime(missing))
+}
+
+// refillTime returns how long the bucket takes to gain the given number of tokens
+func (l *TokenBucketLog) refillTime(tokens float64) time.Duration {
+	return time.Duration(math.Ceil(tokens * float64(l.interval) / float64(l.refill)))
+}
+
+// Reset refills a visitor's bucket
+func (l *TokenBucketLog) Reset(key string) {
+	l.mux.Lock()
+	delete(l.buckets, key)
+	l.mux.Unlock()
+}
+
+// Prune drops every visitor whose bucket has refilled, since a full bucket is the same as none. since is ignored.
+func (l *TokenBucketLog) Prune(since time.Time) {
+	now := nowFrom(l.Clock)
+
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	for key := range l.buckets {
+		if l.fill(key, now).tokens >= float64(l.capacity) {
+			delete(l.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package httpjail
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketJail(t *testing.T) {
+	clock := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	// bursts of 5, refilled at 1 request every 2 seconds
+	jail := NewTokenBucketJail(5, 1, 2*time.Second)
+	jail.Clock = clock
+	jail.visitors.(*TokenBucketLog).Clock = clock
+
+	handler := jail.Middleware(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {}))
+	serve := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, makeRequest("1.2.3.4", false))
+		return w
+	}
+
+	for i := 1; i <= 5; i++ {
+		if w := serve(); w.Code != http.StatusOK {
+			t.Logf("burst request %d got %d", i, w.Code)
+			t.Fail()
+		}
+	}
+
+	w := serve()
+	if w.Code != http.StatusTooManyRequests {
+		t.Logf("request past the capacity got %d", w.Code)
+		t.FailNow()
+	}
+	if retry := w.Header().Get("Retry-After"); retry != "2" {
+		t.Logf("Retry-After %q, expected the 2 seconds to the next token", retry)
+		t.Fail()
+	}
+	if remaining := w.Header().Get(headerRemaining); remaining != "0" {
+		t.Logf("remaining %q on a blocked request", remaining)
+		t.Fail()
+	}
+
+	// blocked requests take nothing, so the next token lets the client straight back in
+	clock.Advance(time.Second)
+	serve()
+	clock.Advance(time.Second)
+	if w := serve(); w.Code != http.StatusOK {
+		t.Logf("request after a token refilled got %d", w.Code)
+		t.Fail()
+	}
+	if w := serve(); w.Code != http.StatusTooManyRequests {
+		t.Logf("second request on one refilled token got %d", w.Code)
+		t.Fail()
+	}
+
+	// a long pause refills the bucket only up to its capacity
+	clock.Advance(time.Hour)
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if serve().Code == http.StatusOK {
+			allowed++
+		}
+	}
+	if allowed != 5 {
+		t.Logf("%d requests allowed after an hour idle, expected the capacity of 5", allowed)
+		t.Fail()
+	}
+}
+
+func TestTokenBucketLogReset(t *testing.T) {
+	clock := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	visitorLog := NewTokenBucketLog(4, 2, time.Second)
+	visitorLog.Clock = clock
+
+	for i := 0; i < 3; i++ {
+		visitorLog.LogVisit("1.2.3.4")
+	}
+	if count := visitorLog.CountVisits("1.2.3.4", time.Time{}); count != 3 {
+		t.Logf("%d tokens taken, expected 3", count)
+		t.Fail()
+	}
+	// 3 tokens at 2 a second
+	if reset := visitorLog.ResetAt("1.2.3.4", clock.Now()); !reset.Equal(clock.Now().Add(1500 * time.Millisecond)) {
+		t.Logf("bucket full at %v, expected in 1.5s", reset)
+		t.Fail()
+	}
+
+	clock.Advance(2 * time.Second)
+	visitorLog.Prune(time.Time{})
+	if len(visitorLog.buckets) != 0 {
+		t.Logf("%d full buckets left after pruning", len(visitorLog.buckets))
+		t.Fail()
+	}
+}
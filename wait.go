@@ -0,0 +1,83 @@
+package httpjail
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrQueueFull is returned by Wait when MaxWaiters or MaxTotalWaiters callers are already waiting
+var ErrQueueFull = errors.New("httpjail: wait queue full")
+
+// minWaitPoll is the shortest interval between Wait's checks of the visitor's budget
+const minWaitPoll = 10 * time.Millisecond
+
+// Wait blocks until the visitor key may make a request under the jail's default limit, then counts the request,
+// for callers that would rather queue than be rejected, such as outbound clients limiting themselves. It returns
+// ctx's error if ctx is done first, or ErrQueueFull straight away if the wait queue is full.
+//
+// Wait checks the budget and logs the visit in two steps, so concurrent callers for one key can occasionally be
+// admitted together.
+func (j *Jail) Wait(ctx context.Context, key string) error {
+	if !j.enqueueWaiter(key) {
+		return ErrQueueFull
+	}
+	defer j.dequeueWaiter(key)
+
+	for {
+		now := j.now()
+		window := j.window()
+		j.limitsMux.RLock()
+		allowed := j.AllowedRequests
+		j.limitsMux.RUnlock()
+
+		j.releaseExpired(key, now)
+		if !j.isSentenced(key, now) && j.visitors.CountVisits(key, now.Add(-window)) < allowed {
+			j.visitors.LogVisit(key)
+			return nil
+		}
+
+		poll := window / time.Duration(allowed+1)
+		if poll < minWaitPoll {
+			poll = minWaitPoll
+		}
+		timer := time.NewTimer(poll)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// enqueueWaiter registers a waiting caller for the key, reporting false if the per-key or global cap is reached
+func (j *Jail) enqueueWaiter(key string) bool {
+	j.mux.Lock()
+	defer j.mux.Unlock()
+
+	if j.MaxWaiters > 0 && j.waiters[key] >= j.MaxWaiters {
+		return false
+	}
+	if j.MaxTotalWaiters > 0 && j.totalWaiters >= j.MaxTotalWaiters {
+		return false
+	}
+
+	if j.waiters == nil {
+		j.waiters = make(map[string]int)
+	}
+	j.waiters[key]++
+	j.totalWaiters++
+	return true
+}
+
+// dequeueWaiter unregisters a waiting caller for the key
+func (j *Jail) dequeueWaiter(key string) {
+	j.mux.Lock()
+	defer j.mux.Unlock()
+
+	j.totalWaiters--
+	if j.waiters[key]--; j.waiters[key] <= 0 {
+		delete(j.waiters, key)
+	}
+}
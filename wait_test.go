@@ -0,0 +1,115 @@
+package httpjail
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWait(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	jail := NewJailForTesting(clock, time.Second, 0, 2)
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := jail.Wait(ctx, "client"); err != nil {
+			t.Logf("wait %d under the limit: %s", i, err)
+			t.Fail()
+		}
+	}
+
+	// over the limit the caller waits until the window moves on
+	done := make(chan error)
+	go func() {
+		done <- jail.Wait(ctx, "client")
+	}()
+	select {
+	case err := <-done:
+		t.Logf("wait over the limit returned early: %v", err)
+		t.FailNow()
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	clock.Advance(2 * time.Second)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Log(err)
+			t.Fail()
+		}
+	case <-time.After(5 * time.Second):
+		t.Log("waiter not admitted after the window passed")
+		t.Fail()
+	}
+
+	// a cancelled wait returns the context's error
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	jail.Wait(ctx, "client")
+	if err := jail.Wait(ctx, "client"); err != context.DeadlineExceeded {
+		t.Logf("cancelled wait returned %v", err)
+		t.Fail()
+	}
+}
+
+func TestWaitQueueFull(t *testing.T) {
+	// a zero limit never admits anyone, so waiters pile up until cancelled
+	jail := NewJailForTesting(NewFakeClock(time.Now()), time.Second, 0, 0)
+	jail.MaxWaiters = 3
+	jail.MaxTotalWaiters = 5
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	wait := func(key string) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			jail.Wait(ctx, key)
+		}()
+	}
+	queued := func() int {
+		jail.mux.Lock()
+		defer jail.mux.Unlock()
+		return jail.totalWaiters
+	}
+	fill := func(want int) {
+		deadline := time.Now().Add(5 * time.Second)
+		for queued() < want && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		wait("a")
+	}
+	fill(3)
+
+	start := time.Now()
+	if err := jail.Wait(context.Background(), "a"); err != ErrQueueFull {
+		t.Logf("waiter past the per-key cap got %v, expected ErrQueueFull", err)
+		t.Fail()
+	}
+
+	for i := 0; i < 2; i++ {
+		wait(fmt.Sprintf("b%d", i))
+	}
+	fill(5)
+
+	if err := jail.Wait(context.Background(), "c"); err != ErrQueueFull {
+		t.Logf("waiter past the global cap got %v, expected ErrQueueFull", err)
+		t.Fail()
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Logf("rejected waiters took %s, expected an immediate rejection", elapsed)
+		t.Fail()
+	}
+
+	cancel()
+	wg.Wait()
+	if queued() != 0 || len(jail.waiters) != 0 {
+		t.Logf("waiters not released: %d total, %v", queued(), jail.waiters)
+		t.Fail()
+	}
+}